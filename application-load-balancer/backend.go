@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthConfig controls the active health checks and the passive outlier
+// detection of the backends.
+type HealthConfig struct {
+	// Path is requested on every backend each Interval. A backend is healthy
+	// when it answers with a 2xx or 3xx status within Timeout.
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// HealthyThreshold and UnhealthyThreshold are the number of consecutive
+	// checks that must pass or fail to change the health of a backend.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+
+	// ConsecutiveFailures is the number of consecutive 5xx responses or
+	// connection errors after which a backend is ejected. Ejections last
+	// BaseEjectionTime times the number of times the backend was ejected,
+	// up to MaxEjectionTime. The count drops by one for each
+	// BaseEjectionTime the backend spends without being ejected.
+	ConsecutiveFailures int
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
+}
+
+var defaultHealthConfig = HealthConfig{
+	Path:                "/",
+	Interval:            5 * time.Second,
+	Timeout:             2 * time.Second,
+	HealthyThreshold:    2,
+	UnhealthyThreshold:  2,
+	ConsecutiveFailures: 5,
+	BaseEjectionTime:    30 * time.Second,
+	MaxEjectionTime:     5 * time.Minute,
+}
+
+// Backend is a server that requests are proxied to.
+type Backend struct {
+	URL *url.URL
+	// weight is the share of the requests sent to the backend by the
+	// weighted strategies, relative to the other backends. It can change
+	// when the config is reloaded.
+	weight atomic.Int64
+	// breaker is the circuit breaker of the backend, which is never nil.
+	breaker *Breaker
+	// outstanding counts the requests in flight to the backend, for the
+	// strategies that balance them.
+	outstanding atomic.Int64
+
+	mu sync.Mutex
+	// healthy is the result of the active health checks. Backends start
+	// healthy, so that traffic flows before the first checks complete.
+	healthy bool
+	// checkStreak counts the consecutive checks that disagree with healthy.
+	checkStreak int
+	lastCheck   time.Time
+	lastError   string
+	// failures counts the consecutive failed requests.
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+	// lastDecay is when ejections last dropped.
+	lastDecay time.Time
+}
+
+// NewBackend returns a backend at rawURL, with a circuit breaker configured by
+// breaker.
+func NewBackend(rawURL string, breaker BreakerConfig) (*Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend url %q: %w", rawURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid backend url %q: missing scheme or host", rawURL)
+	}
+	b := &Backend{URL: u, breaker: NewBreaker(u.String(), breaker), healthy: true}
+	b.weight.Store(1)
+	return b, nil
+}
+
+func (b *Backend) Weight() int {
+	return int(b.weight.Load())
+}
+
+func (b *Backend) SetWeight(weight int) {
+	b.weight.Store(int64(weight))
+}
+
+// Available reports whether the backend can receive requests, i.e. it passes
+// its health checks, isn't ejected, and its circuit isn't open.
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available(time.Now())
+}
+
+// available is Available with b.mu held.
+func (b *Backend) available(now time.Time) bool {
+	return b.healthy && !now.Before(b.ejectedUntil) && !b.breaker.Blocked(now)
+}
+
+// BackendState reports the state of a backend.
+type BackendState struct {
+	URL          string     `json:"url"`
+	Weight       int        `json:"weight"`
+	Available    bool       `json:"available"`
+	Healthy      bool       `json:"healthy"`
+	Circuit      string     `json:"circuit"`
+	LastCheck    *time.Time `json:"last_check,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Failures     int        `json:"consecutive_failures"`
+	Ejections    int        `json:"ejections"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+func (b *Backend) State() BackendState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	state := BackendState{
+		URL:       b.URL.String(),
+		Weight:    b.Weight(),
+		Available: b.available(now),
+		Healthy:   b.healthy,
+		LastError: b.lastError,
+		Failures:  b.failures,
+		Ejections: b.ejections,
+		Circuit:   b.breaker.State(),
+	}
+	if !b.lastCheck.IsZero() {
+		lastCheck := b.lastCheck
+		state.LastCheck = &lastCheck
+	}
+	if now.Before(b.ejectedUntil) {
+		ejectedUntil := b.ejectedUntil
+		state.EjectedUntil = &ejectedUntil
+	}
+	return state
+}
+
+// Pool is a set of backends, which are health checked in the background and
+// ejected when they fail too many requests in a row.
+type Pool struct {
+	backends []*Backend
+	cfg      HealthConfig
+	client   *http.Client
+}
+
+func NewPool(backends []*Backend, cfg HealthConfig) (*Pool, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends provided")
+	}
+	return &Pool{
+		backends: backends,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+func (p *Pool) States() []BackendState {
+	states := make([]BackendState, 0, len(p.backends))
+	for _, b := range p.backends {
+		states = append(states, b.State())
+	}
+	return states
+}
+
+// StartHealthChecks checks every backend each interval, until ctx is done.
+func (p *Pool) StartHealthChecks(ctx context.Context) {
+	for _, b := range p.backends {
+		go func() {
+			ticker := time.NewTicker(p.cfg.Interval)
+			defer ticker.Stop()
+
+			for {
+				p.check(ctx, b)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// check requests the health check path of b, and flips its health once
+// enough consecutive checks disagree with it.
+func (p *Pool) check(ctx context.Context, b *Backend) {
+	err := p.probe(ctx, b)
+	if ctx.Err() != nil {
+		// The checks were stopped, e.g. by a reload, which says nothing about
+		// the backend.
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastCheck = time.Now()
+	b.lastError = ""
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	passed := err == nil
+	if passed == b.healthy {
+		b.checkStreak = 0
+		return
+	}
+	b.checkStreak++
+
+	threshold := p.cfg.UnhealthyThreshold
+	if passed {
+		threshold = p.cfg.HealthyThreshold
+	}
+	if b.checkStreak >= threshold {
+		b.healthy = passed
+		b.checkStreak = 0
+		if passed {
+			log.Printf("backend %s is healthy", b.URL)
+		} else {
+			log.Printf("backend %s is unhealthy: %v", b.URL, err)
+		}
+	}
+}
+
+func (p *Pool) probe(ctx context.Context, b *Backend) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL.JoinPath(p.cfg.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Report records the outcome of a request proxied to b: the status of the
+// response, or the error if the backend couldn't be reached. b is ejected
+// after too many consecutive failures, unless it's the last available backend
+// of the pool, since failing requests beat having no backend at all.
+func (p *Pool) Report(b *Backend, status int, err error) {
+	failed := err != nil || status >= 500
+
+	b.mu.Lock()
+	b.decayEjections(time.Now(), p.cfg.BaseEjectionTime)
+	if !failed {
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+	b.failures++
+	eject := b.failures >= p.cfg.ConsecutiveFailures
+	b.mu.Unlock()
+
+	if !eject || !p.hasOtherAvailable(b) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if !b.available(now) {
+		// Another request ejected it meanwhile.
+		return
+	}
+	b.ejections++
+	b.failures = 0
+	duration := min(p.cfg.BaseEjectionTime*time.Duration(b.ejections), p.cfg.MaxEjectionTime)
+	b.ejectedUntil = now.Add(duration)
+	log.Printf("ejecting backend %s for %s after %d consecutive failures", b.URL, duration, p.cfg.ConsecutiveFailures)
+}
+
+// decayEjections forgets an ejection of b for each period spent without being
+// ejected, so that a backend that recovered isn't ejected for long by its next
+// failures. b.mu must be held.
+func (b *Backend) decayEjections(now time.Time, period time.Duration) {
+	if b.ejections == 0 || period <= 0 || now.Before(b.ejectedUntil) {
+		return
+	}
+	since := b.ejectedUntil
+	if b.lastDecay.After(since) {
+		since = b.lastDecay
+	}
+	n := int(now.Sub(since) / period)
+	if n == 0 {
+		return
+	}
+	b.ejections = max(b.ejections-n, 0)
+	b.lastDecay = since.Add(period * time.Duration(n))
+}
+
+func (p *Pool) hasOtherAvailable(b *Backend) bool {
+	for _, other := range p.backends {
+		if other != b && other.Available() {
+			return true
+		}
+	}
+	return false
+}
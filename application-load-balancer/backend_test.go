@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDecayEjections(t *testing.T) {
+	const period = 30 * time.Second
+	ejectedUntil := time.Now()
+
+	tests := []struct {
+		name      string
+		ejections int
+		elapsed   time.Duration
+		expected  int
+	}{
+		{"still ejected", 3, -time.Second, 3},
+		{"less than a period", 3, period - time.Second, 3},
+		{"one period", 3, period, 2},
+		{"two periods", 3, 2*period + time.Second, 1},
+		{"many periods", 3, 10 * period, 0},
+		{"never ejected", 0, 10 * period, 0},
+	}
+
+	for _, tt := range tests {
+		b := &Backend{ejections: tt.ejections, ejectedUntil: ejectedUntil}
+		b.decayEjections(ejectedUntil.Add(tt.elapsed), period)
+		if b.ejections != tt.expected {
+			t.Errorf("%s: expected %d ejections, got %d", tt.name, tt.expected, b.ejections)
+		}
+	}
+}
+
+func TestDecayEjectionsIncrementally(t *testing.T) {
+	const period = 30 * time.Second
+	now := time.Now()
+	b := &Backend{ejections: 3, ejectedUntil: now}
+
+	// Checking often doesn't lose the time elapsed since the last decay.
+	for range 4 {
+		now = now.Add(20 * time.Second)
+		b.decayEjections(now, period)
+	}
+	if b.ejections != 1 {
+		t.Errorf("expected 1 ejection after 80s, got %d", b.ejections)
+	}
+}
+
+func TestCheckIgnoresStoppedChecks(t *testing.T) {
+	cfg := defaultHealthConfig
+	cfg.UnhealthyThreshold = 1
+	b, err := NewBackend("http://127.0.0.1:1", defaultBreakerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPool([]*Backend{b}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.check(ctx, b)
+	if state := b.State(); !state.Healthy || state.LastError != "" || state.LastCheck != nil {
+		t.Errorf("expected a stopped check not to be recorded, got %+v", state)
+	}
+
+	// A check that fails on its own is recorded.
+	p.check(context.Background(), b)
+	if state := b.State(); state.Healthy || state.LastError == "" {
+		t.Errorf("expected a failed check to mark the backend unhealthy, got %+v", state)
+	}
+}
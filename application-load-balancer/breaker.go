@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// BreakerConfig configures the circuit breaker of each backend of a route.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failed requests after which the
+	// circuit opens.
+	Failures int `json:"failures,omitempty"`
+	// OpenTime is how long the circuit stays open before a request is let
+	// through to probe the backend.
+	OpenTime Duration `json:"open_time,omitempty"`
+}
+
+var defaultBreakerConfig = BreakerConfig{
+	Failures: 5,
+	OpenTime: Duration(10 * time.Second),
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is the circuit breaker of a backend. It opens after too many
+// consecutive failures, and rejects the requests until OpenTime has passed.
+// It then half-opens, and lets a single request through: the circuit closes
+// if it succeeds, and opens again otherwise.
+//
+// Unlike the outlier ejection of the pool, which is lifted after a fixed time,
+// a backend only gets traffic again once a probe succeeded.
+type Breaker struct {
+	name string
+	cfg  BreakerConfig
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+	// probing is set while the request probing a half-open circuit is in
+	// flight.
+	probing bool
+}
+
+func NewBreaker(name string, cfg BreakerConfig) *Breaker {
+	return &Breaker{name: name, cfg: cfg}
+}
+
+// SetConfig changes the config of the breaker, e.g. when the config is
+// reloaded. It applies from the next failure.
+func (b *Breaker) SetConfig(cfg BreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg
+}
+
+// Blocked reports whether Allow would reject a request, without claiming the
+// probe of a half-open circuit.
+func (b *Breaker) Blocked(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return now.Before(b.openUntil)
+	case breakerHalfOpen:
+		return b.probing
+	default:
+		return false
+	}
+}
+
+// Allow reports whether a request may be sent to the backend. If the circuit
+// is half-open, the request is the probe, and its outcome must be passed to
+// Record or Abort.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record records the outcome of a request allowed by Allow.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.Failures {
+			b.open()
+			log.Printf("circuit of backend %s opened after %d consecutive failures", b.name, b.failures)
+		}
+	case breakerHalfOpen:
+		b.probing = false
+		if success {
+			b.state = breakerClosed
+			b.failures = 0
+			log.Printf("circuit of backend %s closed", b.name)
+		} else {
+			b.open()
+			log.Printf("circuit of backend %s opened again after a failed probe", b.name)
+		}
+	case breakerOpen:
+		// The request was allowed before the circuit opened.
+	}
+}
+
+// Abort releases a request allowed by Allow whose outcome says nothing about
+// the backend, e.g. because the client canceled it.
+func (b *Breaker) Abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
+
+// open is called with b.mu held.
+func (b *Breaker) open() {
+	b.state = breakerOpen
+	b.openUntil = time.Now().Add(time.Duration(b.cfg.OpenTime))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const openTime = 20 * time.Millisecond
+	type step struct {
+		// action is one of allow, reject, success, failure, abort and wait.
+		action string
+		state  string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens after consecutive failures", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "open"},
+			{"reject", "open"},
+		}},
+		{"success resets the failures", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"success", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+		}},
+		{"half-opens after the open time with a single probe", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "open"},
+			{"wait", "open"},
+			{"allow", "half-open"}, {"reject", "half-open"},
+		}},
+		{"successful probe closes", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "open"},
+			{"wait", "open"},
+			{"allow", "half-open"}, {"success", "closed"},
+			{"allow", "closed"}, {"allow", "closed"},
+		}},
+		{"failed probe opens again", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "open"},
+			{"wait", "open"},
+			{"allow", "half-open"}, {"failure", "open"},
+			{"reject", "open"},
+		}},
+		{"aborted probe is released", []step{
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "closed"},
+			{"allow", "closed"}, {"failure", "open"},
+			{"wait", "open"},
+			{"allow", "half-open"}, {"abort", "half-open"},
+			{"allow", "half-open"}, {"reject", "half-open"},
+		}},
+	}
+
+	for _, tt := range tests {
+		b := NewBreaker("test", BreakerConfig{Failures: 3, OpenTime: Duration(openTime)})
+		for i, s := range tt.steps {
+			switch s.action {
+			case "allow":
+				if !b.Allow() {
+					t.Fatalf("%s: step %d: expected the request to be allowed", tt.name, i)
+				}
+			case "reject":
+				if b.Allow() {
+					t.Fatalf("%s: step %d: expected the request to be rejected", tt.name, i)
+				}
+				if !b.Blocked(time.Now()) {
+					t.Fatalf("%s: step %d: expected Blocked to agree with Allow", tt.name, i)
+				}
+			case "success":
+				b.Record(true)
+			case "failure":
+				b.Record(false)
+			case "abort":
+				b.Abort()
+			case "wait":
+				time.Sleep(openTime)
+			}
+			if state := b.State(); state != s.state {
+				t.Fatalf("%s: step %d (%s): expected state %s, got %s", tt.name, i, s.action, s.state, state)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds the requests of the routes without a timeout.
+const defaultTimeout = Duration(30 * time.Second)
+
+// Config is the routing configuration of the load balancer, loaded from a
+// JSON file such as:
+//
+//	{
+//	  "routes": [
+//	    {
+//	      "name": "api",
+//	      "host": "api.example.com",
+//	      "path_prefix": "/v1/",
+//	      "rewrite_prefix": "/",
+//	      "headers": {"X-Canary": "true"},
+//	      "strategy": {"name": "least-requests"},
+//	      "timeout": "30s",
+//	      "try_timeout": "5s",
+//	      "retries": {"attempts": 3, "budget": 0.2},
+//	      "circuit_breaker": {"failures": 5, "open_time": "10s"},
+//	      "backends": [{"url": "http://localhost:8081", "weight": 2}]
+//	    }
+//	  ]
+//	}
+type Config struct {
+	// Routes are matched in order, so the more specific ones go first.
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig matches requests and sends them to a pool of backends. A
+// request matches when it matches every matcher that is set.
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Host matches the host of the request, ignoring the port. A host
+	// starting with "*." matches the subdomains of the rest of the host.
+	Host string `json:"host,omitempty"`
+	// PathPrefix matches the paths starting with it, "/" by default.
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// Headers match the requests whose headers have these values.
+	Headers map[string]string `json:"headers,omitempty"`
+	// RewritePrefix replaces PathPrefix in the path sent to the backends, if
+	// set.
+	RewritePrefix *string `json:"rewrite_prefix,omitempty"`
+
+	Strategy StrategyConfig  `json:"strategy"`
+	Backends []BackendConfig `json:"backends"`
+
+	// Timeout bounds the whole request, retries included, 30s by default.
+	Timeout Duration `json:"timeout,omitempty"`
+	// TryTimeout bounds each attempt until the response headers are
+	// received, so that a slow backend can be retried. It's unbounded by
+	// default.
+	TryTimeout     Duration      `json:"try_timeout,omitempty"`
+	Retries        RetryConfig   `json:"retries"`
+	CircuitBreaker BreakerConfig `json:"circuit_breaker"`
+}
+
+type BackendConfig struct {
+	URL string `json:"url"`
+	// Weight is 1 by default.
+	Weight int `json:"weight,omitempty"`
+}
+
+// LoadConfig reads and validates the configuration in the file at path.
+// Unknown fields are rejected, so that a typo doesn't silently drop a
+// matcher.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var cfg Config
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Duration is a time.Duration written as a string such as "1.5s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	if len(cfg.Routes) == 0 {
+		return errors.New("no routes")
+	}
+
+	names := make(map[string]bool, len(cfg.Routes))
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("duplicate route %q", route.Name)
+		}
+		names[route.Name] = true
+
+		if route.PathPrefix == "" {
+			route.PathPrefix = "/"
+		}
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("route %q: path prefix %q doesn't start with /", route.Name, route.PathPrefix)
+		}
+		if route.RewritePrefix != nil && !strings.HasPrefix(*route.RewritePrefix, "/") {
+			return fmt.Errorf("route %q: rewrite prefix %q doesn't start with /", route.Name, *route.RewritePrefix)
+		}
+		if route.Strategy.Name == "" {
+			route.Strategy.Name = "round-robin"
+		}
+		if route.Timeout == 0 {
+			route.Timeout = defaultTimeout
+		}
+		if route.Timeout < 0 || route.TryTimeout < 0 {
+			return fmt.Errorf("route %q: negative timeout", route.Name)
+		}
+		if route.Retries.Attempts == 0 {
+			route.Retries.Attempts = defaultRetryConfig.Attempts
+		}
+		if route.Retries.Budget == 0 {
+			route.Retries.Budget = defaultRetryConfig.Budget
+		}
+		if route.Retries.Attempts < 0 || route.Retries.Budget < 0 {
+			return fmt.Errorf("route %q: negative retry attempts or budget", route.Name)
+		}
+		if route.CircuitBreaker.Failures == 0 {
+			route.CircuitBreaker.Failures = defaultBreakerConfig.Failures
+		}
+		if route.CircuitBreaker.OpenTime == 0 {
+			route.CircuitBreaker.OpenTime = defaultBreakerConfig.OpenTime
+		}
+		if route.CircuitBreaker.Failures < 0 || route.CircuitBreaker.OpenTime < 0 {
+			return fmt.Errorf("route %q: negative circuit breaker failures or open time", route.Name)
+		}
+		if len(route.Backends) == 0 {
+			return fmt.Errorf("route %q: no backends", route.Name)
+		}
+		for j := range route.Backends {
+			backend := &route.Backends[j]
+			if backend.Weight == 0 {
+				backend.Weight = 1
+			}
+			if backend.Weight < 0 {
+				return fmt.Errorf("route %q: negative weight for backend %s", route.Name, backend.URL)
+			}
+		}
+	}
+	return nil
+}
@@ -1,79 +1,140 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"os"
+	"os/signal"
 	"sync/atomic"
+	"syscall"
 )
 
 const (
 	addr = ":8080"
 )
 
-// Define the backend servers to load balance across
-var backends = []string{
-	"http://localhost:8081",
-	"http://localhost:8082",
-}
-
 func main() {
-	strategy, err := NewRoundRobinStrategy(backends)
-	if err != nil {
-		log.Fatalf("error creating round robin strategy: %v", err)
+	configPath := flag.String("config", "config.json", "path of the routing config, reloaded on SIGHUP")
+	adminAddr := flag.String("admin-addr", ":9090", "address of the admin endpoints")
+	health := defaultHealthConfig
+	flag.StringVar(&health.Path, "health-path", health.Path, "path requested by the health checks")
+	flag.DurationVar(&health.Interval, "health-interval", health.Interval, "delay between two health checks of a backend")
+	flag.DurationVar(&health.Timeout, "health-timeout", health.Timeout, "timeout of a health check")
+	flag.IntVar(&health.ConsecutiveFailures, "eject-after", health.ConsecutiveFailures, "number of consecutive failed requests after which a backend is ejected")
+	flag.DurationVar(&health.BaseEjectionTime, "ejection-time", health.BaseEjectionTime, "duration of the first ejection of a backend, which grows with each ejection")
+	flag.Parse()
+
+	proxy := NewProxy(*configPath, health)
+	if err := proxy.Reload(); err != nil {
+		log.Fatalf("error loading config: %v", err)
 	}
-	proxy := NewProxy(strategy)
+
+	// A bad config is logged and the current routes are kept, so that a
+	// reload can't take the load balancer down.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := proxy.Reload(); err != nil {
+				log.Printf("error reloading config: %v", err)
+			}
+		}
+	}()
+
+	// The admin endpoints have their own address, so that they don't shadow
+	// the paths of the backends.
+	admin := http.NewServeMux()
+	admin.HandleFunc("GET /admin/backends", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.router.Load().States())
+	})
+	go func() {
+		log.Println("admin endpoints listening on", *adminAddr)
+		if err := http.ListenAndServe(*adminAddr, admin); err != nil {
+			log.Fatalf("error starting admin server: %v", err)
+		}
+	}()
 
 	http.Handle("/", proxy)
 	log.Println("Load balancer started on", addr)
-	log.Printf("Proxying to backends: %v\n", backends)
 	if err := http.ListenAndServe(addr, nil); err != nil {
 		log.Fatalf("error starting http server: %v", err)
 	}
 }
 
+// Proxy routes the requests to the backends of their route. It has a single
+// reverse proxy, whose transport keeps the connections to the backends alive
+// across requests and config reloads.
 type Proxy struct {
-	strategy Strategy
+	configPath string
+	health     HealthConfig
+	router     atomic.Pointer[Router]
+	reverse    *httputil.ReverseProxy
 }
 
-func NewProxy(strategy Strategy) *Proxy {
-	return &Proxy{strategy: strategy}
+func NewProxy(configPath string, health HealthConfig) *Proxy {
+	p := &Proxy{configPath: configPath, health: health}
+	p.reverse = &httputil.ReverseProxy{
+		// The backend is picked by the transport, on each attempt.
+		Director: func(req *http.Request) {
+			if route, ok := req.Context().Value(routeKey{}).(*Route); ok {
+				route.Rewrite(req)
+			}
+		},
+		Transport:    &balancerTransport{transport: newTransport()},
+		ErrorHandler: p.handleError,
+	}
+	return p
 }
 
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	targetURL, err := url.Parse(p.strategy.NextBackend())
+// Reload loads the config and swaps the routes. The requests in flight finish
+// with the routes they matched, whose health checks are stopped. The backends
+// that are still in the config keep their state.
+func (p *Proxy) Reload() error {
+	cfg, err := LoadConfig(p.configPath)
 	if err != nil {
-		http.Error(w, "Bad backend", http.StatusInternalServerError)
-		return
+		return err
 	}
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, e error) {
-		http.Error(w, "Backend unreachable", http.StatusBadGateway)
+	router, err := NewRouter(cfg, p.health, p.router.Load())
+	if err != nil {
+		return err
 	}
-	proxy.ServeHTTP(w, r)
-}
 
-type Strategy interface {
-	NextBackend() string
-}
-
-type RoundRobinStrategy struct {
-	current  atomic.Uint32
-	backends []string
+	if old := p.router.Swap(router); old != nil {
+		old.Close()
+	}
+	log.Printf("loaded %d routes from %s", len(cfg.Routes), p.configPath)
+	return nil
 }
 
-func NewRoundRobinStrategy(backends []string) (*RoundRobinStrategy, error) {
-	if len(backends) == 0 {
-		return nil, errors.New("no backends provided")
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := p.router.Load().Match(r)
+	if route == nil {
+		http.Error(w, "No route matches the request", http.StatusNotFound)
+		return
 	}
-	return &RoundRobinStrategy{
-		backends: backends,
-	}, nil
+
+	ctx, cancel := context.WithTimeout(r.Context(), route.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, routeKey{}, route)
+	p.reverse.ServeHTTP(w, r.WithContext(ctx))
 }
 
-func (r *RoundRobinStrategy) NextBackend() string {
-	idx := r.current.Add(1) - 1
-	return r.backends[idx%uint32(len(r.backends))]
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errNoBackend):
+		http.Error(w, "No backend available", http.StatusServiceUnavailable)
+	case errors.Is(err, errTryTimeout), errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Backend timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		// The client went away, so nobody reads the response.
+	default:
+		log.Printf("error proxying %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "Backend unreachable", http.StatusBadGateway)
+	}
 }
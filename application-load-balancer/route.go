@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route sends the requests it matches to its pool of backends.
+type Route struct {
+	name          string
+	host          string
+	pathPrefix    string
+	headers       map[string]string
+	rewritePrefix *string
+
+	pool     *Pool
+	strategy Strategy
+
+	timeout    time.Duration
+	tryTimeout time.Duration
+	retries    RetryConfig
+	budget     *retryBudget
+}
+
+// Match reports whether r matches every matcher of the route.
+func (rt *Route) Match(r *http.Request) bool {
+	if rt.host != "" && !matchHost(rt.host, requestHost(r)) {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	for name, value := range rt.headers {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Rewrite replaces the path prefix of r, if the route rewrites it.
+func (rt *Route) Rewrite(r *http.Request) {
+	if rt.rewritePrefix == nil {
+		return
+	}
+	// The escaped path is rewritten too, so that escaped slashes in the rest
+	// of the path are kept.
+	rawPath := ""
+	if r.URL.RawPath != "" {
+		if rest, ok := strings.CutPrefix(r.URL.RawPath, rt.pathPrefix); ok {
+			rawPath = *rt.rewritePrefix + rest
+		}
+	}
+	r.URL.Path = *rt.rewritePrefix + strings.TrimPrefix(r.URL.Path, rt.pathPrefix)
+	r.URL.RawPath = rawPath
+}
+
+// requestHost returns the host of r in lower case, without the port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// Router is an immutable set of routes. The proxy swaps it for a new one when
+// the config is reloaded, so that the in-flight requests keep the routes and
+// the backends they started with.
+type Router struct {
+	routes []*Route
+	// cancel stops the health checks of the pools.
+	cancel context.CancelFunc
+}
+
+// backendKey identifies a backend of a route across config reloads.
+type backendKey struct {
+	route string
+	url   string
+}
+
+// NewRouter creates the routes of cfg, and starts the health checks of their
+// pools. The backends that a route of old already has are reused, so that
+// their health, ejections, circuits and requests in flight carry over. old may
+// be nil.
+func NewRouter(cfg *Config, health HealthConfig, old *Router) (*Router, error) {
+	previous := make(map[backendKey]*Backend)
+	if old != nil {
+		for _, route := range old.routes {
+			for _, b := range route.pool.Backends() {
+				previous[backendKey{route.name, b.URL.String()}] = b
+			}
+		}
+	}
+
+	router := &Router{}
+	// The reused backends are only updated once every route is created, so
+	// that an invalid config leaves the current routes as they are.
+	var updates []func()
+	for _, rc := range cfg.Routes {
+		backends := make([]*Backend, 0, len(rc.Backends))
+		for _, bc := range rc.Backends {
+			b, err := NewBackend(bc.URL, rc.CircuitBreaker)
+			if err != nil {
+				return nil, err
+			}
+			key := backendKey{rc.Name, b.URL.String()}
+			if prev, ok := previous[key]; ok {
+				// A backend listed twice is only reused once.
+				delete(previous, key)
+				b = prev
+				updates = append(updates, func() {
+					b.SetWeight(bc.Weight)
+					b.breaker.SetConfig(rc.CircuitBreaker)
+				})
+			} else {
+				b.SetWeight(bc.Weight)
+			}
+			backends = append(backends, b)
+		}
+		pool, err := NewPool(backends, health)
+		if err != nil {
+			return nil, err
+		}
+		strategy, err := NewStrategy(backends, rc.Strategy)
+		if err != nil {
+			return nil, err
+		}
+
+		router.routes = append(router.routes, &Route{
+			name:          rc.Name,
+			host:          strings.ToLower(rc.Host),
+			pathPrefix:    rc.PathPrefix,
+			headers:       rc.Headers,
+			rewritePrefix: rc.RewritePrefix,
+			pool:          pool,
+			strategy:      strategy,
+			timeout:       time.Duration(rc.Timeout),
+			tryTimeout:    time.Duration(rc.TryTimeout),
+			retries:       rc.Retries,
+			budget:        newRetryBudget(rc.Retries.Budget),
+		})
+	}
+
+	for _, update := range updates {
+		update()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	router.cancel = cancel
+	for _, route := range router.routes {
+		route.pool.StartHealthChecks(ctx)
+	}
+	return router, nil
+}
+
+// Match returns the first route matching r, or nil.
+func (router *Router) Match(r *http.Request) *Route {
+	for _, route := range router.routes {
+		if route.Match(r) {
+			return route
+		}
+	}
+	return nil
+}
+
+// States returns the state of the backends of each route.
+func (router *Router) States() map[string][]BackendState {
+	states := make(map[string][]BackendState, len(router.routes))
+	for _, route := range router.routes {
+		states[route.name] = route.pool.States()
+	}
+	return states
+}
+
+// Close stops the health checks. The routes keep serving the requests that
+// already matched them.
+func (router *Router) Close() {
+	router.cancel()
+}
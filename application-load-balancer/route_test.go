@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRouterReusesBackends(t *testing.T) {
+	health := defaultHealthConfig
+	health.Interval = time.Hour
+
+	cfg := &Config{Routes: []RouteConfig{{
+		Name:     "api",
+		Backends: []BackendConfig{{URL: "http://127.0.0.1:1"}, {URL: "http://127.0.0.1:2"}},
+	}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewRouter(cfg, health, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	kept := old.routes[0].pool.Backends()[0]
+	kept.outstanding.Add(3)
+	breaker := kept.breaker
+
+	cfg = &Config{Routes: []RouteConfig{
+		{
+			Name: "api",
+			Backends: []BackendConfig{
+				{URL: "http://127.0.0.1:1", Weight: 5},
+				{URL: "http://127.0.0.1:3"},
+			},
+		},
+		{Name: "other", Backends: []BackendConfig{{URL: "http://127.0.0.1:1"}}},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewRouter(cfg, health, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	backends := router.routes[0].pool.Backends()
+	if backends[0] != kept || backends[0].breaker != breaker {
+		t.Errorf("backend %s of route api was recreated", kept.URL)
+	}
+	if n := backends[0].outstanding.Load(); n != 3 {
+		t.Errorf("expected 3 requests in flight to %s, got %d", kept.URL, n)
+	}
+	if w := backends[0].Weight(); w != 5 {
+		t.Errorf("expected weight 5, got %d", w)
+	}
+	if backends[1].URL.String() != "http://127.0.0.1:3" || backends[1].breaker == breaker {
+		t.Errorf("expected a new backend with its own breaker, got %s", backends[1].URL)
+	}
+	// Backends are only shared within a route.
+	if other := router.routes[1].pool.Backends()[0]; other == kept {
+		t.Errorf("route other reuses the backend of route api")
+	}
+}
+
+func TestNewRouterKeepsBackendsOnError(t *testing.T) {
+	health := defaultHealthConfig
+	health.Interval = time.Hour
+
+	cfg := &Config{Routes: []RouteConfig{{Name: "api", Backends: []BackendConfig{{URL: "http://127.0.0.1:1"}}}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewRouter(cfg, health, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	cfg = &Config{Routes: []RouteConfig{
+		{Name: "api", Backends: []BackendConfig{{URL: "http://127.0.0.1:1", Weight: 5}}},
+		{Name: "broken", Strategy: StrategyConfig{Name: "unknown"}, Backends: []BackendConfig{{URL: "http://127.0.0.1:2"}}},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRouter(cfg, health, old); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+	if w := old.routes[0].pool.Backends()[0].Weight(); w != 1 {
+		t.Errorf("expected the failed reload to keep weight 1, got %d", w)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy selects the backend of each request.
+type Strategy interface {
+	// NextBackend returns the backend of r, or nil if no backend is
+	// available. The backends in exclude are skipped, e.g. the ones a retried
+	// request already failed on.
+	NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend
+	// Done is called once the request proxied to b is finished.
+	Done(b *Backend)
+}
+
+// StrategyConfig configures the strategy returned by NewStrategy.
+type StrategyConfig struct {
+	// Name is one of round-robin, weighted-round-robin, least-requests,
+	// power-of-two and consistent-hash.
+	Name string `json:"name"`
+	// HashHeader and HashCookie name the header or the cookie hashed by the
+	// consistent-hash strategy. When both are set, the header is hashed for
+	// the requests without the cookie.
+	HashHeader string `json:"hash_header,omitempty"`
+	HashCookie string `json:"hash_cookie,omitempty"`
+}
+
+func NewStrategy(backends []*Backend, cfg StrategyConfig) (Strategy, error) {
+	switch cfg.Name {
+	case "round-robin":
+		return NewRoundRobinStrategy(backends)
+	case "weighted-round-robin":
+		return NewWeightedRoundRobinStrategy(backends)
+	case "least-requests":
+		return NewLeastRequestsStrategy(backends)
+	case "power-of-two":
+		return NewPowerOfTwoStrategy(backends)
+	case "consistent-hash":
+		return NewConsistentHashStrategy(backends, cfg.HashHeader, cfg.HashCookie)
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", cfg.Name)
+	}
+}
+
+type RoundRobinStrategy struct {
+	current  atomic.Uint32
+	backends []*Backend
+}
+
+func NewRoundRobinStrategy(backends []*Backend) (*RoundRobinStrategy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends provided")
+	}
+	return &RoundRobinStrategy{
+		backends: backends,
+	}, nil
+}
+
+// NextBackend skips the unavailable and excluded backends.
+func (s *RoundRobinStrategy) NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend {
+	for range s.backends {
+		idx := s.current.Add(1) - 1
+		backend := s.backends[idx%uint32(len(s.backends))]
+		if !exclude[backend] && backend.Available() {
+			return backend
+		}
+	}
+	return nil
+}
+
+func (s *RoundRobinStrategy) Done(b *Backend) {}
+
+// WeightedRoundRobinStrategy is the smooth weighted round robin of nginx: a
+// backend of weight 3 and one of weight 1 are picked a, a, b, a rather than
+// a, a, a, b, so that the requests to a backend are spread over time.
+type WeightedRoundRobinStrategy struct {
+	mu       sync.Mutex
+	backends []*Backend
+	// current holds the current weight of each backend, which grows by its
+	// weight on every pick and drops by the total weight when it's picked.
+	current []int
+}
+
+func NewWeightedRoundRobinStrategy(backends []*Backend) (*WeightedRoundRobinStrategy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends provided")
+	}
+	return &WeightedRoundRobinStrategy{
+		backends: backends,
+		current:  make([]int, len(backends)),
+	}, nil
+}
+
+// NextBackend only weighs the available backends, so that the share of an
+// unavailable backend is spread over the others.
+func (s *WeightedRoundRobinStrategy) NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best, total := -1, 0
+	for i, b := range s.backends {
+		if exclude[b] || !b.Available() {
+			continue
+		}
+		weight := b.Weight()
+		s.current[i] += weight
+		total += weight
+		if best == -1 || s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	s.current[best] -= total
+	return s.backends[best]
+}
+
+func (s *WeightedRoundRobinStrategy) Done(b *Backend) {}
+
+// LeastRequestsStrategy picks the available backend with the fewest requests
+// in flight, the first one in case of a tie.
+type LeastRequestsStrategy struct {
+	backends []*Backend
+}
+
+func NewLeastRequestsStrategy(backends []*Backend) (*LeastRequestsStrategy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends provided")
+	}
+	return &LeastRequestsStrategy{
+		backends: backends,
+	}, nil
+}
+
+func (s *LeastRequestsStrategy) NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend {
+	var best *Backend
+	var bestCount int64
+	for _, b := range s.backends {
+		if exclude[b] || !b.Available() {
+			continue
+		}
+		count := b.outstanding.Load()
+		if best == nil || count < bestCount {
+			best, bestCount = b, count
+		}
+	}
+	if best != nil {
+		best.outstanding.Add(1)
+	}
+	return best
+}
+
+func (s *LeastRequestsStrategy) Done(b *Backend) {
+	b.outstanding.Add(-1)
+}
+
+// PowerOfTwoStrategy picks two available backends at random, and the one with
+// the fewest requests in flight of the two. It balances almost as well as
+// LeastRequestsStrategy without scanning every backend, and doesn't send all
+// the requests to the same backend in case of a tie.
+type PowerOfTwoStrategy struct {
+	backends []*Backend
+}
+
+func NewPowerOfTwoStrategy(backends []*Backend) (*PowerOfTwoStrategy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backends provided")
+	}
+	return &PowerOfTwoStrategy{
+		backends: backends,
+	}, nil
+}
+
+func (s *PowerOfTwoStrategy) NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend {
+	available := make([]*Backend, 0, len(s.backends))
+	for _, b := range s.backends {
+		if !exclude[b] && b.Available() {
+			available = append(available, b)
+		}
+	}
+
+	var best *Backend
+	switch len(available) {
+	case 0:
+		return nil
+	case 1:
+		best = available[0]
+	default:
+		i := rand.IntN(len(available))
+		j := rand.IntN(len(available) - 1)
+		if j >= i {
+			j++
+		}
+		best = available[i]
+		if available[j].outstanding.Load() < best.outstanding.Load() {
+			best = available[j]
+		}
+	}
+	best.outstanding.Add(1)
+	return best
+}
+
+func (s *PowerOfTwoStrategy) Done(b *Backend) {
+	b.outstanding.Add(-1)
+}
+
+// hashReplicas is the number of points of each backend on the hash ring, so
+// that the keys are spread evenly over the backends. With 100 points, a
+// backend of two may get 60% of the keys.
+const hashReplicas = 500
+
+// ConsistentHashStrategy sends the requests with the same header or cookie to
+// the same backend, for sticky sessions. When a backend becomes unavailable,
+// only its keys move to other backends. Requests without the key are
+// balanced with round robin.
+type ConsistentHashStrategy struct {
+	header   string
+	cookie   string
+	ring     []ringPoint
+	fallback *RoundRobinStrategy
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+func NewConsistentHashStrategy(backends []*Backend, header, cookie string) (*ConsistentHashStrategy, error) {
+	if header == "" && cookie == "" {
+		return nil, errors.New("consistent hashing needs a header or a cookie")
+	}
+	fallback, err := NewRoundRobinStrategy(backends)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ConsistentHashStrategy{
+		header:   header,
+		cookie:   cookie,
+		fallback: fallback,
+	}
+	for _, b := range backends {
+		for i := range hashReplicas {
+			s.ring = append(s.ring, ringPoint{
+				hash:    hashKey(b.URL.String() + "#" + strconv.Itoa(i)),
+				backend: b,
+			})
+		}
+	}
+	slices.SortFunc(s.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return s, nil
+}
+
+func (s *ConsistentHashStrategy) NextBackend(r *http.Request, exclude map[*Backend]bool) *Backend {
+	key := s.key(r)
+	if key == "" {
+		return s.fallback.NextBackend(r, exclude)
+	}
+
+	// The key belongs to the first point at or after its hash, or to the next
+	// ones if that backend is unavailable or excluded.
+	hash := hashKey(key)
+	start, _ := slices.BinarySearchFunc(s.ring, hash, func(p ringPoint, hash uint64) int {
+		return cmp.Compare(p.hash, hash)
+	})
+	for i := range s.ring {
+		b := s.ring[(start+i)%len(s.ring)].backend
+		if !exclude[b] && b.Available() {
+			return b
+		}
+	}
+	return nil
+}
+
+func (s *ConsistentHashStrategy) Done(b *Backend) {}
+
+func (s *ConsistentHashStrategy) key(r *http.Request) string {
+	if s.cookie != "" {
+		if c, err := r.Cookie(s.cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	if s.header == "" {
+		return ""
+	}
+	return r.Header.Get(s.header)
+}
+
+// hashKey returns the position of key on the ring: its 64-bit FNV-1a hash,
+// mixed with the fmix64 step of MurmurHash3.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
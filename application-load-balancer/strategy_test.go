@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testBackends returns n available backends, named a, b, c...
+func testBackends(t *testing.T, n int) []*Backend {
+	t.Helper()
+	backends := make([]*Backend, n)
+	for i := range backends {
+		b, err := NewBackend(fmt.Sprintf("http://%c.test", 'a'+i), defaultBreakerConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends[i] = b
+	}
+	return backends
+}
+
+func backendName(b *Backend) string {
+	if b == nil {
+		return "-"
+	}
+	return strings.TrimSuffix(b.URL.Host, ".test")
+}
+
+func TestWeightedRoundRobinOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		weights  []int
+		expected string
+	}{
+		{"equal weights", []int{1, 1}, "a,b,a,b"},
+		{"3 to 1", []int{3, 1}, "a,a,b,a,a,a,b,a"},
+		{"5 to 1 to 1", []int{5, 1, 1}, "a,a,b,a,c,a,a"},
+	}
+
+	for _, tt := range tests {
+		backends := testBackends(t, len(tt.weights))
+		for i, w := range tt.weights {
+			backends[i].SetWeight(w)
+		}
+		s, err := NewWeightedRoundRobinStrategy(backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var picks []string
+		for range strings.Count(tt.expected, ",") + 1 {
+			picks = append(picks, backendName(s.NextBackend(nil, nil)))
+		}
+		if got := strings.Join(picks, ","); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestLeastRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		outstanding []int64
+		unavailable int
+		exclude     int
+		expected    string
+	}{
+		{"fewest requests", []int64{3, 1, 2}, -1, -1, "b"},
+		{"tie goes to the first", []int64{2, 1, 1}, -1, -1, "b"},
+		{"unavailable skipped", []int64{3, 1, 2}, 1, -1, "c"},
+		{"excluded skipped", []int64{3, 1, 2}, -1, 1, "c"},
+	}
+
+	for _, tt := range tests {
+		backends := testBackends(t, len(tt.outstanding))
+		for i, n := range tt.outstanding {
+			backends[i].outstanding.Store(n)
+		}
+		if tt.unavailable >= 0 {
+			backends[tt.unavailable].healthy = false
+		}
+		exclude := map[*Backend]bool{}
+		if tt.exclude >= 0 {
+			exclude[backends[tt.exclude]] = true
+		}
+		s, err := NewLeastRequestsStrategy(backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := s.NextBackend(nil, exclude)
+		if backendName(b) != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, backendName(b))
+			continue
+		}
+		before := b.outstanding.Load()
+		s.Done(b)
+		if b.outstanding.Load() != before-1 {
+			t.Errorf("%s: expected Done to release the request", tt.name)
+		}
+	}
+}
+
+func TestLeastRequestsSpreadsInFlightRequests(t *testing.T) {
+	backends := testBackends(t, 3)
+	s, err := NewLeastRequestsStrategy(backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests that don't finish are spread evenly.
+	var picks []string
+	for range 6 {
+		picks = append(picks, backendName(s.NextBackend(nil, nil)))
+	}
+	if got := strings.Join(picks, ","); got != "a,b,c,a,b,c" {
+		t.Errorf("expected a,b,c,a,b,c, got %s", got)
+	}
+
+	s.Done(backends[1])
+	if b := s.NextBackend(nil, nil); b != backends[1] {
+		t.Errorf("expected the released backend b, got %s", backendName(b))
+	}
+}
+
+func TestPowerOfTwoSkipsBackends(t *testing.T) {
+	tests := []struct {
+		name        string
+		unavailable []int
+		exclude     []int
+		expected    string
+	}{
+		{"all available", nil, nil, "a,b,c,d"},
+		{"unavailable", []int{0, 2}, nil, "b,d"},
+		{"excluded", nil, []int{1, 3}, "a,c"},
+		{"both", []int{0}, []int{1, 2}, "d"},
+		{"none left", []int{0, 1}, []int{2, 3}, "-"},
+	}
+
+	for _, tt := range tests {
+		backends := testBackends(t, 4)
+		for _, i := range tt.unavailable {
+			backends[i].healthy = false
+		}
+		exclude := map[*Backend]bool{}
+		for _, i := range tt.exclude {
+			exclude[backends[i]] = true
+		}
+		s, err := NewPowerOfTwoStrategy(backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		picked := map[string]bool{}
+		for range 200 {
+			b := s.NextBackend(nil, exclude)
+			picked[backendName(b)] = true
+			if b != nil {
+				s.Done(b)
+			}
+		}
+		var names []string
+		for _, name := range []string{"-", "a", "b", "c", "d"} {
+			if picked[name] {
+				names = append(names, name)
+			}
+		}
+		if got := strings.Join(names, ","); got != tt.expected {
+			t.Errorf("%s: expected picks %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	backends := testBackends(t, 3)
+	s, err := NewConsistentHashStrategy(backends, "X-User", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(user string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://api/", nil)
+		r.Header.Set("X-User", user)
+		return r
+	}
+
+	owners := make(map[string]*Backend)
+	for i := range 300 {
+		user := fmt.Sprint("user-", i)
+		owners[user] = s.NextBackend(request(user), nil)
+		// A key stays on the same backend.
+		for range 3 {
+			if b := s.NextBackend(request(user), nil); b != owners[user] {
+				t.Fatalf("%s moved from %s to %s", user, backendName(owners[user]), backendName(b))
+			}
+		}
+	}
+
+	// Only the keys of the unavailable backend move.
+	backends[1].healthy = false
+	moved := 0
+	for user, owner := range owners {
+		b := s.NextBackend(request(user), nil)
+		switch {
+		case owner == backends[1]:
+			if b == backends[1] || b == nil {
+				t.Errorf("%s stayed on the unavailable backend", user)
+			}
+			moved++
+		case b != owner:
+			t.Errorf("%s moved from %s to %s", user, backendName(owner), backendName(b))
+		}
+	}
+	if moved == 0 {
+		t.Errorf("expected some keys on backend b")
+	}
+
+	// A retry skips the backend that owns the key.
+	for user, owner := range owners {
+		if owner == backends[1] {
+			continue
+		}
+		b := s.NextBackend(request(user), map[*Backend]bool{owner: true})
+		if b == owner || b == nil || b == backends[1] {
+			t.Errorf("%s retried on %s", user, backendName(b))
+		}
+	}
+}
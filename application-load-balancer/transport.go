@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryConfig configures the retries of the idempotent requests of a route.
+type RetryConfig struct {
+	// Attempts is the maximum number of attempts of a request, the first one
+	// included, so 1 disables retries.
+	Attempts int `json:"attempts,omitempty"`
+	// Budget is the ratio of retries to requests, so that retries can't
+	// multiply the load on backends that are already failing.
+	Budget float64 `json:"budget,omitempty"`
+}
+
+var defaultRetryConfig = RetryConfig{
+	Attempts: 3,
+	Budget:   0.2,
+}
+
+// retryBudgetBurst is the number of retries a route can make in a row before
+// earning more, so that the routes with little traffic can retry too.
+const retryBudgetBurst = 10
+
+// retryBudget is a token bucket: each request adds Budget tokens, and each
+// retry takes one.
+type retryBudget struct {
+	ratio float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetBurst}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, retryBudgetBurst)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+var (
+	errNoRoute   = errors.New("no route matches the request")
+	errNoBackend = errors.New("no backend available")
+)
+
+// routeKey is the context key of the route of a request.
+type routeKey struct{}
+
+// newTransport returns the transport shared by all the routes, which keeps
+// the connections to the backends alive between requests.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.MaxIdleConns = 1000
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// balancerTransport sends the requests proxied on a route to its backends:
+// it picks a backend for each attempt, and retries the idempotent requests
+// on another backend when an attempt fails.
+type balancerTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *balancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, ok := req.Context().Value(routeKey{}).(*Route)
+	if !ok {
+		return nil, errNoRoute
+	}
+	route.budget.deposit()
+
+	tried := make(map[*Backend]bool, route.retries.Attempts)
+	backend := t.pick(route, req, tried)
+	if backend == nil {
+		return nil, errNoBackend
+	}
+	for attempt := 1; ; attempt++ {
+		tried[backend] = true
+		resp, err := t.try(route, backend, req)
+		if !shouldRetry(resp, err) || req.Context().Err() != nil ||
+			attempt >= route.retries.Attempts || !isReplayable(req) {
+			return resp, err
+		}
+
+		// The failed attempt is returned when there's no other backend to
+		// retry on, or the budget is spent.
+		next := t.pick(route, req, tried)
+		if next == nil {
+			return resp, err
+		}
+		if !route.budget.withdraw() {
+			next.breaker.Abort()
+			route.strategy.Done(next)
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		backend = next
+	}
+}
+
+// pick returns a backend that wasn't tried yet and whose circuit lets the
+// request through, or nil. The strategy skips the tried backends, so that a
+// retry goes to another backend even if the failed one still has the fewest
+// requests or owns the hash of the request. A backend whose circuit rejects
+// the request is skipped for the next attempts too.
+func (t *balancerTransport) pick(route *Route, req *http.Request, tried map[*Backend]bool) *Backend {
+	for range route.pool.Backends() {
+		backend := route.strategy.NextBackend(req, tried)
+		if backend == nil {
+			return nil
+		}
+		if backend.breaker.Allow() {
+			return backend
+		}
+		route.strategy.Done(backend)
+		tried[backend] = true
+	}
+	return nil
+}
+
+// try sends req to backend, and records the outcome in the pool and the
+// circuit breaker. The backend is released once the body of the response
+// is closed.
+func (t *balancerTransport) try(route *Route, backend *Backend, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	if route.tryTimeout > 0 {
+		// The timer only bounds the wait for the response headers, since the
+		// body may take longer to stream.
+		timer := time.AfterFunc(route.tryTimeout, cancel)
+		defer timer.Stop()
+	}
+
+	out := req.Clone(ctx)
+	out.URL.Scheme = backend.URL.Scheme
+	out.URL.Host = backend.URL.Host
+	out.URL.Path = joinPath(backend.URL.Path, req.URL.Path)
+	if req.URL.RawPath != "" {
+		out.URL.RawPath = joinPath(backend.URL.EscapedPath(), req.URL.RawPath)
+	}
+
+	resp, err := t.transport.RoundTrip(out)
+	if err != nil {
+		if ctx.Err() != nil && req.Context().Err() == nil {
+			err = errTryTimeout
+		}
+		cancel()
+		route.strategy.Done(backend)
+		if errors.Is(req.Context().Err(), context.Canceled) {
+			// The client went away, which says nothing about the backend.
+			backend.breaker.Abort()
+			return nil, err
+		}
+		route.pool.Report(backend, 0, err)
+		backend.breaker.Record(false)
+		return nil, err
+	}
+
+	route.pool.Report(backend, resp.StatusCode, nil)
+	backend.breaker.Record(resp.StatusCode < 500)
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() {
+		cancel()
+		route.strategy.Done(backend)
+	}}
+	return resp, nil
+}
+
+var errTryTimeout = errors.New("backend timed out")
+
+// releaseBody calls release once, when the body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// shouldRetry reports whether an attempt failed in a way that another backend
+// may not: the backend couldn't be reached, or it's overloaded or down.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReplayable reports whether req can be sent again: its method is
+// idempotent, and it has no body that the first attempt may have consumed.
+func isReplayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+func joinPath(base, path string) string {
+	if base == "" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
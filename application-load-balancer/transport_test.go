@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryOnConnectionError(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer live.Close()
+	// The dead backend refuses connections.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	health := defaultHealthConfig
+	health.Interval = time.Hour
+
+	strategies := []StrategyConfig{
+		{Name: "round-robin"},
+		{Name: "weighted-round-robin"},
+		{Name: "least-requests"},
+		{Name: "power-of-two"},
+		{Name: "consistent-hash", HashHeader: "X-User"},
+	}
+	for _, strategy := range strategies {
+		cfg := &Config{Routes: []RouteConfig{{
+			Name:     "api",
+			Strategy: strategy,
+			// The dead backend goes first, so that it wins the ties.
+			Backends: []BackendConfig{{URL: dead.URL}, {URL: live.URL}},
+		}}}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		router, err := NewRouter(cfg, health, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		route := router.routes[0]
+		transport := &balancerTransport{transport: newTransport()}
+
+		for i := range 10 {
+			ctx := context.WithValue(context.Background(), routeKey{}, route)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://api/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-User", fmt.Sprint(i))
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Errorf("%s: request %d failed: %v", strategy.Name, i, err)
+				continue
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+				t.Errorf("%s: request %d got %d %q, want 200 from the live backend", strategy.Name, i, resp.StatusCode, body)
+			}
+		}
+
+		for _, b := range route.pool.Backends() {
+			if n := b.outstanding.Load(); n != 0 {
+				t.Errorf("%s: %d requests still in flight to %s", strategy.Name, n, b.URL)
+			}
+		}
+		router.Close()
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5)
+
+	// A route can make a burst of retries without any request.
+	for i := range retryBudgetBurst {
+		if !b.withdraw() {
+			t.Fatalf("expected retry %d of the burst to be allowed", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("expected the budget to be spent")
+	}
+
+	// Each request earns half a retry.
+	b.deposit()
+	if b.withdraw() {
+		t.Fatal("expected half a token not to allow a retry")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("expected two requests to earn a retry")
+	}
+
+	// The tokens don't grow past the burst.
+	for range 100 {
+		b.deposit()
+	}
+	allowed := 0
+	for b.withdraw() {
+		allowed++
+	}
+	if allowed != retryBudgetBurst {
+		t.Errorf("expected %d retries after a refill, got %d", retryBudgetBurst, allowed)
+	}
+}
+
+func TestRetryOnlyReplayableRequests(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	health := defaultHealthConfig
+	health.Interval = time.Hour
+
+	tests := []struct {
+		method  string
+		body    string
+		retried bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodDelete, "", true},
+		{http.MethodPost, "", false},
+		{http.MethodPatch, "", false},
+		{http.MethodPost, "data", false},
+		{http.MethodPut, "data", false},
+	}
+
+	for _, tt := range tests {
+		// Round robin tries the dead backend first.
+		cfg := &Config{Routes: []RouteConfig{{
+			Name:     "api",
+			Backends: []BackendConfig{{URL: dead.URL}, {URL: live.URL}},
+		}}}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		router, err := NewRouter(cfg, health, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := context.WithValue(context.Background(), routeKey{}, router.routes[0])
+		var body io.Reader
+		if tt.body != "" {
+			body = strings.NewReader(tt.body)
+		}
+		req, err := http.NewRequestWithContext(ctx, tt.method, "http://api/", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := &balancerTransport{transport: newTransport()}
+		resp, err := transport.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if retried := err == nil; retried != tt.retried {
+			t.Errorf("%s with body %q: expected retried %v, got error %v", tt.method, tt.body, tt.retried, err)
+		}
+		router.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxBatchOps is the maximum number of operations in a batch.
+const maxBatchOps = 1000
+
+// BatchOp is a single operation of a batch: "get", "put" or "delete".
+type BatchOp struct {
+	Op         string
+	Key        string
+	Value      string
+	Expiration time.Duration
+}
+
+// BatchResult is the result of a batch operation. Value and Found are only
+// set by gets.
+type BatchResult struct {
+	Value string
+	Found bool
+	Err   error
+}
+
+// validateBatch checks that ops can be run as a batch. A key can only appear
+// once, since the operations of a batch run concurrently.
+func validateBatch(ops []BatchOp) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("batch is empty")
+	}
+	if len(ops) > maxBatchOps {
+		return fmt.Errorf("batch has %d operations, at most %d are allowed", len(ops), maxBatchOps)
+	}
+
+	seen := make(map[string]bool, len(ops))
+	for i, op := range ops {
+		switch op.Op {
+		case "get", "delete":
+		case "put":
+			if op.Value == "" {
+				return fmt.Errorf("operation %d: value cannot be empty", i)
+			}
+		default:
+			return fmt.Errorf("operation %d: unknown operation %q", i, op.Op)
+		}
+		if op.Key == "" {
+			return fmt.Errorf("operation %d: key cannot be empty", i)
+		}
+		if seen[op.Key] {
+			return fmt.Errorf("operation %d: duplicate key %q", i, op.Key)
+		}
+		seen[op.Key] = true
+	}
+
+	return nil
+}
+
+// Batch runs ops with one request per shard, instead of one per key, and
+// returns their results in the same order. The operations aren't atomic: each
+// result reports whether its operation failed.
+func (k *KVStore) Batch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+
+	route, _, release := k.shardManager.Snapshot()
+	defer release()
+
+	results := make([]BatchResult, len(ops))
+	now := time.Now()
+
+	// Writes go to the owner of each key, like single writes.
+	writes := make(map[*ReplicaSet][]Row)
+	writeOps := make(map[*ReplicaSet][]int)
+	var gets []string
+	getOps := make(map[string]int)
+	for i, op := range ops {
+		row := Row{Key: op.Key, Value: op.Value, Version: newVersion()}
+		switch op.Op {
+		case "get":
+			gets = append(gets, op.Key)
+			getOps[op.Key] = i
+			continue
+		case "put":
+			if op.Expiration > 0 {
+				row.ExpiresAt = sql.NullTime{Time: now.Add(op.Expiration), Valid: true}
+			}
+		case "delete":
+			row.ExpiresAt = sql.NullTime{Time: now, Valid: true}
+		}
+		owner := route(op.Key).Owner
+		writes[owner] = append(writes[owner], row)
+		writeOps[owner] = append(writeOps[owner], i)
+	}
+
+	for shard, err := range forEachShard(writes, func(shard *ReplicaSet, rows []Row) error {
+		return shard.PutMany(ctx, rows)
+	}) {
+		for _, i := range writeOps[shard] {
+			results[i].Err = fmt.Errorf("error writing kv: %v", err)
+		}
+	}
+
+	// Reads follow the same order as single reads: the owner, then the
+	// previous shard of the keys being moved, then the owner again.
+	rows, errs := getByShard(ctx, gets, func(key string) *ReplicaSet {
+		return route(key).Owner
+	})
+	var moving []string
+	for _, key := range gets {
+		if _, ok := rows[key]; !ok && errs[key] == nil && route(key).Previous != nil {
+			moving = append(moving, key)
+		}
+	}
+	if len(moving) > 0 {
+		previousRows, previousErrs := getByShard(ctx, moving, func(key string) *ReplicaSet {
+			return route(key).Previous
+		})
+		var retry []string
+		for _, key := range moving {
+			if row, ok := previousRows[key]; ok {
+				rows[key] = row
+			} else if err := previousErrs[key]; err != nil {
+				errs[key] = err
+			} else {
+				retry = append(retry, key)
+			}
+		}
+
+		ownerRows, ownerErrs := getByShard(ctx, retry, func(key string) *ReplicaSet {
+			return route(key).Owner
+		})
+		for _, key := range retry {
+			if row, ok := ownerRows[key]; ok {
+				rows[key] = row
+			}
+			errs[key] = ownerErrs[key]
+		}
+	}
+
+	for _, key := range gets {
+		i := getOps[key]
+		if err := errs[key]; err != nil {
+			results[i].Err = fmt.Errorf("error scanning value: %v", err)
+			continue
+		}
+		if row, ok := rows[key]; ok && row.Live(now) {
+			results[i].Value = row.Value
+			results[i].Found = true
+		}
+	}
+
+	return results, nil
+}
+
+// getByShard reads keys with one request per shard, and returns the rows that
+// were found along with the error of each key whose shard failed.
+func getByShard(ctx context.Context, keys []string, shardOf func(key string) *ReplicaSet) (map[string]Row, map[string]error) {
+	groups := make(map[*ReplicaSet][]string)
+	for _, key := range keys {
+		shard := shardOf(key)
+		groups[shard] = append(groups[shard], key)
+	}
+
+	var mu sync.Mutex
+	rows := make(map[string]Row)
+	errs := make(map[string]error)
+	for shard, err := range forEachShard(groups, func(shard *ReplicaSet, keys []string) error {
+		found, err := shard.GetMany(ctx, keys)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for key, row := range found {
+			rows[key] = row
+		}
+		return nil
+	}) {
+		for _, key := range groups[shard] {
+			errs[key] = err
+		}
+	}
+
+	return rows, errs
+}
+
+// forEachShard calls fn concurrently for every shard of groups, and returns
+// the shards that failed along with their error.
+func forEachShard[T any](groups map[*ReplicaSet][]T, fn func(shard *ReplicaSet, items []T) error) map[*ReplicaSet]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[*ReplicaSet]error)
+	for shard, items := range groups {
+		wg.Go(func() {
+			if err := fn(shard, items); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs[shard] = err
+			}
+		})
+	}
+	wg.Wait()
+
+	return errs
+}
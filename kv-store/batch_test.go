@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBatch(t *testing.T) {
+	valid := []BatchOp{
+		{Op: "put", Key: "a", Value: "1"},
+		{Op: "get", Key: "b"},
+		{Op: "delete", Key: "c"},
+	}
+	if err := validateBatch(valid); err != nil {
+		t.Fatalf("validateBatch(valid) = %v", err)
+	}
+
+	tests := map[string][]BatchOp{
+		"empty":         nil,
+		"unknown op":    {{Op: "incr", Key: "a"}},
+		"empty key":     {{Op: "get", Key: ""}},
+		"empty value":   {{Op: "put", Key: "a"}},
+		"duplicate key": {{Op: "get", Key: "a"}, {Op: "delete", Key: "a"}},
+		"too many ops":  make([]BatchOp, maxBatchOps+1),
+	}
+	for name, ops := range tests {
+		if err := validateBatch(ops); err == nil {
+			t.Errorf("%s: validateBatch succeeded", name)
+		}
+	}
+
+	err := validateBatch([]BatchOp{{Op: "get", Key: "a"}, {Op: "put", Key: "a", Value: "1"}})
+	if err == nil || !strings.Contains(err.Error(), "operation 1") {
+		t.Errorf("duplicate key error = %v, want it to name operation 1", err)
+	}
+}
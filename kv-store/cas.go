@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// casLockStripes is the number of locks serializing the conditional writes.
+// Keys share a lock when their hashes collide, which only delays them.
+const casLockStripes = 256
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// Precondition is a condition on the current version of a key, given by the
+// If-Match and If-None-Match headers of a request. Each field holds entity
+// tags, or "*" to match any live value.
+type Precondition struct {
+	IfMatch     []string
+	IfNoneMatch []string
+}
+
+// parsePrecondition returns the precondition of the given header values, or
+// nil if there is none.
+func parsePrecondition(ifMatch, ifNoneMatch string) *Precondition {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	return &Precondition{
+		IfMatch:     parseETags(ifMatch),
+		IfNoneMatch: parseETags(ifNoneMatch),
+	}
+}
+
+// parseETags splits a list of entity tags separated by commas.
+func parseETags(header string) []string {
+	var etags []string
+	for etag := range strings.SplitSeq(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// formatETag returns the entity tag of a version.
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// matchETag reports whether the version of a live value matches one of
+// etags. Weak tags only match with weak comparison, since the versions are
+// strong validators.
+func matchETag(etags []string, version int64, weak bool) bool {
+	etag := formatETag(version)
+	for _, tag := range etags {
+		if tag == "*" || tag == etag {
+			return true
+		}
+		if weak && strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether the precondition holds for row, the current state of
+// the key. found is false when the key has no value, including when it was
+// deleted or expired.
+func (p *Precondition) Check(row Row, found bool) bool {
+	if len(p.IfMatch) > 0 && (!found || !matchETag(p.IfMatch, row.Version, false)) {
+		return false
+	}
+	if len(p.IfNoneMatch) > 0 && found && matchETag(p.IfNoneMatch, row.Version, true) {
+		return false
+	}
+	return true
+}
+
+// casLocks serializes the conditional writes of a key, between reading its
+// current version and writing the new one.
+type casLocks [casLockStripes]sync.Mutex
+
+func (l *casLocks) lock(key string) func() {
+	mu := &l[hashKey(key)%casLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseETags(t *testing.T) {
+	got := parseETags(` "1", W/"2" ,*,`)
+	want := []string{`"1"`, `W/"2"`, "*"}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseETags = %q, want %q", got, want)
+	}
+
+	if parsePrecondition("", "") != nil {
+		t.Error("parsePrecondition without headers returned a precondition")
+	}
+}
+
+func TestPreconditionCheck(t *testing.T) {
+	row := Row{Key: "a", Value: "1", Version: 42}
+
+	tests := []struct {
+		name  string
+		cond  Precondition
+		found bool
+		want  bool
+	}{
+		{"if-match current", Precondition{IfMatch: []string{`"41"`, `"42"`}}, true, true},
+		{"if-match stale", Precondition{IfMatch: []string{`"41"`}}, true, false},
+		{"if-match weak", Precondition{IfMatch: []string{`W/"42"`}}, true, false},
+		{"if-match any", Precondition{IfMatch: []string{"*"}}, true, true},
+		{"if-match missing", Precondition{IfMatch: []string{"*"}}, false, false},
+		{"if-none-match any", Precondition{IfNoneMatch: []string{"*"}}, true, false},
+		{"if-none-match any missing", Precondition{IfNoneMatch: []string{"*"}}, false, true},
+		{"if-none-match current", Precondition{IfNoneMatch: []string{`W/"42"`}}, true, false},
+		{"if-none-match stale", Precondition{IfNoneMatch: []string{`"41"`}}, true, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Check(row, tt.found); got != tt.want {
+			t.Errorf("%s: Check = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewVersionAfter(t *testing.T) {
+	// A version issued by a process whose clock is far ahead.
+	current := newVersion() + 1e12
+	if v := newVersionAfter(current); v <= current {
+		t.Errorf("newVersionAfter(%d) = %d", current, v)
+	}
+	if v := newVersion(); v <= current {
+		t.Errorf("newVersion() = %d after a version ahead of the clock", v)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReaperConfig controls how expired rows are removed from the shards.
+type ReaperConfig struct {
+	// Interval is the delay between two runs of the reaper of a shard. The
+	// reaper is disabled when it's zero.
+	Interval time.Duration
+	// Grace is how long a row stays after it expired. Deleted keys are
+	// expired rows, which win over the older values of the key on stale
+	// replicas, so Grace must be longer than a replica can miss writes for
+	// before it's repaired. Otherwise a deleted key can come back.
+	Grace time.Duration
+	// BatchSize is the maximum number of rows deleted by a single statement,
+	// which keeps each transaction short.
+	BatchSize int
+}
+
+var defaultReaperConfig = ReaperConfig{
+	Interval:  time.Minute,
+	Grace:     time.Hour,
+	BatchSize: 1000,
+}
+
+// ReaperStats reports the activity of the reaper of a shard.
+type ReaperStats struct {
+	Shard       string     `json:"shard"`
+	Runs        int64      `json:"runs"`
+	DeletedRows int64      `json:"deleted_rows"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	// LastDuration and LastDeleted describe the last run.
+	LastDuration string `json:"last_duration,omitempty"`
+	LastDeleted  int64  `json:"last_deleted"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// Reaper periodically hard-deletes the rows of a shard that expired more than
+// a grace period ago, on every replica.
+type Reaper struct {
+	shard *ReplicaSet
+	cfg   ReaperConfig
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	stats ReaperStats
+}
+
+// StartReaper starts reaping the expired rows of rs in the background, until
+// rs is closed.
+func (rs *ReplicaSet) StartReaper(cfg ReaperConfig) {
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	rs.reaper = &Reaper{
+		shard: rs,
+		cfg:   cfg,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		stats: ReaperStats{Shard: rs.Name},
+	}
+	go rs.reaper.run()
+}
+
+// ReaperStats returns the stats of the reaper of rs, or false if it has no
+// reaper.
+func (rs *ReplicaSet) ReaperStats() (ReaperStats, bool) {
+	if rs.reaper == nil {
+		return ReaperStats{}, false
+	}
+
+	rs.reaper.mu.Lock()
+	defer rs.reaper.mu.Unlock()
+	return rs.reaper.stats, true
+}
+
+func (r *Reaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.reap()
+		}
+	}
+}
+
+// Stop stops the reaper, and waits for the current run to finish.
+func (r *Reaper) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// reap deletes the rows that expired before the grace period from every
+// replica, one batch at a time. A replica that fails is skipped until the
+// next run.
+func (r *Reaper) reap() {
+	start := time.Now()
+	cutoff := start.Add(-r.cfg.Grace)
+
+	var deleted int64
+	var errs []error
+	for i, db := range r.shard.replicas {
+		for {
+			select {
+			case <-r.stop:
+				// Stop is waiting for this run to finish.
+				r.record(start, deleted, errs)
+				return
+			default:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+			res, err := db.ExecContext(ctx, `
+			DELETE FROM kv WHERE key IN (
+				SELECT key FROM kv WHERE expires_at < $1 LIMIT $2
+			)
+			`, cutoff, r.cfg.BatchSize)
+			cancel()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error reaping replica %d of %s: %v", i, r.shard.Name, err))
+				break
+			}
+
+			n, err := res.RowsAffected()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error reaping replica %d of %s: %v", i, r.shard.Name, err))
+				break
+			}
+			deleted += n
+			if n < int64(r.cfg.BatchSize) {
+				break
+			}
+		}
+	}
+
+	r.record(start, deleted, errs)
+}
+
+func (r *Reaper) record(start time.Time, deleted int64, errs []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Runs++
+	r.stats.DeletedRows += deleted
+	r.stats.LastRunAt = &start
+	r.stats.LastDuration = time.Since(start).String()
+	r.stats.LastDeleted = deleted
+	r.stats.LastError = ""
+	for _, err := range errs {
+		log.Print(err)
+		r.stats.LastError = err.Error()
+	}
+	if deleted > 0 {
+		log.Printf("reaped %d expired rows from %s in %s", deleted, r.shard.Name, r.stats.LastDuration)
+	}
+}
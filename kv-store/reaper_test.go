@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReaperDisabled(t *testing.T) {
+	rs := NewReplicaSet("kvstore_1", nil, 1, 1)
+	rs.StartReaper(ReaperConfig{})
+	if _, ok := rs.ReaperStats(); ok {
+		t.Error("reaper started with a zero interval")
+	}
+}
+
+func TestReaperStats(t *testing.T) {
+	rs := NewReplicaSet("kvstore_1", nil, 1, 1)
+	rs.StartReaper(ReaperConfig{Interval: time.Hour, Grace: time.Minute, BatchSize: 10})
+	defer rs.Close()
+
+	start := time.Now()
+	rs.reaper.record(start, 25, nil)
+	rs.reaper.record(start, 5, []error{errors.New("replica down")})
+
+	stats, ok := rs.ReaperStats()
+	if !ok {
+		t.Fatal("reaper not started")
+	}
+	if stats.Shard != "kvstore_1" || stats.Runs != 2 || stats.DeletedRows != 30 || stats.LastDeleted != 5 {
+		t.Errorf("stats = %+v, want 2 runs deleting 30 rows, 5 in the last one", stats)
+	}
+	if stats.LastError != "replica down" {
+		t.Errorf("last error = %q, want %q", stats.LastError, "replica down")
+	}
+
+	rs.reaper.record(start, 0, nil)
+	if stats, _ := rs.ReaperStats(); stats.LastError != "" {
+		t.Errorf("last error = %q after a successful run", stats.LastError)
+	}
+}
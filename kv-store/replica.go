@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// replicaTimeout bounds each query sent to a replica. Queries outlive the
+// request that started them, so that replicas slower than the quorum are
+// still written or repaired.
+const replicaTimeout = 5 * time.Second
+
+// ReplicationConfig describes how each shard is replicated.
+type ReplicationConfig struct {
+	// Servers lists the Postgres servers as host:port. Every shard has a
+	// replica on each of them.
+	Servers []string `json:"servers"`
+	// WriteQuorum and ReadQuorum are the number of replicas that must
+	// acknowledge a write or answer a read. Reads see the latest write when
+	// WriteQuorum + ReadQuorum is greater than the number of servers.
+	WriteQuorum int `json:"write_quorum"`
+	ReadQuorum  int `json:"read_quorum"`
+}
+
+// withDefaults fills the missing fields of cfg: a single local server, and
+// majority quorums.
+func (cfg ReplicationConfig) withDefaults() ReplicationConfig {
+	if len(cfg.Servers) == 0 {
+		cfg.Servers = []string{"localhost:5432"}
+	}
+	majority := len(cfg.Servers)/2 + 1
+	if cfg.WriteQuorum == 0 {
+		cfg.WriteQuorum = majority
+	}
+	if cfg.ReadQuorum == 0 {
+		cfg.ReadQuorum = majority
+	}
+	return cfg
+}
+
+func (cfg ReplicationConfig) validate() error {
+	n := len(cfg.Servers)
+	if cfg.WriteQuorum < 1 || cfg.WriteQuorum > n {
+		return fmt.Errorf("write quorum must be between 1 and %d, got %d", n, cfg.WriteQuorum)
+	}
+	if cfg.ReadQuorum < 1 || cfg.ReadQuorum > n {
+		return fmt.Errorf("read quorum must be between 1 and %d, got %d", n, cfg.ReadQuorum)
+	}
+	return nil
+}
+
+// Row is the stored state of a key. Deleted keys are kept as rows that
+// expired, so that the deletion has a version and wins over older values.
+type Row struct {
+	Key       string
+	Value     string
+	ExpiresAt sql.NullTime
+	// Version orders the writes of a key. Replicas keep the row with the
+	// highest version.
+	Version int64
+}
+
+// Live reports whether the row holds a value that hasn't expired.
+func (r Row) Live(now time.Time) bool {
+	return !r.ExpiresAt.Valid || r.ExpiresAt.Time.After(now)
+}
+
+// lastVersion is the last version issued by newVersion.
+var lastVersion atomic.Int64
+
+// newVersion returns a version for a new write. Versions are timestamps, so
+// that the versions issued by several kv-store processes are comparable, and
+// the last writer wins as long as their clocks agree. Within a process,
+// versions always increase.
+func newVersion() int64 {
+	return newVersionAfter(0)
+}
+
+// newVersionAfter returns a version for a new write that is greater than
+// current, the version of the value it replaces, even if current was issued
+// by a process whose clock is ahead.
+func newVersionAfter(current int64) int64 {
+	for {
+		last := lastVersion.Load()
+		version := max(time.Now().UnixNano(), last+1, current+1)
+		if lastVersion.CompareAndSwap(last, version) {
+			return version
+		}
+	}
+}
+
+// ReplicaSet is a shard stored on several databases. Writes and reads go to
+// every replica, and complete once a quorum of them answered.
+type ReplicaSet struct {
+	Name     string
+	replicas []*sql.DB
+	w, r     int
+	// reaper removes the expired rows. It's nil until StartReaper is called.
+	reaper *Reaper
+	// put writes rows to a replica. It's putRows, except in tests.
+	put func(ctx context.Context, db *sql.DB, rows []Row) error
+}
+
+func NewReplicaSet(name string, replicas []*sql.DB, w, r int) *ReplicaSet {
+	return &ReplicaSet{
+		Name:     name,
+		replicas: replicas,
+		w:        w,
+		r:        r,
+		put:      putRows,
+	}
+}
+
+func (rs *ReplicaSet) Close() error {
+	if rs.reaper != nil {
+		rs.reaper.Stop()
+	}
+
+	var errs []error
+	for _, db := range rs.replicas {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// replicaResult is the answer of a single replica.
+type replicaResult struct {
+	replica int
+	rows    []Row
+	err     error
+}
+
+// query runs fn on every replica concurrently, and returns the answers of the
+// first quorum replicas that succeeded. The answers of the numPending other
+// replicas are sent to pending afterward. Queries outlive ctx, so that they
+// complete even when the caller returned with a quorum.
+func (rs *ReplicaSet) query(
+	ctx context.Context,
+	quorum int,
+	op string,
+	fn func(ctx context.Context, db *sql.DB) ([]Row, error),
+) (answers []replicaResult, pending <-chan replicaResult, numPending int, err error) {
+	// The channel is buffered, so that the replicas slower than the quorum
+	// don't block once nobody waits for them.
+	results := make(chan replicaResult, len(rs.replicas))
+	for i, db := range rs.replicas {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), replicaTimeout)
+			defer cancel()
+
+			rows, err := fn(ctx, db)
+			if err != nil {
+				err = fmt.Errorf("replica %d of %s: %v", i, rs.Name, err)
+			}
+			results <- replicaResult{replica: i, rows: rows, err: err}
+		}()
+	}
+
+	var errs []error
+	for len(answers) < quorum {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res.err)
+			if len(rs.replicas)-len(errs) < quorum {
+				return nil, nil, 0, fmt.Errorf("%s quorum not reached on %s: %d replicas answered, %d needed: %w",
+					op, rs.Name, len(answers), quorum, errors.Join(errs...))
+			}
+			continue
+		}
+		answers = append(answers, res)
+	}
+
+	return answers, results, len(rs.replicas) - len(answers) - len(errs), nil
+}
+
+// Put writes row to the replicas. See PutMany.
+func (rs *ReplicaSet) Put(ctx context.Context, row Row) error {
+	return rs.PutMany(ctx, []Row{row})
+}
+
+// PutMany writes rows to every replica, and returns once the write quorum
+// acknowledged them. A replica that already has a more recent version of a
+// key keeps it.
+func (rs *ReplicaSet) PutMany(ctx context.Context, rows []Row) error {
+	_, _, _, err := rs.query(ctx, rs.w, "write", func(ctx context.Context, db *sql.DB) ([]Row, error) {
+		return nil, rs.put(ctx, db, rows)
+	})
+	return err
+}
+
+// putRows writes rows to a single replica in one statement, except for the
+// keys that have a more recent version there.
+func putRows(ctx context.Context, db *sql.DB, rows []Row) error {
+	keys := make([]string, len(rows))
+	values := make([]string, len(rows))
+	// Timestamps are sent as text, since pq can't encode arrays of them.
+	expiresAt := make([]sql.NullString, len(rows))
+	keyHashes := make([]int64, len(rows))
+	versions := make([]int64, len(rows))
+	for i, row := range rows {
+		keys[i] = row.Key
+		values[i] = row.Value
+		if row.ExpiresAt.Valid {
+			expiresAt[i] = sql.NullString{String: row.ExpiresAt.Time.Format(time.RFC3339Nano), Valid: true}
+		}
+		keyHashes[i] = int64(hashKey(row.Key))
+		versions[i] = row.Version
+	}
+
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO kv (key, value, expires_at, key_hash, version)
+	SELECT * FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::bigint[], $5::bigint[])
+	ON CONFLICT (key) DO UPDATE
+	SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at, version = EXCLUDED.version
+	WHERE kv.version < EXCLUDED.version
+	`, pq.Array(keys), pq.Array(values), pq.Array(expiresAt), pq.Array(keyHashes), pq.Array(versions))
+	if err != nil {
+		return fmt.Errorf("error writing rows: %v", err)
+	}
+
+	return nil
+}
+
+// Get reads key from the replicas. See GetMany.
+func (rs *ReplicaSet) Get(ctx context.Context, key string) (Row, bool, error) {
+	rows, err := rs.GetMany(ctx, []string{key})
+	if err != nil {
+		return Row{}, false, err
+	}
+
+	row, ok := rows[key]
+	return row, ok, nil
+}
+
+// GetMany reads keys from every replica and returns the most recent row of
+// each key among the first read quorum of answers, including rows of deleted
+// keys. Replicas that answered with an older row, or none, are repaired in
+// the background.
+func (rs *ReplicaSet) GetMany(ctx context.Context, keys []string) (map[string]Row, error) {
+	answers, pending, numPending, err := rs.query(ctx, rs.r, "read", func(ctx context.Context, db *sql.DB) ([]Row, error) {
+		return selectRows(ctx, db, `
+		SELECT key, value, expires_at, version FROM kv WHERE key = ANY($1)
+		`, pq.Array(keys))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]Row)
+	for _, res := range answers {
+		mergeLatest(latest, res.rows)
+	}
+
+	if len(latest) > 0 {
+		go rs.repair(context.WithoutCancel(ctx), latest, answers, pending, numPending)
+	}
+
+	return latest, nil
+}
+
+// mergeLatest adds rows to latest, unless latest has a more recent version of
+// their key.
+func mergeLatest(latest map[string]Row, rows []Row) {
+	for _, row := range rows {
+		if prev, ok := latest[row.Key]; !ok || row.Version > prev.Version {
+			latest[row.Key] = row
+		}
+	}
+}
+
+func selectRows(ctx context.Context, db *sql.DB, query string, args ...any) ([]Row, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting rows: %v", err)
+	}
+	defer rows.Close()
+
+	var retval []Row
+	for rows.Next() {
+		var row Row
+		if err := rows.Scan(&row.Key, &row.Value, &row.ExpiresAt, &row.Version); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		retval = append(retval, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return retval, nil
+}
+
+// repair writes the latest rows to the replicas that answered with an older
+// row, or none, including the numPending replicas that answer after the
+// quorum.
+func (rs *ReplicaSet) repair(ctx context.Context, latest map[string]Row, answers []replicaResult, pending <-chan replicaResult, numPending int) {
+	repairIfStale := func(res replicaResult) {
+		if res.err != nil {
+			return
+		}
+
+		versions := make(map[string]int64, len(res.rows))
+		for _, row := range res.rows {
+			versions[row.Key] = row.Version
+		}
+		var stale []Row
+		for key, row := range latest {
+			if version, ok := versions[key]; !ok || version < row.Version {
+				stale = append(stale, row)
+			}
+		}
+		if len(stale) == 0 {
+			return
+		}
+
+		log.Printf("repairing %d keys on replica %d of %s", len(stale), res.replica, rs.Name)
+		ctx, cancel := context.WithTimeout(ctx, replicaTimeout)
+		defer cancel()
+		if err := rs.put(ctx, rs.replicas[res.replica], stale); err != nil {
+			log.Printf("error repairing replica %d of %s: %v", res.replica, rs.Name, err)
+		}
+	}
+
+	for _, res := range answers {
+		repairIfStale(res)
+	}
+	for range numPending {
+		repairIfStale(<-pending)
+	}
+}
+
+// scan returns up to limit rows matching the where clause, ordered by key,
+// from the first quorum replicas that answer. Each replica returns its first
+// rows, so the merged rows are complete up to the limit-th key. The most
+// recent row of each key is kept, including rows of deleted keys.
+func (rs *ReplicaSet) scan(ctx context.Context, quorum int, limit int, where string, args ...any) ([]Row, error) {
+	query := fmt.Sprintf(`
+	SELECT key, value, expires_at, version FROM kv
+	WHERE %s
+	ORDER BY key
+	LIMIT %d
+	`, where, limit)
+	answers, _, _, err := rs.query(ctx, quorum, "scan", func(ctx context.Context, db *sql.DB) ([]Row, error) {
+		return selectRows(ctx, db, query, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]Row)
+	for _, res := range answers {
+		mergeLatest(latest, res.rows)
+	}
+
+	keys := slices.Sorted(maps.Keys(latest))
+	rows := make([]Row, 0, min(len(keys), limit))
+	for _, key := range keys[:min(len(keys), limit)] {
+		rows = append(rows, latest[key])
+	}
+
+	return rows, nil
+}
+
+// ScanMove returns up to limit rows in the hash range of move whose key comes
+// after afterKey, ordered by key. It reads enough replicas to see every write
+// acknowledged by a write quorum.
+func (rs *ReplicaSet) ScanMove(ctx context.Context, move Move, afterKey string, limit int) ([]Row, error) {
+	return rs.scan(ctx, len(rs.replicas)-rs.w+1, limit,
+		`key_hash > $1 AND key_hash <= $2 AND key > $3`, move.Start, move.End, afterKey)
+}
+
+// ScanKeys returns up to limit rows whose key starts with prefix, is at least
+// start and comes after afterKey, ordered by key, from a read quorum of
+// replicas.
+func (rs *ReplicaSet) ScanKeys(ctx context.Context, prefix, start, afterKey string, limit int) ([]Row, error) {
+	return rs.scan(ctx, rs.r, limit, `key LIKE $1 AND key >= $2 AND key > $3`,
+		escapeLike(prefix)+"%", start, afterKey)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// DeleteKeys removes keys from every replica it can reach, and returns the
+// errors of the others.
+func (rs *ReplicaSet) DeleteKeys(ctx context.Context, keys []string) error {
+	var errs []error
+	for i, db := range rs.replicas {
+		_, err := db.ExecContext(ctx, `DELETE FROM kv WHERE key = ANY($1)`, pq.Array(keys))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error deleting from replica %d of %s: %v", i, rs.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
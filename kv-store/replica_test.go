@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sqlTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+func TestReplicationConfigDefaults(t *testing.T) {
+	cfg := ReplicationConfig{}.withDefaults()
+	if len(cfg.Servers) != 1 || cfg.WriteQuorum != 1 || cfg.ReadQuorum != 1 {
+		t.Errorf("default config = %+v, want a single server with quorums of 1", cfg)
+	}
+
+	cfg = ReplicationConfig{Servers: []string{"a:5432", "b:5432", "c:5432"}}.withDefaults()
+	if cfg.WriteQuorum != 2 || cfg.ReadQuorum != 2 {
+		t.Errorf("quorums = %d/%d, want majorities of 2", cfg.WriteQuorum, cfg.ReadQuorum)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+
+	for _, cfg := range []ReplicationConfig{
+		{Servers: []string{"a:5432"}, WriteQuorum: 2, ReadQuorum: 1},
+		{Servers: []string{"a:5432"}, WriteQuorum: 1, ReadQuorum: -1},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestNewVersionIncreases(t *testing.T) {
+	prev := newVersion()
+	for range 1000 {
+		v := newVersion()
+		if v <= prev {
+			t.Fatalf("version %d after %d", v, prev)
+		}
+		prev = v
+	}
+}
+
+func TestRowLive(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		row  Row
+		want bool
+	}{
+		"no expiry": {Row{}, true},
+		"expires":   {Row{ExpiresAt: sqlTime(now.Add(time.Minute))}, true},
+		"expired":   {Row{ExpiresAt: sqlTime(now.Add(-time.Minute))}, false},
+		"deleted":   {Row{ExpiresAt: sqlTime(now)}, false},
+	}
+	for name, test := range tests {
+		if got := test.row.Live(now); got != test.want {
+			t.Errorf("%s: Live() = %v, want %v", name, got, test.want)
+		}
+	}
+}
+
+// fakeReplicaSet returns a replica set of n replicas that can't be queried,
+// and the index of each of them.
+func fakeReplicaSet(n, w, r int) (*ReplicaSet, map[*sql.DB]int) {
+	replicas := make([]*sql.DB, n)
+	index := make(map[*sql.DB]int, n)
+	for i := range replicas {
+		replicas[i] = new(sql.DB)
+		index[replicas[i]] = i
+	}
+	return NewReplicaSet("kvstore_1", replicas, w, r), index
+}
+
+// replicaAnswer is the fake answer of a replica.
+type replicaAnswer struct {
+	rows  []Row
+	err   error
+	delay time.Duration
+}
+
+func fakeQuery(index map[*sql.DB]int, answers []replicaAnswer) func(context.Context, *sql.DB) ([]Row, error) {
+	return func(ctx context.Context, db *sql.DB) ([]Row, error) {
+		answer := answers[index[db]]
+		time.Sleep(answer.delay)
+		return answer.rows, answer.err
+	}
+}
+
+func answeredReplicas(answers []replicaResult) []int {
+	var replicas []int
+	for _, res := range answers {
+		replicas = append(replicas, res.replica)
+	}
+	slices.Sort(replicas)
+	return replicas
+}
+
+func TestQueryQuorum(t *testing.T) {
+	errDown := errors.New("connection refused")
+	tests := map[string]struct {
+		answers     []replicaAnswer
+		quorum      int
+		wantErr     bool
+		wantAnswers []int
+		wantPending int
+	}{
+		"all answer": {
+			answers:     []replicaAnswer{{}, {delay: 50 * time.Millisecond}, {}},
+			quorum:      2,
+			wantAnswers: []int{0, 2},
+			wantPending: 1,
+		},
+		"one down": {
+			answers:     []replicaAnswer{{err: errDown}, {}, {delay: 10 * time.Millisecond}},
+			quorum:      2,
+			wantAnswers: []int{1, 2},
+		},
+		"one down after the quorum": {
+			answers:     []replicaAnswer{{err: errDown, delay: 50 * time.Millisecond}, {}, {}},
+			quorum:      2,
+			wantAnswers: []int{1, 2},
+			wantPending: 1,
+		},
+		"quorum not reached": {
+			answers: []replicaAnswer{{err: errDown}, {}, {err: errDown, delay: 10 * time.Millisecond}},
+			quorum:  2,
+			wantErr: true,
+		},
+		"all needed": {
+			answers:     []replicaAnswer{{delay: 10 * time.Millisecond}, {}, {}},
+			quorum:      3,
+			wantAnswers: []int{0, 1, 2},
+		},
+	}
+	for name, test := range tests {
+		rs, index := fakeReplicaSet(3, 2, 2)
+		answers, pending, numPending, err := rs.query(context.Background(), test.quorum, "read", fakeQuery(index, test.answers))
+		if test.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "read quorum not reached") {
+				t.Errorf("%s: err = %v, want a quorum error", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if got := answeredReplicas(answers); !slices.Equal(got, test.wantAnswers) {
+			t.Errorf("%s: answered replicas = %v, want %v", name, got, test.wantAnswers)
+		}
+		if numPending != test.wantPending {
+			t.Errorf("%s: %d pending replicas, want %d", name, numPending, test.wantPending)
+		}
+		// The replicas slower than the quorum still answer.
+		for range numPending {
+			<-pending
+		}
+	}
+}
+
+func TestPutManyQuorum(t *testing.T) {
+	rs, index := fakeReplicaSet(3, 2, 2)
+	var mu sync.Mutex
+	down := map[int]bool{}
+	rs.put = func(ctx context.Context, db *sql.DB, rows []Row) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[index[db]] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	row := Row{Key: "a", Value: "1", Version: 1}
+	mu.Lock()
+	down[0] = true
+	mu.Unlock()
+	if err := rs.PutMany(context.Background(), []Row{row}); err != nil {
+		t.Fatalf("write with a replica down: %v", err)
+	}
+
+	mu.Lock()
+	down[1] = true
+	mu.Unlock()
+	err := rs.PutMany(context.Background(), []Row{row})
+	if err == nil || !strings.Contains(err.Error(), "write quorum not reached") {
+		t.Errorf("write with two replicas down: err = %v, want a quorum error", err)
+	}
+}
+
+func TestMergeLatest(t *testing.T) {
+	now := time.Now()
+	latest := make(map[string]Row)
+	mergeLatest(latest, []Row{
+		{Key: "a", Value: "old", Version: 1},
+		{Key: "b", Value: "1", Version: 5},
+	})
+	mergeLatest(latest, []Row{
+		{Key: "a", Value: "new", Version: 3},
+		{Key: "b", Value: "stale", Version: 4},
+		// A deletion wins over the older values.
+		{Key: "c", ExpiresAt: sqlTime(now), Version: 2},
+	})
+	mergeLatest(latest, []Row{
+		{Key: "c", Value: "before delete", Version: 1},
+	})
+
+	want := map[string]string{"a": "new", "b": "1", "c": ""}
+	for key, value := range want {
+		if latest[key].Value != value {
+			t.Errorf("latest[%q] = %q, want %q", key, latest[key].Value, value)
+		}
+	}
+	if latest["c"].Live(now) {
+		t.Errorf("deleted key c is live")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	rs, index := fakeReplicaSet(4, 2, 2)
+	var mu sync.Mutex
+	repaired := make(map[int][]string)
+	rs.put = func(ctx context.Context, db *sql.DB, rows []Row) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, row := range rows {
+			repaired[index[db]] = append(repaired[index[db]], row.Key)
+		}
+		return nil
+	}
+
+	latest := map[string]Row{
+		"a": {Key: "a", Value: "new", Version: 3},
+		"b": {Key: "b", Value: "1", Version: 2},
+	}
+	answers := []replicaResult{
+		// Up to date.
+		{replica: 0, rows: []Row{latest["a"], latest["b"]}},
+		// Older a, missing b.
+		{replica: 1, rows: []Row{{Key: "a", Value: "old", Version: 1}}},
+	}
+	pending := make(chan replicaResult, 2)
+	// A replica that answers after the quorum is repaired too, unless it
+	// failed.
+	pending <- replicaResult{replica: 2, rows: []Row{latest["a"]}}
+	pending <- replicaResult{replica: 3, err: errors.New("connection refused")}
+
+	rs.repair(context.Background(), latest, answers, pending, 2)
+
+	want := map[int][]string{1: {"a", "b"}, 2: {"b"}}
+	if len(repaired) != len(want) {
+		t.Errorf("repaired replicas = %v, want %v", repaired, want)
+	}
+	for replica, keys := range want {
+		got := slices.Sorted(slices.Values(repaired[replica]))
+		if !slices.Equal(got, keys) {
+			t.Errorf("replica %d: repaired keys %v, want %v", replica, got, keys)
+		}
+	}
+}
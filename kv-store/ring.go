@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// virtualNodes is the number of points each shard has on the hash ring. More
+// points spread the keys more evenly, at the cost of a bigger ring.
+const virtualNodes = 128
+
+// HashRing is a consistent-hash ring, the hash sharding strategy. A key
+// belongs to the shard of the first point at or after the hash of the key, so
+// adding or removing a shard only moves the keys next to its points.
+type HashRing struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash  uint32
+	shard string
+}
+
+func NewHashRing(shards []string) *HashRing {
+	r := &HashRing{}
+	for _, shard := range shards {
+		r.Add(shard)
+	}
+	return r
+}
+
+// Add places the virtual nodes of shard on the ring.
+func (r *HashRing) Add(shard string) {
+	for i := range virtualNodes {
+		r.points = append(r.points, ringPoint{
+			hash:  hashKey(fmt.Sprintf("%s#%d", shard, i)),
+			shard: shard,
+		})
+	}
+	// Ties are broken by name, so that the ring doesn't depend on the order
+	// shards were added in.
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.shard, b.shard))
+	})
+}
+
+// Remove takes the virtual nodes of shard off the ring.
+func (r *HashRing) Remove(shard string) {
+	r.points = slices.DeleteFunc(r.points, func(p ringPoint) bool {
+		return p.shard == shard
+	})
+}
+
+func (r *HashRing) Shards() []string {
+	var shards []string
+	for _, p := range r.points {
+		if !slices.Contains(shards, p.shard) {
+			shards = append(shards, p.shard)
+		}
+	}
+	slices.Sort(shards)
+	return shards
+}
+
+// PrefixShards returns every shard, since hashing spreads the keys sharing a
+// prefix over the ring.
+func (r *HashRing) PrefixShards(prefix string) []string {
+	return r.Shards()
+}
+
+func (r *HashRing) Clone() *HashRing {
+	return &HashRing{points: slices.Clone(r.points)}
+}
+
+func (r *HashRing) Shard(key string) string {
+	return r.owner(hashKey(key))
+}
+
+// owner returns the shard that owns the given key hash.
+func (r *HashRing) owner(hash uint32) string {
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		// Wrap around to the first point.
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+// Move is a range of key hashes whose owner changes between two rings. The
+// range goes from Start, exclusive, to End, inclusive.
+type Move struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+// Diff returns the ranges of key hashes that belong to a different shard in
+// next, which are the only keys to migrate when going from r to next.
+func (r *HashRing) Diff(next *HashRing) []Move {
+	var bounds []uint32
+	for _, p := range r.points {
+		bounds = append(bounds, p.hash)
+	}
+	for _, p := range next.points {
+		bounds = append(bounds, p.hash)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	var moves []Move
+	addMove := func(start, end int64, hash uint32) {
+		from, to := r.owner(hash), next.owner(hash)
+		if from == to {
+			return
+		}
+		// Merge with the previous range when the owners are the same.
+		if n := len(moves); n > 0 && moves[n-1].End == start && moves[n-1].From == from && moves[n-1].To == to {
+			moves[n-1].End = end
+			return
+		}
+		moves = append(moves, Move{From: from, To: to, Start: start, End: end})
+	}
+
+	// No point lies between two consecutive bounds, so every hash in between
+	// has the same owner as the upper bound. The hashes before the first bound
+	// and after the last one belong to the first point.
+	addMove(-1, int64(bounds[0]), bounds[0])
+	for i := 1; i < len(bounds); i++ {
+		addMove(int64(bounds[i-1]), int64(bounds[i]), bounds[i])
+	}
+	addMove(int64(bounds[len(bounds)-1]), math.MaxUint32, 0)
+
+	return moves
+}
+
+// hashKey hashes key with 32-bit FNV-1a, followed by the MurmurHash3
+// finalizer since FNV alone spreads similar keys, like the virtual node names,
+// poorly. It's inlined to avoid allocating a hash.Hash32 for every request.
+func hashKey(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+
+	h := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime32
+	}
+
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
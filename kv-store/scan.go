@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultScanLimit and maxScanLimit bound the number of keys returned by
+	// a single scan.
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// ScanOptions selects the keys of a scan.
+type ScanOptions struct {
+	// Prefix restricts the scan to the keys starting with it.
+	Prefix string
+	// Start is the first key to return, inclusive.
+	Start string
+	// Cursor resumes a previous scan. It takes precedence over Start.
+	Cursor string
+	// Limit is the maximum number of keys to return.
+	Limit int
+}
+
+// ScanPage is a page of keys returned by a scan, ordered by key.
+type ScanPage struct {
+	Rows []Row
+	// NextCursor resumes the scan after the last row. It's empty when the scan
+	// is over. A full page always returns a cursor, so the next page may be
+	// empty.
+	NextCursor string
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor returns an opaque cursor that resumes a scan after key.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidCursor, err)
+	}
+	return string(key), nil
+}
+
+// keyScanner reads the keys of a shard. ReplicaSet implements it.
+type keyScanner interface {
+	ScanKeys(ctx context.Context, prefix, start, afterKey string, limit int) ([]Row, error)
+}
+
+// shardScanner reads the keys of a scan from a shard, one page at a time.
+type shardScanner struct {
+	name  string
+	shard keyScanner
+	rows  []Row
+	// done is set once the shard returned its last page.
+	done bool
+}
+
+// peek returns the next row of the shard, fetching the page of keys after
+// afterKey if the previous page has been consumed. Every shard is peeked before
+// a key is consumed, so afterKey never skips a key of the shard.
+func (s *shardScanner) peek(ctx context.Context, opts ScanOptions, afterKey string) (Row, bool, error) {
+	if len(s.rows) == 0 && !s.done {
+		rows, err := s.shard.ScanKeys(ctx, opts.Prefix, opts.Start, afterKey, opts.Limit)
+		if err != nil {
+			return Row{}, false, err
+		}
+		s.rows = rows
+		s.done = len(rows) < opts.Limit
+	}
+	if len(s.rows) == 0 {
+		return Row{}, false, nil
+	}
+	return s.rows[0], true, nil
+}
+
+// Scan returns the live keys selected by opts, ordered by key, merging the
+// keys of the shards that may hold the prefix. A shard may hold a stale copy
+// of a key it doesn't own, e.g. after a rebalance failed to delete it, so each
+// key is only read from the shards routed for it. Deleted and expired keys are
+// skipped.
+func (k *KVStore) Scan(ctx context.Context, opts ScanOptions) (ScanPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultScanLimit
+	}
+	opts.Limit = min(opts.Limit, maxScanLimit)
+
+	afterKey := ""
+	if opts.Cursor != "" {
+		var err error
+		afterKey, err = decodeCursor(opts.Cursor)
+		if err != nil {
+			return ScanPage{}, err
+		}
+	}
+
+	route, shards, release := k.shardManager.ScanSnapshot(opts.Prefix)
+	defer release()
+
+	scanners := make([]*shardScanner, len(shards))
+	for i, shard := range shards {
+		scanners[i] = &shardScanner{name: shard.Name, shard: shard}
+	}
+	routed := func(shard keyScanner, key string) bool {
+		r := route(key)
+		return shard == r.Owner || shard == r.Previous
+	}
+	return mergeScan(ctx, opts, afterKey, scanners, routed, time.Now())
+}
+
+// mergeScan merges the keys of scanners after afterKey into a page, keeping
+// the latest version of each key among the shards routed for it.
+func mergeScan(ctx context.Context, opts ScanOptions, afterKey string, scanners []*shardScanner,
+	routed func(shard keyScanner, key string) bool, now time.Time) (ScanPage, error) {
+	var page ScanPage
+	for len(page.Rows) < opts.Limit {
+		// Find the smallest key among the shards, along with the shards
+		// holding it.
+		var key string
+		var holders []*shardScanner
+		for _, s := range scanners {
+			row, ok, err := s.peek(ctx, opts, afterKey)
+			if err != nil {
+				return ScanPage{}, fmt.Errorf("error scanning shard %s: %v", s.name, err)
+			}
+			if !ok {
+				continue
+			}
+			switch {
+			case len(holders) == 0 || row.Key < key:
+				key = row.Key
+				holders = []*shardScanner{s}
+			case row.Key == key:
+				holders = append(holders, s)
+			}
+		}
+		if len(holders) == 0 {
+			return page, nil
+		}
+
+		var latest Row
+		found := false
+		for _, s := range holders {
+			row := s.rows[0]
+			s.rows = s.rows[1:]
+			if !routed(s.shard, key) {
+				continue
+			}
+			if !found || row.Version > latest.Version {
+				latest, found = row, true
+			}
+		}
+		afterKey = key
+
+		if found && latest.Live(now) {
+			page.Rows = append(page.Rows, latest)
+		}
+	}
+
+	page.NextCursor = encodeCursor(afterKey)
+	return page, nil
+}
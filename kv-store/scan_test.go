@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	for _, key := range []string{"a", "user:42/profile", "ключ", "with space"} {
+		got, err := decodeCursor(encodeCursor(key))
+		if err != nil {
+			t.Fatalf("decodeCursor(encodeCursor(%q)): %v", key, err)
+		}
+		if got != key {
+			t.Errorf("decodeCursor(encodeCursor(%q)) = %q", key, got)
+		}
+	}
+
+	if _, err := decodeCursor("not a cursor!"); !errors.Is(err, errInvalidCursor) {
+		t.Errorf("decodeCursor of an invalid cursor returned %v, want errInvalidCursor", err)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"user:":      "user:",
+		"50%":        `50\%`,
+		"a_b":        `a\_b`,
+		`back\slash`: `back\\slash`,
+	}
+	for prefix, want := range tests {
+		if got := escapeLike(prefix); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+// fakeShard is a shard holding rows sorted by key.
+type fakeShard struct {
+	rows []Row
+	// pages counts the calls to ScanKeys.
+	pages int
+}
+
+func (s *fakeShard) ScanKeys(ctx context.Context, prefix, start, afterKey string, limit int) ([]Row, error) {
+	s.pages++
+	var rows []Row
+	for _, row := range s.rows {
+		if strings.HasPrefix(row.Key, prefix) && row.Key >= start && row.Key > afterKey && len(rows) < limit {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func TestMergeScan(t *testing.T) {
+	now := time.Now()
+	deleted := sql.NullTime{Time: now.Add(-time.Second), Valid: true}
+	shard1 := &fakeShard{rows: []Row{
+		{Key: "a", Value: "1", Version: 1},
+		{Key: "c", Value: "old", Version: 1},
+		{Key: "e", Value: "stale", Version: 9},
+		{Key: "g", Value: "7", Version: 1},
+	}}
+	shard2 := &fakeShard{rows: []Row{
+		{Key: "b", Value: "2", Version: 1},
+		{Key: "c", Value: "new", Version: 2},
+		{Key: "d", Value: "deleted", Version: 2, ExpiresAt: deleted},
+		{Key: "e", Value: "5", Version: 1},
+		{Key: "f", Value: "6", Version: 1},
+	}}
+	// Every key is routed to shard2, and c is being moved from shard1. shard1
+	// still holds a stale copy of e, which it doesn't own anymore.
+	routed := func(shard keyScanner, key string) bool {
+		return shard == shard2 || (key == "c" && shard == shard1)
+	}
+
+	var keys []string
+	afterKey := ""
+	for range 10 {
+		scanners := []*shardScanner{
+			{name: "shard1", shard: shard1},
+			{name: "shard2", shard: shard2},
+		}
+		page, err := mergeScan(context.Background(), ScanOptions{Limit: 2}, afterKey, scanners, routed, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range page.Rows {
+			keys = append(keys, row.Key+"="+row.Value)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if afterKey, err = decodeCursor(page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "[b=2 c=new e=5 f=6]"
+	if got := fmt.Sprint(keys); got != want {
+		t.Errorf("scanned %s, want %s", got, want)
+	}
+}
+
+func TestMergeScanPrefix(t *testing.T) {
+	shard := &fakeShard{rows: []Row{
+		{Key: "tenant-1/a", Version: 1},
+		{Key: "tenant-1/b", Version: 1},
+		{Key: "tenant-2/a", Version: 1},
+	}}
+	routed := func(keyScanner, string) bool { return true }
+
+	opts := ScanOptions{Prefix: "tenant-1/", Start: "tenant-1/b", Limit: 10}
+	scanners := []*shardScanner{{name: "shard", shard: shard}}
+	page, err := mergeScan(context.Background(), opts, "", scanners, routed, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Rows) != 1 || page.Rows[0].Key != "tenant-1/b" || page.NextCursor != "" {
+		t.Errorf("mergeScan = %+v, want tenant-1/b and no cursor", page)
+	}
+	// A short page means the shard has no more keys, so it's only read once.
+	if shard.pages != 1 {
+		t.Errorf("shard read %d times, want 1", shard.pages)
+	}
+}
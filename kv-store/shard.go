@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// migrationBatchSize is the number of keys moved between shards in a
+	// single transaction during a rebalance.
+	migrationBatchSize = 500
+	// migrationMaxBackoff caps the delay before a failed batch is retried.
+	migrationMaxBackoff = 30 * time.Second
+)
+
+var (
+	errReshardingUnsupported = errors.New("only the hash strategy supports adding and removing shards")
+	errRebalancing           = errors.New("a rebalance is already in progress")
+	errShardExists           = errors.New("shard already exists")
+	errShardNotFound         = errors.New("shard not found")
+	errLastShard             = errors.New("cannot remove the last shard")
+)
+
+// Route tells which shards to use for a key.
+type Route struct {
+	// Owner is the shard that owns the key.
+	Owner *ReplicaSet
+	// Previous is the shard that owned the key before the ongoing rebalance.
+	// It's nil unless the key is being moved, in which case it may still
+	// hold the key.
+	Previous *ReplicaSet
+}
+
+// RebalanceStatus reports the progress of the last rebalance.
+type RebalanceStatus struct {
+	Running    bool       `json:"running"`
+	Operation  string     `json:"operation"`
+	Shard      string     `json:"shard"`
+	Moves      []Move     `json:"moves"`
+	MovedKeys  int64      `json:"moved_keys"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ShardManager picks a shard for a given record key using a sharding
+// strategy. With the hash strategy, it also moves keys between shards when
+// shards are added or removed.
+type ShardManager struct {
+	// mu is held for reading while a request uses the shards of a key, so
+	// that a rebalance only starts once no request routes with the old
+	// strategy.
+	mu sync.RWMutex
+	// shards holds every open shard, including a removed shard until its
+	// keys have been moved.
+	shards   map[string]*ReplicaSet
+	strategy Strategy
+	// prevRing is the ring before the ongoing rebalance. It's nil when no
+	// rebalance is running.
+	prevRing *HashRing
+
+	// statusMu guards rebalance, so that reporting progress doesn't wait for
+	// in-flight requests.
+	statusMu  sync.Mutex
+	rebalance *RebalanceStatus
+}
+
+// NewShardManager returns a manager that routes keys to shards with
+// strategy. shards must hold every shard the strategy routes to.
+func NewShardManager(strategy Strategy, shards []*ReplicaSet) (*ShardManager, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no data sources provided")
+	}
+
+	sm := &ShardManager{
+		shards:   make(map[string]*ReplicaSet),
+		strategy: strategy,
+	}
+	for _, shard := range shards {
+		sm.shards[shard.Name] = shard
+	}
+	for _, name := range strategy.Shards() {
+		if _, ok := sm.shards[name]; !ok {
+			return nil, fmt.Errorf("no data source provided for shard %s", name)
+		}
+	}
+
+	return sm, nil
+}
+
+// Route returns the shards to use for key. release must be called once the
+// caller is done with them.
+func (sm *ShardManager) Route(key string) (route Route, release func()) {
+	sm.mu.RLock()
+	return sm.route(key), sm.mu.RUnlock
+}
+
+// Snapshot returns a function that routes keys, and every open shard sorted by
+// name, for requests that span many keys. They stay consistent with each
+// other until release is called.
+func (sm *ShardManager) Snapshot() (route func(key string) Route, shards []*ReplicaSet, release func()) {
+	sm.mu.RLock()
+
+	for _, name := range slices.Sorted(maps.Keys(sm.shards)) {
+		shards = append(shards, sm.shards[name])
+	}
+
+	return sm.route, shards, sm.mu.RUnlock
+}
+
+// ScanSnapshot is like Snapshot, but only returns the shards that may hold a
+// key starting with prefix, including the shards the keys are being moved
+// from.
+func (sm *ShardManager) ScanSnapshot(prefix string) (route func(key string) Route, shards []*ReplicaSet, release func()) {
+	sm.mu.RLock()
+
+	names := sm.strategy.PrefixShards(prefix)
+	if sm.prevRing != nil {
+		names = append(names, sm.prevRing.PrefixShards(prefix)...)
+		slices.Sort(names)
+		names = slices.Compact(names)
+	}
+	for _, name := range names {
+		shards = append(shards, sm.shards[name])
+	}
+
+	return sm.route, shards, sm.mu.RUnlock
+}
+
+// route returns the shards to use for key. sm.mu must be held.
+func (sm *ShardManager) route(key string) Route {
+	var route Route
+	owner := sm.strategy.Shard(key)
+	route.Owner = sm.shards[owner]
+	if sm.prevRing != nil {
+		if previous := sm.prevRing.Shard(key); previous != owner {
+			route.Previous = sm.shards[previous]
+		}
+	}
+	return route
+}
+
+// Shards returns the names of the shards on the ring, along with the status
+// of the last rebalance, if any.
+func (sm *ShardManager) Shards() ([]string, *RebalanceStatus) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+
+	var status *RebalanceStatus
+	if sm.rebalance != nil {
+		s := *sm.rebalance
+		status = &s
+	}
+	return sm.strategy.Shards(), status
+}
+
+// ReaperStats returns the stats of the reapers of the open shards, sorted by
+// shard.
+func (sm *ShardManager) ReaperStats() []ReaperStats {
+	_, shards, release := sm.Snapshot()
+	defer release()
+
+	stats := []ReaperStats{}
+	for _, shard := range shards {
+		if s, ok := shard.ReaperStats(); ok {
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
+
+// SupportsResharding reports whether shards can be added and removed, which
+// requires the hash strategy. Moving keys with the other strategies would
+// require new range boundaries or directory entries.
+func (sm *ShardManager) SupportsResharding() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	_, ok := sm.strategy.(*HashRing)
+	return ok
+}
+
+// AddShard adds shard to the ring and moves the keys it now owns in the
+// background.
+func (sm *ShardManager) AddShard(shard *ReplicaSet) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ring, ok := sm.strategy.(*HashRing)
+	if !ok {
+		return errReshardingUnsupported
+	}
+	if sm.prevRing != nil {
+		return errRebalancing
+	}
+	if _, ok := sm.shards[shard.Name]; ok {
+		return errShardExists
+	}
+
+	sm.shards[shard.Name] = shard
+	next := ring.Clone()
+	next.Add(shard.Name)
+	sm.startRebalance("add", shard.Name, ring, next)
+
+	return nil
+}
+
+// RemoveShard takes the shard with the given name off the ring, moves its
+// keys to the remaining shards in the background, and closes it once done.
+func (sm *ShardManager) RemoveShard(name string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ring, ok := sm.strategy.(*HashRing)
+	if !ok {
+		return errReshardingUnsupported
+	}
+	if sm.prevRing != nil {
+		return errRebalancing
+	}
+	if _, ok := sm.shards[name]; !ok {
+		return errShardNotFound
+	}
+	if len(sm.shards) == 1 {
+		return errLastShard
+	}
+
+	next := ring.Clone()
+	next.Remove(name)
+	sm.startRebalance("remove", name, ring, next)
+
+	return nil
+}
+
+// startRebalance switches from ring to the next ring and starts moving keys.
+// sm.mu must be held for writing.
+func (sm *ShardManager) startRebalance(operation, shard string, ring, next *HashRing) {
+	moves := ring.Diff(next)
+	sm.prevRing = ring
+	sm.strategy = next
+
+	sm.statusMu.Lock()
+	sm.rebalance = &RebalanceStatus{
+		Running:   true,
+		Operation: operation,
+		Shard:     shard,
+		Moves:     moves,
+		StartedAt: time.Now(),
+	}
+	sm.statusMu.Unlock()
+
+	log.Printf("rebalance started: %s shard %s, %d hash ranges to move", operation, shard, len(moves))
+	go sm.migrate(operation, shard, moves)
+}
+
+// migrate moves the keys in the given hash ranges to their new shard. Failed
+// batches are retried until they succeed, since reads depend on the previous
+// ring until every key has been moved.
+func (sm *ShardManager) migrate(operation, shard string, moves []Move) {
+	ctx := context.Background()
+
+	for _, move := range moves {
+		sm.mu.RLock()
+		from, to := sm.shards[move.From], sm.shards[move.To]
+		sm.mu.RUnlock()
+
+		afterKey := ""
+		backoff := time.Second
+		for {
+			n, lastKey, err := moveBatch(ctx, from, to, move, afterKey)
+			if err != nil {
+				log.Printf("error moving keys from %s to %s, retrying in %s: %v", move.From, move.To, backoff, err)
+				sm.setRebalanceError(err)
+				time.Sleep(backoff)
+				backoff = min(2*backoff, migrationMaxBackoff)
+				continue
+			}
+			backoff = time.Second
+
+			sm.statusMu.Lock()
+			sm.rebalance.MovedKeys += int64(n)
+			sm.statusMu.Unlock()
+
+			if n < migrationBatchSize {
+				break
+			}
+			afterKey = lastKey
+		}
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if operation == "remove" {
+		if err := sm.shards[shard].Close(); err != nil {
+			log.Printf("error closing shard %s: %v", shard, err)
+		}
+		delete(sm.shards, shard)
+	}
+	sm.prevRing = nil
+
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+	now := time.Now()
+	sm.rebalance.Running = false
+	sm.rebalance.FinishedAt = &now
+	log.Printf("rebalance finished: %s shard %s, moved %d keys", operation, shard, sm.rebalance.MovedKeys)
+}
+
+func (sm *ShardManager) setRebalanceError(err error) {
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+	sm.rebalance.LastError = err.Error()
+}
+
+// moveBatch moves up to migrationBatchSize keys in the hash range of move,
+// ordered by key and starting after afterKey. It returns the number of keys
+// that were scanned and the last of them.
+//
+// Writes to a moving key go to its new shard, with a newer version than the
+// copied row, so the copy never overwrites them.
+func moveBatch(ctx context.Context, from, to *ReplicaSet, move Move, afterKey string) (int, string, error) {
+	rows, err := from.ScanMove(ctx, move, afterKey, migrationBatchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rows) == 0 {
+		return 0, "", nil
+	}
+
+	if err := to.PutMany(ctx, rows); err != nil {
+		return 0, "", fmt.Errorf("error copying keys: %v", err)
+	}
+
+	keys := make([]string, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.Key)
+	}
+
+	// A replica that can't be reached keeps its copy of the keys, which is
+	// never read since the keys are routed to their new shard.
+	if err := from.DeleteKeys(ctx, keys); err != nil {
+		log.Printf("error deleting moved keys from %s: %v", from.Name, err)
+	}
+
+	return len(rows), rows[len(rows)-1].Key, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Strategy decides which shard owns each key.
+type Strategy interface {
+	// Shard returns the name of the shard that owns key.
+	Shard(key string) string
+	// Shards returns the names of the shards that own keys, sorted.
+	Shards() []string
+	// PrefixShards returns the names of the shards that may own a key
+	// starting with prefix, sorted, so that a scan skips the other shards.
+	PrefixShards(prefix string) []string
+}
+
+// KeyRange is a range of keys owned by a shard, from the end of the previous
+// range, inclusive, to End, exclusive. An empty End means the range is
+// unbounded.
+type KeyRange struct {
+	Shard string `json:"shard"`
+	End   string `json:"end"`
+}
+
+// RangeStrategy assigns contiguous ranges of keys to shards, so that keys
+// sharing a prefix usually live on the same shard and can be scanned
+// together.
+type RangeStrategy struct {
+	ranges []KeyRange
+}
+
+// NewRangeStrategy returns a strategy with the given ranges, which must be
+// sorted by End and cover every key, i.e. only the last range is unbounded.
+func NewRangeStrategy(ranges []KeyRange) (*RangeStrategy, error) {
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no key ranges provided")
+	}
+	for i, r := range ranges {
+		if r.Shard == "" {
+			return nil, fmt.Errorf("key range %d has no shard", i)
+		}
+		last := i == len(ranges)-1
+		if last != (r.End == "") {
+			return nil, fmt.Errorf("only the last key range must be unbounded")
+		}
+		if i > 0 && !last && r.End <= ranges[i-1].End {
+			return nil, fmt.Errorf("key ranges must be sorted: %q is not after %q", r.End, ranges[i-1].End)
+		}
+	}
+
+	return &RangeStrategy{ranges: slices.Clone(ranges)}, nil
+}
+
+func (s *RangeStrategy) Shard(key string) string {
+	i := sort.Search(len(s.ranges)-1, func(i int) bool {
+		return key < s.ranges[i].End
+	})
+	return s.ranges[i].Shard
+}
+
+func (s *RangeStrategy) Shards() []string {
+	var shards []string
+	for _, r := range s.ranges {
+		shards = append(shards, r.Shard)
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards)
+}
+
+// PrefixShards returns the shards of the ranges that overlap the keys
+// starting with prefix, usually a single one.
+func (s *RangeStrategy) PrefixShards(prefix string) []string {
+	end, bounded := prefixEnd(prefix)
+	var shards []string
+	start := ""
+	for i, r := range s.ranges {
+		last := i == len(s.ranges)-1
+		if (last || prefix < r.End) && (!bounded || start < end) {
+			shards = append(shards, r.Shard)
+		}
+		start = r.End
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards)
+}
+
+// prefixEnd returns the smallest key that comes after every key starting with
+// prefix. bounded is false when there's none, e.g. for an empty prefix.
+func prefixEnd(prefix string) (end string, bounded bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// DirectoryStrategy looks up the shard of a key in a table of key prefixes,
+// e.g. one per tenant. The longest matching prefix wins, and keys without a
+// matching prefix go to the default shard.
+type DirectoryStrategy struct {
+	entries map[string]string
+	// prefixLens holds the distinct lengths of the prefixes, longest first.
+	prefixLens   []int
+	defaultShard string
+}
+
+func NewDirectoryStrategy(entries map[string]string, defaultShard string) (*DirectoryStrategy, error) {
+	if defaultShard == "" {
+		return nil, fmt.Errorf("no default shard provided")
+	}
+
+	s := &DirectoryStrategy{
+		entries:      make(map[string]string, len(entries)),
+		defaultShard: defaultShard,
+	}
+	for prefix, shard := range entries {
+		if prefix == "" || shard == "" {
+			return nil, fmt.Errorf("invalid directory entry %q: %q", prefix, shard)
+		}
+		s.entries[prefix] = shard
+		if !slices.Contains(s.prefixLens, len(prefix)) {
+			s.prefixLens = append(s.prefixLens, len(prefix))
+		}
+	}
+	slices.Sort(s.prefixLens)
+	slices.Reverse(s.prefixLens)
+
+	return s, nil
+}
+
+func (s *DirectoryStrategy) Shard(key string) string {
+	for _, n := range s.prefixLens {
+		if n > len(key) {
+			continue
+		}
+		if shard, ok := s.entries[key[:n]]; ok {
+			return shard
+		}
+	}
+	return s.defaultShard
+}
+
+func (s *DirectoryStrategy) Shards() []string {
+	shards := []string{s.defaultShard}
+	for _, shard := range s.entries {
+		shards = append(shards, shard)
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards)
+}
+
+// PrefixShards returns the shards of the entries that a key starting with
+// prefix may match, and the default shard unless every such key matches an
+// entry.
+func (s *DirectoryStrategy) PrefixShards(prefix string) []string {
+	var shards []string
+	matched := false
+	for p, shard := range s.entries {
+		switch {
+		case strings.HasPrefix(prefix, p):
+			matched = true
+			shards = append(shards, shard)
+		case strings.HasPrefix(p, prefix):
+			shards = append(shards, shard)
+		}
+	}
+	if !matched {
+		shards = append(shards, s.defaultShard)
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards)
+}
+
+// ShardingConfig selects the sharding strategy and its shards.
+type ShardingConfig struct {
+	// Strategy is one of "hash", "range" and "directory".
+	Strategy string `json:"strategy"`
+	// Shards lists the shards of the hash strategy.
+	Shards []string `json:"shards"`
+	// Ranges lists the key ranges of the range strategy.
+	Ranges []KeyRange `json:"ranges"`
+	// Directory maps key prefixes to shards for the directory strategy, and
+	// DefaultShard owns the other keys.
+	Directory    map[string]string `json:"directory"`
+	DefaultShard string            `json:"default_shard"`
+	// Replication describes the replicas of every shard.
+	Replication ReplicationConfig `json:"replication"`
+}
+
+// defaultShardingConfig spreads the keys over 3 shards with a hash ring.
+var defaultShardingConfig = ShardingConfig{
+	Strategy: "hash",
+	Shards:   []string{"kvstore_1", "kvstore_2", "kvstore_3"},
+}
+
+// NewStrategy creates the strategy described by cfg, after checking that its
+// shard names can be used as database names.
+func NewStrategy(cfg ShardingConfig) (Strategy, error) {
+	var strategy Strategy
+	switch strings.ToLower(cfg.Strategy) {
+	case "hash":
+		if len(cfg.Shards) == 0 {
+			return nil, fmt.Errorf("no shards provided")
+		}
+		strategy = NewHashRing(cfg.Shards)
+	case "range":
+		var err error
+		strategy, err = NewRangeStrategy(cfg.Ranges)
+		if err != nil {
+			return nil, err
+		}
+	case "directory":
+		var err error
+		strategy, err = NewDirectoryStrategy(cfg.Directory, cfg.DefaultShard)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown sharding strategy %q", cfg.Strategy)
+	}
+
+	for _, shard := range strategy.Shards() {
+		if !shardNamePattern.MatchString(shard) {
+			return nil, fmt.Errorf("invalid shard name %q", shard)
+		}
+	}
+
+	return strategy, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// testKeys returns n keys that look like the ones stored by tenants.
+func testKeys(n int) []string {
+	keys := make([]string, 0, n)
+	for i := range n {
+		keys = append(keys, fmt.Sprintf("tenant-%d/user:%d", i%7, i))
+	}
+	return keys
+}
+
+func TestHashStrategyDistribution(t *testing.T) {
+	shards := []string{"kvstore_1", "kvstore_2", "kvstore_3", "kvstore_4"}
+	ring := NewHashRing(shards)
+
+	keys := testKeys(100000)
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[ring.Shard(key)]++
+	}
+
+	mean := float64(len(keys)) / float64(len(shards))
+	for _, shard := range shards {
+		if deviation := math.Abs(float64(counts[shard])-mean) / mean; deviation > 0.15 {
+			t.Errorf("shard %s owns %d keys, %.0f%% away from the mean", shard, counts[shard], deviation*100)
+		}
+	}
+}
+
+func TestHashStrategyRouting(t *testing.T) {
+	ring := NewHashRing([]string{"kvstore_1", "kvstore_2", "kvstore_3"})
+	// The ring doesn't depend on the order shards are added in.
+	other := NewHashRing([]string{"kvstore_3", "kvstore_1", "kvstore_2"})
+
+	for _, key := range testKeys(1000) {
+		if ring.Shard(key) != other.Shard(key) {
+			t.Fatalf("key %s routed to %s and %s", key, ring.Shard(key), other.Shard(key))
+		}
+	}
+}
+
+func TestHashRingDiff(t *testing.T) {
+	ring := NewHashRing([]string{"kvstore_1", "kvstore_2", "kvstore_3"})
+	next := ring.Clone()
+	next.Add("kvstore_4")
+	moves := ring.Diff(next)
+
+	numMoved := 0
+	keys := testKeys(20000)
+	for _, key := range keys {
+		hash := int64(hashKey(key))
+		var move *Move
+		for i, m := range moves {
+			if hash > m.Start && hash <= m.End {
+				move = &moves[i]
+			}
+		}
+
+		from, to := ring.Shard(key), next.Shard(key)
+		if move == nil {
+			if from != to {
+				t.Fatalf("key %s moves from %s to %s outside of the diff", key, from, to)
+			}
+			continue
+		}
+		if move.From != from || move.To != to || to != "kvstore_4" {
+			t.Fatalf("key %s in move %+v, but routed from %s to %s", key, *move, from, to)
+		}
+		numMoved++
+	}
+
+	// Only the keys taken over by the new shard move, about a quarter.
+	if ratio := float64(numMoved) / float64(len(keys)); ratio < 0.15 || ratio > 0.35 {
+		t.Errorf("%.0f%% of the keys moved", ratio*100)
+	}
+}
+
+func TestRangeStrategyRouting(t *testing.T) {
+	s, err := NewRangeStrategy([]KeyRange{
+		{Shard: "kvstore_1", End: "h"},
+		{Shard: "kvstore_2", End: "tenant-5"},
+		{Shard: "kvstore_3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"":             "kvstore_1",
+		"apple":        "kvstore_1",
+		"gzz":          "kvstore_1",
+		"h":            "kvstore_2",
+		"tenant-4/a":   "kvstore_2",
+		"tenant-5":     "kvstore_3",
+		"tenant-5/a":   "kvstore_3",
+		"zebra":        "kvstore_3",
+		"\xff\xffhigh": "kvstore_3",
+	}
+	for key, want := range tests {
+		if got := s.Shard(key); got != want {
+			t.Errorf("Shard(%q) = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestRangeStrategyDistribution(t *testing.T) {
+	s, err := NewRangeStrategy([]KeyRange{
+		{Shard: "kvstore_1", End: "tenant-3"},
+		{Shard: "kvstore_2", End: "tenant-5"},
+		{Shard: "kvstore_3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every key of a tenant lives on a single shard, so that a prefix scan
+	// only reads one shard.
+	tenantShards := make(map[string]map[string]bool)
+	for _, key := range testKeys(7000) {
+		tenant := key[:len("tenant-0")]
+		if tenantShards[tenant] == nil {
+			tenantShards[tenant] = make(map[string]bool)
+		}
+		tenantShards[tenant][s.Shard(key)] = true
+	}
+	for tenant, shards := range tenantShards {
+		if len(shards) != 1 {
+			t.Errorf("tenant %s is spread over %d shards", tenant, len(shards))
+		}
+	}
+}
+
+func TestNewRangeStrategyValidation(t *testing.T) {
+	tests := map[string][]KeyRange{
+		"empty":              nil,
+		"unsorted":           {{Shard: "a", End: "m"}, {Shard: "b", End: "c"}, {Shard: "c"}},
+		"bounded last range": {{Shard: "a", End: "m"}},
+		"unbounded middle":   {{Shard: "a"}, {Shard: "b"}},
+		"missing shard":      {{End: "m"}, {Shard: "b"}},
+	}
+	for name, ranges := range tests {
+		if _, err := NewRangeStrategy(ranges); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDirectoryStrategyRouting(t *testing.T) {
+	s, err := NewDirectoryStrategy(map[string]string{
+		"tenant-1/":     "kvstore_1",
+		"tenant-1/big/": "kvstore_2",
+		"tenant-2/":     "kvstore_2",
+	}, "kvstore_3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"tenant-1/a":      "kvstore_1",
+		"tenant-1/big/a":  "kvstore_2",
+		"tenant-1/bigger": "kvstore_1",
+		"tenant-2/a":      "kvstore_2",
+		"tenant-3/a":      "kvstore_3",
+		"tenant-1":        "kvstore_3",
+		"":                "kvstore_3",
+	}
+	for key, want := range tests {
+		if got := s.Shard(key); got != want {
+			t.Errorf("Shard(%q) = %s, want %s", key, got, want)
+		}
+	}
+
+	want := []string{"kvstore_1", "kvstore_2", "kvstore_3"}
+	if got := s.Shards(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Shards() = %v, want %v", got, want)
+	}
+}
+
+func TestDirectoryStrategyDistribution(t *testing.T) {
+	entries := map[string]string{
+		"tenant-0/": "kvstore_1",
+		"tenant-1/": "kvstore_1",
+		"tenant-2/": "kvstore_2",
+		"tenant-3/": "kvstore_2",
+	}
+	s, err := NewDirectoryStrategy(entries, "kvstore_3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, key := range testKeys(7000) {
+		counts[s.Shard(key)]++
+	}
+
+	// Each of the 7 tenants owns 1000 keys, and the unlisted tenants go to
+	// the default shard.
+	want := map[string]int{"kvstore_1": 2000, "kvstore_2": 2000, "kvstore_3": 3000}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	s, err := NewStrategy(defaultShardingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*HashRing); !ok {
+		t.Errorf("default strategy is %T, want *HashRing", s)
+	}
+
+	s, err = NewStrategy(ShardingConfig{
+		Strategy: "range",
+		Ranges:   []KeyRange{{Shard: "kvstore_1", End: "m"}, {Shard: "kvstore_2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*RangeStrategy); !ok {
+		t.Errorf("strategy is %T, want *RangeStrategy", s)
+	}
+
+	s, err = NewStrategy(ShardingConfig{Strategy: "directory", DefaultShard: "kvstore_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*DirectoryStrategy); !ok {
+		t.Errorf("strategy is %T, want *DirectoryStrategy", s)
+	}
+
+	for name, cfg := range map[string]ShardingConfig{
+		"unknown strategy":   {Strategy: "random", Shards: []string{"kvstore_1"}},
+		"no shards":          {Strategy: "hash"},
+		"invalid shard name": {Strategy: "hash", Shards: []string{"kv; DROP DATABASE postgres"}},
+	} {
+		if _, err := NewStrategy(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPrefixShards(t *testing.T) {
+	ranges, err := NewRangeStrategy([]KeyRange{
+		{Shard: "kvstore_1", End: "h"},
+		{Shard: "kvstore_2", End: "tenant-5"},
+		{Shard: "kvstore_3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := NewDirectoryStrategy(map[string]string{
+		"tenant-1/":     "kvstore_1",
+		"tenant-1/big/": "kvstore_2",
+		"tenant-2/":     "kvstore_2",
+	}, "kvstore_3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		strategy Strategy
+		prefix   string
+		want     string
+	}{
+		{ranges, "", "[kvstore_1 kvstore_2 kvstore_3]"},
+		{ranges, "apple", "[kvstore_1]"},
+		{ranges, "tenant-4/", "[kvstore_2]"},
+		{ranges, "tenant-5", "[kvstore_3]"},
+		{ranges, "tenant-", "[kvstore_2 kvstore_3]"},
+		{ranges, "g", "[kvstore_1]"},
+		{ranges, "\xff", "[kvstore_3]"},
+		{directory, "tenant-1/", "[kvstore_1 kvstore_2]"},
+		{directory, "tenant-1/a", "[kvstore_1]"},
+		{directory, "tenant-2/", "[kvstore_2]"},
+		{directory, "tenant-3/", "[kvstore_3]"},
+		{directory, "tenant-", "[kvstore_1 kvstore_2 kvstore_3]"},
+		{NewHashRing([]string{"kvstore_1", "kvstore_2"}), "tenant-1/", "[kvstore_1 kvstore_2]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.strategy.PrefixShards(tt.prefix)); got != tt.want {
+			t.Errorf("%T.PrefixShards(%q) = %s, want %s", tt.strategy, tt.prefix, got, tt.want)
+		}
+	}
+}
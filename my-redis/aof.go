@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// fsyncPolicy controls how often the AOF file is flushed to stable storage.
+//
+// https://redis.io/docs/latest/operate/oss_and_stack/management/persistence/#how-durable-is-the-append-only-file
+type fsyncPolicy int
+
+const (
+	// fsyncAlways calls fsync after every write command. It's the safest and
+	// the slowest option.
+	fsyncAlways fsyncPolicy = iota
+	// fsyncEverySec calls fsync once per second from a background goroutine,
+	// so at most one second of writes can be lost.
+	fsyncEverySec
+	// fsyncNo never calls fsync and lets the OS decide when to flush.
+	fsyncNo
+)
+
+func parseFsyncPolicy(s string) (fsyncPolicy, error) {
+	switch s {
+	case "always":
+		return fsyncAlways, nil
+	case "everysec":
+		return fsyncEverySec, nil
+	case "no":
+		return fsyncNo, nil
+	default:
+		return 0, fmt.Errorf("invalid fsync policy %q: expect one of always, everysec, no", s)
+	}
+}
+
+// aof appends every write command to a file as a RESP array, so that the
+// dataset can be rebuilt by replaying the file on startup.
+//
+// All methods except the background fsync are called from the executor
+// goroutine.
+type aof struct {
+	path   string
+	policy fsyncPolicy
+
+	// mu guards f, which is swapped out when a rewrite finishes while the
+	// background fsync goroutine may still be using it.
+	mu sync.Mutex
+	f  *os.File
+
+	// rewriting is true while a background rewrite is in progress. Commands
+	// appended during that time are also collected in rewriteBuf and copied
+	// to the new file before it replaces the current one.
+	rewriting  bool
+	rewriteBuf []byte
+}
+
+func openAOF(path string, policy fsyncPolicy) (*aof, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open aof file: %w", err)
+	}
+
+	a := &aof{
+		path:   path,
+		policy: policy,
+		f:      f,
+	}
+
+	if policy == fsyncEverySec {
+		go a.fsyncEverySecond()
+	}
+
+	return a, nil
+}
+
+// append writes a single command to the end of the AOF file.
+func (a *aof) append(name string, args [][]byte) error {
+	b := resp.SerializeArray(append([][]byte{[]byte(name)}, args...))
+
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, b...)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err := a.f.Write(b)
+	if err != nil {
+		return fmt.Errorf("write to aof file: %w", err)
+	}
+
+	if a.policy == fsyncAlways {
+		err = a.f.Sync()
+		if err != nil {
+			return fmt.Errorf("fsync aof file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (a *aof) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mu.Lock()
+		err := a.f.Sync()
+		a.mu.Unlock()
+		if err != nil {
+			slog.Error("fsync aof file failed", "err", err)
+		}
+	}
+}
+
+// enableAOF rebuilds the store from the AOF file at path, if it exists, and
+// then starts appending new write commands to it.
+func (ex *executor) enableAOF(path string, policy fsyncPolicy) error {
+	err := replayAOF(path, ex)
+	if err != nil {
+		return err
+	}
+
+	a, err := openAOF(path, policy)
+	if err != nil {
+		return err
+	}
+	// The assignment happens before any command that could read it is sent to
+	// the executor goroutine, so it doesn't race with the loop.
+	ex.aof = a
+
+	return nil
+}
+
+// replayAOF runs every command stored in the AOF file through the executor.
+// A command that was cut off midway, e.g. because the server crashed while
+// writing it, is truncated from the file instead of failing the whole load.
+// The same goes for a transaction that is missing its EXEC.
+func replayAOF(path string, ex *executor) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("open aof file: %w", err)
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	r := bufio.NewReader(cr)
+	// Like Redis, commands are replayed by a fake client.
+	c := newClient()
+
+	numCmds := 0
+	// validOffset is the end of the last complete command, and multiOffset is
+	// the start of the transaction that is being replayed, if any.
+	var validOffset, multiOffset int64
+	for {
+		cmd, err := resp.ParseArray(r)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("parse aof file at offset %d: %w", validOffset, err)
+			}
+
+			if c.inMulti {
+				validOffset = multiOffset
+			}
+			if cr.n-int64(r.Buffered()) == validOffset {
+				break
+			}
+
+			slog.Warn("aof file ends with an incomplete command, truncating it",
+				"path", path, "offset", validOffset)
+			err = f.Truncate(validOffset)
+			if err != nil {
+				return fmt.Errorf("truncate aof file: %w", err)
+			}
+			break
+		}
+
+		if !c.inMulti {
+			multiOffset = validOffset
+		}
+
+		res := ex.execute(c, cmd)
+		if len(res) > 0 && res[0] == '-' {
+			return fmt.Errorf("replay command #%d %q: %s", numCmds, cmd[0], res[1:len(res)-2])
+		}
+
+		numCmds++
+		validOffset = cr.n - int64(r.Buffered())
+	}
+
+	// Discard the partial transaction, if any.
+	ex.execute(c, [][]byte{[]byte("DISCARD")})
+
+	slog.Info("loaded data from aof file", "path", path, "commands", numCmds)
+	return nil
+}
+
+// bgRewriteAOF starts compacting the AOF file in the background. The new
+// file contains the minimal set of commands needed to recreate the current
+// dataset, instead of the full history of writes.
+func (ex *executor) bgRewriteAOF() error {
+	if ex.aof == nil {
+		return errors.New("append only file is disabled")
+	}
+	if ex.aof.rewriting {
+		return errors.New("background append only file rewriting already in progress")
+	}
+
+	snapshot := ex.store.snapshot()
+	ex.aof.rewriting = true
+
+	tmpPath := ex.aof.path + ".rewrite"
+	go func() {
+		err := writeRewrittenAOF(tmpPath, snapshot)
+		ex.tasks <- func() {
+			ex.aof.finishRewrite(tmpPath, err)
+		}
+	}()
+
+	return nil
+}
+
+// finishRewrite copies the commands appended during the rewrite to the new
+// file and atomically replaces the current AOF file with it.
+func (a *aof) finishRewrite(tmpPath string, rewriteErr error) {
+	defer func() {
+		a.rewriting = false
+		a.rewriteBuf = nil
+	}()
+
+	if rewriteErr != nil {
+		slog.Error("background aof rewrite failed", "err", rewriteErr)
+		os.Remove(tmpPath)
+		return
+	}
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		slog.Error("open rewritten aof file failed", "err", err)
+		os.Remove(tmpPath)
+		return
+	}
+
+	_, err = f.Write(a.rewriteBuf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, a.path)
+	}
+	if err != nil {
+		slog.Error("finish aof rewrite failed", "err", err)
+		f.Close()
+		os.Remove(tmpPath)
+		return
+	}
+
+	a.mu.Lock()
+	oldFile := a.f
+	a.f = f
+	a.mu.Unlock()
+
+	oldFile.Close()
+	slog.Info("background aof rewrite finished", "path", a.path)
+}
+
+// writeRewrittenAOF writes the commands that recreate the given dataset to a
+// new file at path.
+func writeRewrittenAOF(path string, mp map[string]entry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create aof file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	now := time.Now()
+	for key, e := range mp {
+		if e.isExpired(now) {
+			continue
+		}
+
+		var cmds [][][]byte
+		switch val := e.val.(type) {
+		case []byte:
+			cmds = append(cmds, [][]byte{[]byte("SET"), []byte(key), val})
+		case [][]byte:
+			cmds = append(cmds, append([][]byte{[]byte("RPUSH"), []byte(key)}, val...))
+		case set:
+			cmds = append(cmds, append([][]byte{[]byte("SADD"), []byte(key)}, val.members()...))
+		case *sortedSet:
+			cmd := [][]byte{[]byte("ZADD"), []byte(key)}
+			for x := val.zsl.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+				cmd = append(cmd, formatScore(x.score), []byte(x.member))
+			}
+			cmds = append(cmds, cmd)
+		case hash:
+			cmd := [][]byte{[]byte("HSET"), []byte(key)}
+			for field, fieldVal := range val {
+				cmd = append(cmd, []byte(field), fieldVal)
+			}
+			cmds = append(cmds, cmd)
+		default:
+			return fmt.Errorf("rewrite key %s: unsupported data type %T", key, val)
+		}
+
+		if !e.expiredAt.IsZero() {
+			cmds = append(cmds, [][]byte{[]byte("PEXPIREAT"), []byte(key),
+				strconv.AppendInt(nil, e.expiredAt.UnixMilli(), 10)})
+		}
+
+		for _, cmd := range cmds {
+			_, err = w.Write(resp.SerializeArray(cmd))
+			if err != nil {
+				return fmt.Errorf("write aof file: %w", err)
+			}
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return fmt.Errorf("flush aof file: %w", err)
+	}
+
+	return f.Sync()
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
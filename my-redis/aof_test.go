@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+func newAOFExecutor(t *testing.T, path string) *executor {
+	t.Helper()
+
+	ex := newExecutor(newStore(), config{})
+	err := ex.enableAOF(path, fsyncAlways)
+	require.NoError(t, err)
+
+	return ex
+}
+
+func execute(ex *executor, args ...string) []byte {
+	return executeAs(ex, newClient(), args...)
+}
+
+// executeAs runs a command on behalf of c, for tests that need state to carry
+// over between commands.
+func executeAs(ex *executor, c *client, args ...string) []byte {
+	rawCmd := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		rawCmd = append(rawCmd, []byte(arg))
+	}
+	return ex.execute(c, rawCmd)
+}
+
+func TestAOFReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	ex := newAOFExecutor(t, path)
+	require.Equal(t, "+OK\r\n", string(execute(ex, "SET", "foo", "bar")))
+	require.Equal(t, ":2\r\n", string(execute(ex, "RPUSH", "list", "a", "b")))
+	require.Equal(t, "+OK\r\n", string(execute(ex, "SET", "expired", "val", "PX", "1")))
+	// Read-only commands and failed writes must not end up in the file.
+	execute(ex, "GET", "foo")
+	execute(ex, "RPUSH", "foo", "x")
+
+	time.Sleep(5 * time.Millisecond)
+
+	restarted := newAOFExecutor(t, path)
+	require.Equal(t, "$3\r\nbar\r\n", string(execute(restarted, "GET", "foo")))
+	require.Equal(t, string(resp.SerializeArray([][]byte{[]byte("a"), []byte("b")})),
+		string(execute(restarted, "LRANGE", "list", "0", "-1")))
+	require.Equal(t, string(resp.NullBulkString), string(execute(restarted, "GET", "expired")))
+}
+
+func TestAOFReplayTruncatesIncompleteCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	complete := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	err := os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$3\r\nba"), 0o644)
+	require.NoError(t, err)
+
+	ex := newAOFExecutor(t, path)
+	require.Equal(t, "$3\r\nbar\r\n", string(execute(ex, "GET", "foo")))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, complete, string(content))
+}
+
+func TestAOFReplayDiscardsIncompleteTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	ex := newAOFExecutor(t, path)
+	c := newClient()
+	ex.execute(c, [][]byte{[]byte("MULTI")})
+	ex.execute(c, [][]byte{[]byte("SET"), []byte("foo"), []byte("bar")})
+	ex.execute(c, [][]byte{[]byte("EXEC")})
+
+	complete, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n*1\r\n$4\r\nEXEC\r\n",
+		string(complete))
+
+	// Simulate a crash in the middle of writing another transaction.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbaz\r\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restarted := newAOFExecutor(t, path)
+	require.Equal(t, "$3\r\nbar\r\n", string(execute(restarted, "GET", "foo")))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(complete), string(content))
+}
+
+func TestBGRewriteAOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	ex := newAOFExecutor(t, path)
+	for range 100 {
+		execute(ex, "SET", "foo", "bar")
+	}
+	execute(ex, "RPUSH", "list", "a")
+	execute(ex, "RPUSH", "list", "b")
+	execute(ex, "HSET", "hash", "f", "v")
+	execute(ex, "SADD", "set", "m")
+	execute(ex, "ZADD", "zset", "1.5", "m")
+	execute(ex, "EXPIRE", "hash", "100")
+
+	sizeBefore := fileSize(t, path)
+
+	require.Equal(t, "+Background append only file rewriting started\r\n",
+		string(execute(ex, "BGREWRITEAOF")))
+	require.Eventually(t, func() bool {
+		done := make(chan bool)
+		ex.tasks <- func() { done <- !ex.aof.rewriting }
+		return <-done
+	}, time.Second, 10*time.Millisecond)
+
+	require.Less(t, fileSize(t, path), sizeBefore)
+
+	// Writes after the rewrite are appended to the new file.
+	execute(ex, "RPUSH", "list", "c")
+
+	restarted := newAOFExecutor(t, path)
+	require.Equal(t, "$3\r\nbar\r\n", string(execute(restarted, "GET", "foo")))
+	require.Equal(t, string(resp.SerializeArray([][]byte{[]byte("a"), []byte("b"), []byte("c")})),
+		string(execute(restarted, "LRANGE", "list", "0", "-1")))
+	require.Equal(t, "$1\r\nv\r\n", string(execute(restarted, "HGET", "hash", "f")))
+	require.Equal(t, ":100\r\n", string(execute(restarted, "TTL", "hash")))
+	require.Equal(t, ":1\r\n", string(execute(restarted, "SISMEMBER", "set", "m")))
+	require.Equal(t, string(resp.SerializeArray([][]byte{[]byte("m"), []byte("1.5")})),
+		string(execute(restarted, "ZRANGE", "zset", "0", "-1", "WITHSCORES")))
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	return info.Size()
+}
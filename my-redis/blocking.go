@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// blockedClient is a client waiting for one of keys to receive an element,
+// so that its blocking command can complete.
+type blockedClient struct {
+	cmd  command
+	keys []string
+	// timer fires when the timeout expires. It's nil if the client blocks
+	// forever.
+	timer *time.Timer
+}
+
+// parseBlockingTimeout parses the timeout of a blocking command, given in
+// seconds with an optional fractional part. Zero means blocking forever.
+func parseBlockingTimeout(b []byte) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// block parks the client that sent cmd until one of keys receives an element
+// or the timeout expires, in which case the client gets null, the null reply
+// of the command. It returns the reply to send right away, which is nil
+// unless the command can't block, e.g. inside a transaction.
+func (ex *executor) block(cmd command, keys []string, timeout time.Duration, null []byte) []byte {
+	if ex.inExec {
+		return null
+	}
+
+	bc := &blockedClient{
+		cmd:  cmd,
+		keys: keys,
+	}
+	for _, key := range keys {
+		// A key can be given more than once, but the client should only be
+		// queued once.
+		if !slices.Contains(ex.blockedKeys[key], bc) {
+			ex.blockedKeys[key] = append(ex.blockedKeys[key], bc)
+		}
+	}
+
+	if timeout > 0 {
+		bc.timer = time.AfterFunc(timeout, func() {
+			ex.tasks <- func() {
+				// The client may have been served while this task was
+				// waiting to run.
+				if cmd.client.blocked != bc {
+					return
+				}
+
+				ex.unblock(cmd.client)
+				ex.reply(cmd, null)
+			}
+		})
+	}
+
+	cmd.client.blocked = bc
+	return nil
+}
+
+// unblock removes c from the queues of the keys it's blocked on, without
+// replying to it.
+func (ex *executor) unblock(c *client) {
+	bc := c.blocked
+	if bc == nil {
+		return
+	}
+
+	for _, key := range bc.keys {
+		ex.blockedKeys[key] = slices.DeleteFunc(ex.blockedKeys[key], func(other *blockedClient) bool {
+			return other == bc
+		})
+		if len(ex.blockedKeys[key]) == 0 {
+			delete(ex.blockedKeys, key)
+		}
+	}
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+
+	c.blocked = nil
+}
+
+// signalKeyAsReady records that key received elements, so that the clients
+// blocked on it are served once the current command completes.
+func (ex *executor) signalKeyAsReady(key string) {
+	if len(ex.blockedKeys[key]) == 0 {
+		return
+	}
+	if slices.Contains(ex.readyKeys, key) {
+		return
+	}
+
+	ex.readyKeys = append(ex.readyKeys, key)
+}
+
+// serveBlockedClients completes the blocking commands waiting on the keys
+// that received elements. Clients blocked on the same key are served in the
+// order they blocked, one element each, so that a single push wakes up a
+// single client.
+func (ex *executor) serveBlockedClients() {
+	// Serving a client can make another key ready, e.g. the destination of
+	// BLMOVE, so keep going until no key is left.
+	for len(ex.readyKeys) > 0 {
+		readyKeys := ex.readyKeys
+		ex.readyKeys = nil
+
+		for _, key := range readyKeys {
+			for len(ex.blockedKeys[key]) > 0 {
+				length, err := ex.store.llen(key)
+				if err != nil || length == 0 {
+					break
+				}
+
+				bc := ex.blockedKeys[key][0]
+				ex.unblock(bc.cmd.client)
+
+				// The key holds an element, so the command doesn't block again.
+				reply := ex.dispatch(bc.cmd)
+				if reply != nil {
+					ex.reply(bc.cmd, reply)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockingCommandInsideTransaction(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	c := newClient()
+
+	require.Equal(t, "+OK\r\n", string(executeAs(ex, c, "MULTI")))
+	require.Equal(t, "+QUEUED\r\n", string(executeAs(ex, c, "BLPOP", "list_key", "0")))
+	require.Equal(t, "+QUEUED\r\n", string(executeAs(ex, c, "BLMOVE", "src", "dst", "LEFT", "LEFT", "0")))
+
+	// A transaction can't block, so the commands reply as if they timed out.
+	require.Equal(t, "*2\r\n*-1\r\n$-1\r\n", string(executeAs(ex, c, "EXEC")))
+}
+
+func TestBlockedClientsAreServedInOrder(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+
+	first := ex.submit(newClient(), [][]byte{[]byte("BLPOP"), []byte("list_key"), []byte("0")})
+	second := ex.submit(newClient(), [][]byte{[]byte("BLPOP"), []byte("list_key"), []byte("0")})
+
+	// Both elements are pushed at once, so each client gets one of them.
+	require.Equal(t, ":2\r\n", string(execute(ex, "RPUSH", "list_key", "a", "b")))
+	require.Equal(t, "*2\r\n$8\r\nlist_key\r\n$1\r\na\r\n", string(<-first))
+	require.Equal(t, "*2\r\n$8\r\nlist_key\r\n$1\r\nb\r\n", string(<-second))
+	require.Equal(t, ":0\r\n", string(execute(ex, "LLEN", "list_key")))
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+
+	start := time.Now()
+	require.Equal(t, "$-1\r\n", string(execute(ex, "BLMOVE", "src", "dst", "LEFT", "LEFT", "0.05")))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, "*-1\r\n", string(execute(ex, "BRPOP", "list_key", "0.01")))
+
+	require.Equal(t, "-timeout is negative\r\n", string(execute(ex, "BLPOP", "list_key", "-1")))
+	require.Equal(t, "-timeout is not a float or out of range\r\n", string(execute(ex, "BLPOP", "list_key", "abc")))
+}
+
+func TestFreedClientDoesNotBlock(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	c := newClient()
+
+	// The client disconnects while its BLPOP is still queued, so it must not
+	// be blocked once the command runs.
+	ex.submit(c, [][]byte{[]byte("BLPOP"), []byte("list_key"), []byte("0")})
+	ex.freeClient(c)
+
+	require.Equal(t, ":1\r\n", string(execute(ex, "RPUSH", "list_key", "a")))
+	require.Equal(t, ":1\r\n", string(execute(ex, "LLEN", "list_key")))
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// clientOutputBufferLimit is the number of replies and pushed messages that
+// can wait to be written to a connection before the client is disconnected.
+const clientOutputBufferLimit = 1024
+
+// errMaxClients is returned to connections accepted while the server already
+// serves maxclients clients.
+var errMaxClients = errors.New("max number of clients reached")
+
+// client holds the state of a single connection that outlives a command.
+// Except for conn and out, which never change after the client is created,
+// its fields are only accessed from the executor goroutine.
+type client struct {
+	// id uniquely identifies the client for the lifetime of the server.
+	id int64
+	// conn is nil for internal clients, such as the one replaying the AOF.
+	conn net.Conn
+	// out holds the output waiting to be written to conn, in the order it was
+	// produced. Since pushed messages go through it as well, they never
+	// interleave with a reply.
+	out chan []byte
+
+	// protocol is the RESP version negotiated with HELLO, either 2 or 3.
+	protocol int
+	// name is set with CLIENT SETNAME or HELLO SETNAME.
+	name string
+
+	// createdAt is when the client connected, and lastInteraction is when it
+	// last sent a command or received a reply. An idle client is closed once
+	// the idle timeout expires.
+	createdAt       time.Time
+	lastInteraction time.Time
+	// lastCmd is the name of the last command sent by the client.
+	lastCmd string
+
+	// inMulti is true between MULTI and the matching EXEC or DISCARD.
+	inMulti bool
+	// queued holds the commands sent after MULTI, in order.
+	queued []command
+	// multiAborted is set when a command can't be queued, e.g. because it
+	// doesn't exist, which makes the next EXEC fail.
+	multiAborted bool
+	// watchedKeys holds the keys passed to WATCH.
+	watchedKeys map[string]struct{}
+	// dirty is set when one of the watched keys is modified, which makes the
+	// next EXEC abort.
+	dirty bool
+
+	// channels and patterns hold the client's pub/sub subscriptions.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// blocked is set while the client waits on a blocking command such as
+	// BLPOP.
+	blocked *blockedClient
+	// closed is set once the client is freed, after it disconnected.
+	closed bool
+
+	// master is true for the client that applies the replication stream of
+	// the primary on a replica.
+	master bool
+	// listeningPort is the port announced by a replica with REPLCONF.
+	listeningPort string
+}
+
+// nextClientID is the ID of the next client to be created.
+var nextClientID atomic.Int64
+
+func newClient() *client {
+	now := time.Now()
+	return &client{
+		id:              nextClientID.Add(1),
+		protocol:        2,
+		createdAt:       now,
+		lastInteraction: now,
+		watchedKeys:     make(map[string]struct{}),
+		channels:        make(map[string]struct{}),
+		patterns:        make(map[string]struct{}),
+	}
+}
+
+// newConnClient creates a client whose output is written to conn.
+func newConnClient(conn net.Conn) *client {
+	c := newClient()
+	c.conn = conn
+	c.out = make(chan []byte, clientOutputBufferLimit)
+	return c
+}
+
+// send queues b to be written to the client's connection. A client that
+// doesn't read fast enough is disconnected rather than blocking the executor.
+func (c *client) send(b []byte) {
+	if c.out == nil || len(b) == 0 {
+		return
+	}
+
+	select {
+	case c.out <- b:
+	default:
+		slog.Warn("client output buffer is full, closing connection",
+			"addr", c.conn.RemoteAddr())
+		c.close()
+	}
+}
+
+// close closes the client's connection, which makes handleConn free the
+// client.
+func (c *client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// subscribed reports whether the client is in subscriber mode.
+func (c *client) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// addClient registers a client that just connected. It fails if the server
+// already serves maxclients clients.
+func (ex *executor) addClient(c *client) error {
+	errc := make(chan error, 1)
+	ex.tasks <- func() {
+		if ex.cfg.maxClients > 0 && len(ex.clients) >= ex.cfg.maxClients {
+			ex.numRejectedConns++
+			errc <- errMaxClients
+			return
+		}
+
+		ex.clients[c] = struct{}{}
+		ex.numConns++
+		errc <- nil
+	}
+
+	return <-errc
+}
+
+// freeClient releases the executor state of a client that disconnected. It's
+// queued behind the commands the client already submitted, since a blocking
+// command that ran after the client was freed would stay blocked on its keys
+// and pop an element for nobody.
+func (ex *executor) freeClient(c *client) {
+	ex.queue <- command{client: c, free: true}
+}
+
+func (ex *executor) free(c *client) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	ex.store.unwatchAll(c)
+	ex.unsubscribeAll(c)
+	// The blocked command gets an empty reply, so that handleConn, which
+	// waits for the reply in flight, can return.
+	if bc := c.blocked; bc != nil {
+		ex.unblock(c)
+		ex.reply(bc.cmd, []byte{})
+	}
+	delete(ex.replicas, c)
+	delete(ex.clients, c)
+}
+
+// closeIdleClients closes the connections that didn't interact with the
+// server for longer than the idle timeout. Like Redis, clients that wait on
+// purpose, i.e. blocked clients, subscribers and replicas, are never idle.
+func (ex *executor) closeIdleClients(now time.Time) {
+	if ex.cfg.timeout <= 0 {
+		return
+	}
+
+	for c := range ex.clients {
+		if _, isReplica := ex.replicas[c]; isReplica || c.blocked != nil || c.subscribed() {
+			continue
+		}
+		if now.Sub(c.lastInteraction) > ex.cfg.timeout {
+			slog.Info("closing idle client", "addr", c.conn.RemoteAddr())
+			c.close()
+		}
+	}
+}
+
+// clientCmd handles CLIENT ID, CLIENT GETNAME, CLIENT SETNAME, CLIENT LIST and
+// CLIENT KILL.
+//
+// https://redis.io/docs/latest/commands/client-list/
+func (ex *executor) clientCmd(cmd command) []byte {
+	if len(cmd.args) == 0 {
+		return wrongNumArgs("at least 1", len(cmd.args))
+	}
+
+	subcommand := strings.ToUpper(string(cmd.args[0]))
+	args := cmd.args[1:]
+	switch subcommand {
+	case "ID":
+		return resp.SerializeInteger(int(cmd.client.id))
+	case "GETNAME":
+		if cmd.client.name == "" {
+			return resp.NullBulkString
+		}
+		return resp.SerializeBulkString([]byte(cmd.client.name))
+	case "SETNAME":
+		if len(args) != 1 {
+			return wrongNumArgs("1", len(args))
+		}
+		if !validClientName(args[0]) {
+			return resp.SerializeSimpleError(errInvalidClientName.Error())
+		}
+		cmd.client.name = string(args[0])
+		return resp.SerializeSimpleString("OK")
+	case "LIST":
+		return ex.clientListCmd(cmd.client, args)
+	case "KILL":
+		return ex.clientKillCmd(cmd.client, args)
+	default:
+		return resp.SerializeSimpleError(fmt.Sprintf(
+			"unknown subcommand '%s'. Try CLIENT HELP.", cmd.args[0]))
+	}
+}
+
+var errInvalidClientName = errors.New("Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name only contains printable characters
+// other than spaces, so that it doesn't break the output of CLIENT LIST.
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b <= ' ' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientListCmd handles CLIENT LIST [ID client-id [client-id ...]]. Each
+// client is described by a line of space-separated key=value fields.
+func (ex *executor) clientListCmd(c *client, args [][]byte) []byte {
+	var ids []int64
+	if len(args) > 0 {
+		if len(args) < 2 || !strings.EqualFold(string(args[0]), "ID") {
+			return resp.SerializeSimpleError("syntax error")
+		}
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(string(arg), 10, 64)
+			if err != nil || id <= 0 {
+				return resp.SerializeSimpleError("Invalid client ID")
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	clients := slices.SortedFunc(maps.Keys(ex.clients), func(a, b *client) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	now := time.Now()
+	var sb strings.Builder
+	for _, other := range clients {
+		if ids != nil && !slices.Contains(ids, other.id) {
+			continue
+		}
+		ex.writeClientInfo(&sb, other, now)
+	}
+
+	return verbatimReply(c, sb.String())
+}
+
+// writeClientInfo writes the CLIENT LIST line describing c.
+func (ex *executor) writeClientInfo(sb *strings.Builder, c *client, now time.Time) {
+	var flags string
+	if _, ok := ex.replicas[c]; ok {
+		flags += "S"
+	}
+	if c.master {
+		flags += "M"
+	}
+	if c.subscribed() {
+		flags += "P"
+	}
+	if c.inMulti {
+		flags += "x"
+	}
+	if c.blocked != nil {
+		flags += "b"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	multi := -1
+	if c.inMulti {
+		multi = len(c.queued)
+	}
+
+	lastCmd := "NULL"
+	if c.lastCmd != "" {
+		lastCmd = strings.ToLower(c.lastCmd)
+	}
+
+	fmt.Fprintf(sb, "id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d multi=%d resp=%d cmd=%s\n",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), c.name,
+		int(now.Sub(c.createdAt).Seconds()), int(now.Sub(c.lastInteraction).Seconds()),
+		flags, len(c.channels), len(c.patterns), multi, c.protocol, lastCmd)
+}
+
+// clientKillCmd handles CLIENT KILL addr:port, which replies OK if the client
+// was found, and CLIENT KILL <ID client-id | ADDR addr:port | LADDR addr:port
+// | SKIPME yes/no> ..., which replies with the number of killed clients. The
+// filters of the latter form must all match, and by default the calling
+// client isn't killed.
+func (ex *executor) clientKillCmd(c *client, args [][]byte) []byte {
+	if len(args) == 1 {
+		for other := range ex.clients {
+			if other.conn.RemoteAddr().String() == string(args[0]) {
+				other.close()
+				return resp.SerializeSimpleString("OK")
+			}
+		}
+		return resp.SerializeSimpleError("No such client")
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return resp.SerializeSimpleError("syntax error")
+	}
+
+	var filters []func(*client) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		val := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || id <= 0 {
+				return resp.SerializeSimpleError("client-id should be greater than 0")
+			}
+			filters = append(filters, func(other *client) bool { return other.id == id })
+		case "ADDR":
+			filters = append(filters, func(other *client) bool { return other.conn.RemoteAddr().String() == val })
+		case "LADDR":
+			filters = append(filters, func(other *client) bool { return other.conn.LocalAddr().String() == val })
+		case "SKIPME":
+			switch strings.ToLower(val) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return resp.SerializeSimpleError("syntax error")
+			}
+		default:
+			return resp.SerializeSimpleError("syntax error")
+		}
+	}
+
+	numKilled := 0
+	for other := range ex.clients {
+		if skipMe && other == c {
+			continue
+		}
+		if !slices.ContainsFunc(filters, func(match func(*client) bool) bool { return !match(other) }) {
+			other.close()
+			numKilled++
+		}
+	}
+
+	return resp.SerializeInteger(numKilled)
+}
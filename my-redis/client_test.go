@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testConn is a raw connection to a test server that sends inline commands.
+type testConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func startTestServer(t *testing.T, cfg config) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go serve(l, newExecutor(newStore(), cfg))
+	return l.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &testConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends an inline command and returns its reply. A bulk string reply is
+// returned without its length prefix.
+func (c *testConn) do(t *testing.T, line string) string {
+	t.Helper()
+
+	_, err := c.Write([]byte(line + "\r\n"))
+	require.NoError(t, err)
+	return c.readReply(t)
+}
+
+func (c *testConn) readReply(t *testing.T) string {
+	t.Helper()
+
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)))
+	line, err := c.r.ReadString('\n')
+	require.NoError(t, err)
+	if line[0] != '$' || line == "$-1\r\n" {
+		return line
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	require.NoError(t, err)
+	buf := make([]byte, length+2)
+	_, err = io.ReadFull(c.r, buf)
+	require.NoError(t, err)
+	return string(buf[:length])
+}
+
+// requireClosed checks that the server closes the connection.
+func (c *testConn) requireClosed(t *testing.T) {
+	t.Helper()
+
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err := c.r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMaxClients(t *testing.T) {
+	addr := startTestServer(t, config{maxClients: 1})
+
+	first := dialTestServer(t, addr)
+	require.Equal(t, "+PONG\r\n", first.do(t, "PING"))
+
+	second := dialTestServer(t, addr)
+	require.Equal(t, "-max number of clients reached\r\n", second.readReply(t))
+	second.requireClosed(t)
+
+	// The slot is released once the first client disconnects.
+	first.Close()
+	require.Eventually(t, func() bool {
+		conn := dialTestServer(t, addr)
+		return conn.do(t, "PING") == "+PONG\r\n"
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	addr := startTestServer(t, config{timeout: 200 * time.Millisecond})
+
+	idle := dialTestServer(t, addr)
+	require.Equal(t, "+PONG\r\n", idle.do(t, "PING"))
+
+	blocked := dialTestServer(t, addr)
+	_, err := blocked.Write([]byte("BLPOP list 0\r\n"))
+	require.NoError(t, err)
+
+	subscriber := dialTestServer(t, addr)
+	require.Equal(t, "*3\r\n", subscriber.do(t, "SUBSCRIBE news"))
+
+	idle.requireClosed(t)
+
+	// Clients waiting on purpose are kept open.
+	pusher := dialTestServer(t, addr)
+	require.Equal(t, ":1\r\n", pusher.do(t, "RPUSH list a"))
+	require.Equal(t, "*2\r\n", blocked.readReply(t))
+	require.Equal(t, ":1\r\n", pusher.do(t, "PUBLISH news hello"))
+}
+
+func TestHalfClosedClientGetsReplies(t *testing.T) {
+	addr := startTestServer(t, config{})
+
+	conn := dialTestServer(t, addr)
+	_, err := conn.Write([]byte("PING\r\nSET a 1\r\nGET a\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Conn.(*net.TCPConn).CloseWrite())
+
+	require.Equal(t, "+PONG\r\n", conn.readReply(t))
+	require.Equal(t, "+OK\r\n", conn.readReply(t))
+	require.Equal(t, "1", conn.readReply(t))
+	conn.requireClosed(t)
+
+	// A blocked command doesn't keep the connection open, and doesn't pop
+	// the elements pushed afterwards.
+	blocked := dialTestServer(t, addr)
+	_, err = blocked.Write([]byte("BLPOP list 0\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, blocked.Conn.(*net.TCPConn).CloseWrite())
+	blocked.requireClosed(t)
+
+	pusher := dialTestServer(t, addr)
+	require.Equal(t, ":1\r\n", pusher.do(t, "RPUSH list a"))
+	require.Equal(t, ":1\r\n", pusher.do(t, "LLEN list"))
+}
+
+func TestClientCommands(t *testing.T) {
+	addr := startTestServer(t, config{})
+
+	worker := dialTestServer(t, addr)
+	require.Equal(t, "$-1\r\n", worker.do(t, "CLIENT GETNAME"))
+	require.Equal(t, "-Client names cannot contain spaces, newlines or special characters.\r\n",
+		worker.do(t, `CLIENT SETNAME "bad name"`))
+	require.Equal(t, "+OK\r\n", worker.do(t, "CLIENT SETNAME worker"))
+	require.Equal(t, "worker", worker.do(t, "CLIENT GETNAME"))
+	workerID := strings.TrimSpace(worker.do(t, "CLIENT ID")[1:])
+
+	admin := dialTestServer(t, addr)
+	list := admin.do(t, "CLIENT LIST")
+	require.Len(t, strings.Split(strings.TrimSuffix(list, "\n"), "\n"), 2)
+	require.Regexp(t, `(?m)^id=`+workerID+` addr=\S+ laddr=\S+ name=worker age=\d+ idle=\d+ flags=N .*cmd=client$`, list)
+	require.Contains(t, admin.do(t, "CLIENT LIST ID "+workerID), "name=worker")
+
+	// The new form of CLIENT KILL skips the caller by default.
+	require.Equal(t, ":0\r\n", admin.do(t, "CLIENT KILL ADDR "+admin.LocalAddr().String()))
+	require.Equal(t, "-No such client\r\n", admin.do(t, "CLIENT KILL 127.0.0.1:1"))
+	require.Equal(t, ":1\r\n", admin.do(t, "CLIENT KILL ID "+workerID))
+	worker.requireClosed(t)
+}
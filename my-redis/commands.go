@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+// commandSpec describes the commands supported by the server.
+//
+// https://redis.io/docs/latest/commands/command/
+type commandSpec struct {
+	// arity is the number of arguments, including the command name, that the
+	// command accepts. A negative arity -N means N or more arguments.
+	arity int
+	// write is true if the command may modify the dataset. Write commands
+	// are rejected by replicas.
+	write bool
+}
+
+// commandTable holds the spec of every supported command. It's used to reject
+// invalid commands before they are queued in a transaction.
+var commandTable = map[string]commandSpec{
+	"PING":          {arity: -1},
+	"HELLO":         {arity: -1},
+	"ECHO":          {arity: 2},
+	"GET":           {arity: 2},
+	"SET":           {arity: -3, write: true},
+	"RPUSH":         {arity: -3, write: true},
+	"LPUSH":         {arity: -3, write: true},
+	"LPOP":          {arity: -2, write: true},
+	"RPOP":          {arity: -2, write: true},
+	"LLEN":          {arity: 2},
+	"LINDEX":        {arity: 3},
+	"LMOVE":         {arity: 5, write: true},
+	"BLPOP":         {arity: -3, write: true},
+	"BRPOP":         {arity: -3, write: true},
+	"BLMOVE":        {arity: 6, write: true},
+	"LRANGE":        {arity: 4},
+	"EXPIRE":        {arity: -3, write: true},
+	"PEXPIRE":       {arity: -3, write: true},
+	"EXPIREAT":      {arity: -3, write: true},
+	"PEXPIREAT":     {arity: -3, write: true},
+	"TTL":           {arity: 2},
+	"PTTL":          {arity: 2},
+	"PERSIST":       {arity: 2, write: true},
+	"HSET":          {arity: -4, write: true},
+	"HGET":          {arity: 3},
+	"HGETALL":       {arity: 2},
+	"HDEL":          {arity: -3, write: true},
+	"SADD":          {arity: -3, write: true},
+	"SMEMBERS":      {arity: 2},
+	"SISMEMBER":     {arity: 3},
+	"SINTER":        {arity: -2},
+	"ZADD":          {arity: -4, write: true},
+	"ZRANGE":        {arity: -4},
+	"ZRANGEBYSCORE": {arity: -4},
+	"ZRANK":         {arity: 3},
+	"BGREWRITEAOF":  {arity: 1},
+	"SAVE":          {arity: 1},
+	"BGSAVE":        {arity: 1},
+	"LASTSAVE":      {arity: 1},
+	"MULTI":         {arity: 1},
+	"EXEC":          {arity: 1},
+	"DISCARD":       {arity: 1},
+	"WATCH":         {arity: -2},
+	"UNWATCH":       {arity: 1},
+	"SUBSCRIBE":     {arity: -2},
+	"UNSUBSCRIBE":   {arity: -1},
+	"PSUBSCRIBE":    {arity: -2},
+	"PUNSUBSCRIBE":  {arity: -1},
+	"PUBLISH":       {arity: 3},
+	"REPLICAOF":     {arity: 3},
+	"SLAVEOF":       {arity: 3},
+	"PSYNC":         {arity: 3},
+	"REPLCONF":      {arity: -1},
+	"ROLE":          {arity: 1},
+	"CLIENT":        {arity: -2},
+	"INFO":          {arity: -1},
+}
+
+// checkArity returns an error if the command doesn't exist or has the wrong
+// number of arguments.
+func checkArity(cmd command) error {
+	spec, ok := commandTable[cmd.name]
+	if !ok {
+		return fmt.Errorf("unsupported command: %s", cmd.name)
+	}
+
+	numArgs := len(cmd.args) + 1
+	if (spec.arity > 0 && numArgs != spec.arity) || (spec.arity < 0 && numArgs < -spec.arity) {
+		return fmt.Errorf("wrong number of arguments for '%s' command", cmd.name)
+	}
+
+	return nil
+}
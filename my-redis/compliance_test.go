@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"io"
 	"net"
 	"os/exec"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -27,7 +32,7 @@ func (s *ComplianceTestSuite) SetupTest() {
 
 	s.testServerListener = listener
 
-	go run(listener)
+	go run(listener, config{})
 }
 
 func (s *ComplianceTestSuite) TearDownTest() {
@@ -136,61 +141,61 @@ func (s *ComplianceTestSuite) TestRPushMultipleElements() {
 	s.Equal("5\n", string(out))
 }
 
-// func (s *ComplianceTestSuite) TestLPushCreatesNewList() {
-// 	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("1\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPushPrependsToExistingList() {
-// 	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("1\n", string(out))
-
-// 	// A second LPUSH prepends to the existing list and returns the new length.
-// 	cmd = exec.Command("redis-cli", "LPUSH", "list_key", "b")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("2\n", string(out))
-
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("b\nc\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPushMultipleElementsAreReversed() {
-// 	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "a", "b", "c")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("3\n", string(out))
-
-// 	// Elements pushed left-to-right end up in reverse order in the list.
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("c\nb\na\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPushThenLPushPreservesOrder() {
-// 	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("1\n", string(out))
-
-// 	cmd = exec.Command("redis-cli", "LPUSH", "list_key", "b", "a")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("3\n", string(out))
-
-// 	// First LPUSH "c" → [c]; then LPUSH "b" "a" prepends b then a → [a, b, c].
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("a\nb\nc\n", string(out))
-// }
+func (s *ComplianceTestSuite) TestLPushCreatesNewList() {
+	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPushPrependsToExistingList() {
+	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// A second LPUSH prepends to the existing list and returns the new length.
+	cmd = exec.Command("redis-cli", "LPUSH", "list_key", "b")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("2\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("b\nc\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPushMultipleElementsAreReversed() {
+	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "a", "b", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	// Elements pushed left-to-right end up in reverse order in the list.
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("c\nb\na\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPushThenLPushPreservesOrder() {
+	cmd := exec.Command("redis-cli", "LPUSH", "list_key", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LPUSH", "list_key", "b", "a")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	// First LPUSH "c" → [c]; then LPUSH "b" "a" prepends b then a → [a, b, c].
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\nb\nc\n", string(out))
+}
 
 func (s *ComplianceTestSuite) TestLRange() {
 	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c", "d", "e")
@@ -303,250 +308,1111 @@ func (s *ComplianceTestSuite) TestLRangeNegativeStartOutOfRange() {
 	s.Equal("a\nb\nc\nd\ne\n", string(out))
 }
 
-// func (s *ComplianceTestSuite) TestLLen() {
-// 	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c", "d")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("4\n", string(out))
-
-// 	cmd = exec.Command("redis-cli", "LLEN", "list_key")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("4\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLLenNonExistentList() {
-// 	cmd := exec.Command("redis-cli", "LLEN", "missing_key")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("0\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPop() {
-// 	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "one", "two", "three", "four", "five")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("5\n", string(out))
-
-// 	cmd = exec.Command("redis-cli", "LPOP", "list_key")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("one\n", string(out))
-
-// 	// The popped element should be gone; remaining elements stay in order.
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("two\nthree\nfour\nfive\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPopNonExistentList() {
-// 	cmd := exec.Command("redis-cli", "LPOP", "missing_key")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	// redis-cli renders a null bulk string ($-1\r\n) as a single newline.
-// 	s.Equal("\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPopMultipleElements() {
-// 	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "one", "two", "three", "four", "five")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("5\n", string(out))
-
-// 	// LPOP with a count returns a RESP array of the removed elements.
-// 	cmd = exec.Command("redis-cli", "LPOP", "list_key", "2")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("one\ntwo\n", string(out))
-
-// 	// Verify the remaining elements stay in their original order.
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("three\nfour\nfive\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestLPopCountGreaterThanLength() {
-// 	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("3\n", string(out))
-
-// 	// Count exceeds length: remove and return every element.
-// 	cmd = exec.Command("redis-cli", "LPOP", "list_key", "10")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("a\nb\nc\n", string(out))
-
-// 	// The list should now be empty.
-// 	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("\n", string(out))
-// }
-
-// func (s *ComplianceTestSuite) TestBLPop() {
-// 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-// 	defer cancel()
-
-// 	type result struct {
-// 		out string
-// 		err error
-// 	}
-// 	resCh := make(chan result, 1)
-
-// 	go func() {
-// 		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
-// 		out, err := cmd.CombinedOutput()
-// 		resCh <- result{string(out), err}
-// 	}()
-
-// 	// Give the BLPOP client time to connect and start blocking before we push.
-// 	time.Sleep(100 * time.Millisecond)
-
-// 	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "foo")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("1\n", string(out))
-
-// 	select {
-// 	case res := <-resCh:
-// 		s.Require().NoError(res.err)
-// 		// redis-cli renders the RESP array ["list_key", "foo"] as two lines.
-// 		s.Equal("list_key\nfoo\n", res.out)
-// 	case <-ctx.Done():
-// 		s.T().Fatal("BLPOP did not return after RPUSH")
-// 	}
-// }
-
-// func (s *ComplianceTestSuite) TestBLPopMultipleClientsServedInOrder() {
-// 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-// 	defer cancel()
-
-// 	type result struct {
-// 		out string
-// 		err error
-// 	}
-// 	res1 := make(chan result, 1)
-// 	res2 := make(chan result, 1)
-
-// 	go func() {
-// 		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
-// 		out, err := cmd.CombinedOutput()
-// 		res1 <- result{string(out), err}
-// 	}()
-
-// 	// Ensure client 1 is blocking before client 2 connects, so FIFO order is well-defined.
-// 	time.Sleep(100 * time.Millisecond)
-
-// 	go func() {
-// 		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
-// 		out, err := cmd.CombinedOutput()
-// 		res2 <- result{string(out), err}
-// 	}()
-
-// 	time.Sleep(100 * time.Millisecond)
-
-// 	// First push should wake the client that has been waiting the longest (client 1).
-// 	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "first")
-// 	_, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-
-// 	select {
-// 	case r := <-res1:
-// 		s.Require().NoError(r.err)
-// 		s.Equal("list_key\nfirst\n", r.out)
-// 	case <-ctx.Done():
-// 		s.T().Fatal("client 1 did not receive first push")
-// 	}
-
-// 	// Client 2 must still be blocked - a single push wakes only one waiter.
-// 	select {
-// 	case r := <-res2:
-// 		s.T().Fatalf("client 2 returned before second push: %q", r.out)
-// 	case <-time.After(100 * time.Millisecond):
-// 	}
-
-// 	cmd = exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "second")
-// 	_, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-
-// 	select {
-// 	case r := <-res2:
-// 		s.Require().NoError(r.err)
-// 		s.Equal("list_key\nsecond\n", r.out)
-// 	case <-ctx.Done():
-// 		s.T().Fatal("client 2 did not receive second push")
-// 	}
-// }
-
-// func (s *ComplianceTestSuite) TestBLPopTimeoutExpires() {
-// 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-// 	defer cancel()
-
-// 	start := time.Now()
-// 	cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "missing_key", "0.1")
-// 	out, err := cmd.CombinedOutput()
-// 	elapsed := time.Since(start)
-
-// 	s.Require().NoError(err)
-// 	// redis-cli renders a null array (*-1\r\n) as a single newline.
-// 	s.Equal("\n", string(out))
-// 	// Sanity check: the command must have actually blocked for ~the timeout duration.
-// 	s.GreaterOrEqual(elapsed, 100*time.Millisecond)
-// }
-
-// func (s *ComplianceTestSuite) TestBLPopUnblocksBeforeTimeout() {
-// 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-// 	defer cancel()
-
-// 	type result struct {
-// 		out string
-// 		err error
-// 	}
-// 	resCh := make(chan result, 1)
-
-// 	go func() {
-// 		// Use a generous timeout so the push has time to arrive first.
-// 		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "2")
-// 		out, err := cmd.CombinedOutput()
-// 		resCh <- result{string(out), err}
-// 	}()
-
-// 	time.Sleep(100 * time.Millisecond)
-
-// 	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "foo")
-// 	_, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-
-// 	select {
-// 	case res := <-resCh:
-// 		s.Require().NoError(res.err)
-// 		s.Equal("list_key\nfoo\n", res.out)
-// 	case <-ctx.Done():
-// 		s.T().Fatal("BLPOP did not return after RPUSH")
-// 	}
-// }
-
-// func (s *ComplianceTestSuite) TestSetWithExpiry() {
-// 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-// 	defer cancel()
-
-// 	cmd := exec.CommandContext(ctx, "redis-cli", "SET", "foo", "bar", "PX", "100")
-// 	out, err := cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("OK\n", string(out))
-
-// 	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("bar\n", string(out))
-
-// 	time.Sleep(200 * time.Millisecond)
-
-// 	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
-// 	out, err = cmd.CombinedOutput()
-// 	s.Require().NoError(err)
-// 	s.Equal("\n", string(out))
-// }
+func (s *ComplianceTestSuite) TestLLen() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c", "d")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("4\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LLEN", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("4\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLLenNonExistentList() {
+	cmd := exec.Command("redis-cli", "LLEN", "missing_key")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPop() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "one", "two", "three", "four", "five")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("5\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LPOP", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("one\n", string(out))
+
+	// The popped element should be gone; remaining elements stay in order.
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("two\nthree\nfour\nfive\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPopNonExistentList() {
+	cmd := exec.Command("redis-cli", "LPOP", "missing_key")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	// redis-cli renders a null bulk string ($-1\r\n) as a single newline.
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPopMultipleElements() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "one", "two", "three", "four", "five")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("5\n", string(out))
+
+	// LPOP with a count returns a RESP array of the removed elements.
+	cmd = exec.Command("redis-cli", "LPOP", "list_key", "2")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("one\ntwo\n", string(out))
+
+	// Verify the remaining elements stay in their original order.
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("three\nfour\nfive\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLPopCountGreaterThanLength() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	// Count exceeds length: remove and return every element.
+	cmd = exec.Command("redis-cli", "LPOP", "list_key", "10")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\nb\nc\n", string(out))
+
+	// The list should now be empty.
+	cmd = exec.Command("redis-cli", "LRANGE", "list_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestBLPop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	type result struct {
+		out string
+		err error
+	}
+	resCh := make(chan result, 1)
+
+	go func() {
+		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
+		out, err := cmd.CombinedOutput()
+		resCh <- result{string(out), err}
+	}()
+
+	// Give the BLPOP client time to connect and start blocking before we push.
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "foo")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	select {
+	case res := <-resCh:
+		s.Require().NoError(res.err)
+		// redis-cli renders the RESP array ["list_key", "foo"] as two lines.
+		s.Equal("list_key\nfoo\n", res.out)
+	case <-ctx.Done():
+		s.T().Fatal("BLPOP did not return after RPUSH")
+	}
+}
+
+func (s *ComplianceTestSuite) TestBLPopMultipleClientsServedInOrder() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		out string
+		err error
+	}
+	res1 := make(chan result, 1)
+	res2 := make(chan result, 1)
+
+	go func() {
+		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
+		out, err := cmd.CombinedOutput()
+		res1 <- result{string(out), err}
+	}()
+
+	// Ensure client 1 is blocking before client 2 connects, so FIFO order is well-defined.
+	time.Sleep(100 * time.Millisecond)
+
+	go func() {
+		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
+		out, err := cmd.CombinedOutput()
+		res2 <- result{string(out), err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// First push should wake the client that has been waiting the longest (client 1).
+	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "first")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	select {
+	case r := <-res1:
+		s.Require().NoError(r.err)
+		s.Equal("list_key\nfirst\n", r.out)
+	case <-ctx.Done():
+		s.T().Fatal("client 1 did not receive first push")
+	}
+
+	// Client 2 must still be blocked - a single push wakes only one waiter.
+	select {
+	case r := <-res2:
+		s.T().Fatalf("client 2 returned before second push: %q", r.out)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "second")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	select {
+	case r := <-res2:
+		s.Require().NoError(r.err)
+		s.Equal("list_key\nsecond\n", r.out)
+	case <-ctx.Done():
+		s.T().Fatal("client 2 did not receive second push")
+	}
+}
+
+func (s *ComplianceTestSuite) TestBLPopTimeoutExpires() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "missing_key", "0.1")
+	out, err := cmd.CombinedOutput()
+	elapsed := time.Since(start)
+
+	s.Require().NoError(err)
+	// redis-cli renders a null array (*-1\r\n) as a single newline.
+	s.Equal("\n", string(out))
+	// Sanity check: the command must have actually blocked for ~the timeout duration.
+	s.GreaterOrEqual(elapsed, 100*time.Millisecond)
+}
+
+func (s *ComplianceTestSuite) TestBLPopUnblocksBeforeTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	type result struct {
+		out string
+		err error
+	}
+	resCh := make(chan result, 1)
+
+	go func() {
+		// Use a generous timeout so the push has time to arrive first.
+		cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "2")
+		out, err := cmd.CombinedOutput()
+		resCh <- result{string(out), err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "list_key", "foo")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	select {
+	case res := <-resCh:
+		s.Require().NoError(res.err)
+		s.Equal("list_key\nfoo\n", res.out)
+	case <-ctx.Done():
+		s.T().Fatal("BLPOP did not return after RPUSH")
+	}
+}
+
+func (s *ComplianceTestSuite) TestSetWithExpiry() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "SET", "foo", "bar", "PX", "100")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("bar\n", string(out))
+
+	time.Sleep(200 * time.Millisecond)
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSetWithExSeconds() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "EX", "100")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("100\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSetNX() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "NX")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+
+	// The key already exists, so the second SET is a no-op and returns nil.
+	cmd = exec.Command("redis-cli", "SET", "foo", "baz", "NX")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+
+	cmd = exec.Command("redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("bar\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSetXX() {
+	// The key doesn't exist yet, so nothing is set.
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "XX")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+
+	cmd = exec.Command("redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+
+	cmd = exec.Command("redis-cli", "SET", "foo", "bar")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "SET", "foo", "baz", "XX")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+
+	cmd = exec.Command("redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("baz\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSetGetOption() {
+	// GET returns nil when there was no old value.
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "GET")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+
+	cmd = exec.Command("redis-cli", "SET", "foo", "baz", "GET")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("bar\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSetKeepTTL() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "EX", "100")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "SET", "foo", "baz", "KEEPTTL")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("100\n", string(out))
+
+	// A plain SET discards the existing TTL.
+	cmd = exec.Command("redis-cli", "SET", "foo", "qux")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("-1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestTTLNonExistentKey() {
+	cmd := exec.Command("redis-cli", "TTL", "missing_key")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("-2\n", string(out))
+
+	cmd = exec.Command("redis-cli", "PTTL", "missing_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("-2\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestExpire() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "EXPIRE", "list_key", "100")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("100\n", string(out))
+
+	// Expiring a missing key is a no-op.
+	cmd = exec.Command("redis-cli", "EXPIRE", "missing_key", "100")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestExpireOptions() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	// XX and GT require an existing expiry.
+	cmd = exec.Command("redis-cli", "EXPIRE", "foo", "100", "XX")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.Command("redis-cli", "EXPIRE", "foo", "100", "GT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.Command("redis-cli", "EXPIRE", "foo", "100", "NX")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// LT only lowers the expiry.
+	cmd = exec.Command("redis-cli", "EXPIRE", "foo", "200", "LT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.Command("redis-cli", "EXPIRE", "foo", "50", "LT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("50\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestPExpire() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "SET", "foo", "bar")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "PEXPIRE", "foo", "100")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	time.Sleep(200 * time.Millisecond)
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestExpireInThePastDeletesKey() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "EXPIREAT", "foo", "1")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("-2\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestPersist() {
+	cmd := exec.Command("redis-cli", "SET", "foo", "bar", "PX", "100000")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "PERSIST", "foo")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "TTL", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("-1\n", string(out))
+
+	// There's no expiry left to remove.
+	cmd = exec.Command("redis-cli", "PERSIST", "foo")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestHSetHGet() {
+	cmd := exec.Command("redis-cli", "HSET", "hash_key", "f1", "v1", "f2", "v2")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("2\n", string(out))
+
+	// Overwriting an existing field doesn't count as an addition.
+	cmd = exec.Command("redis-cli", "HSET", "hash_key", "f1", "v1-new", "f3", "v3")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "HGET", "hash_key", "f1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("v1-new\n", string(out))
+
+	cmd = exec.Command("redis-cli", "HGET", "hash_key", "missing_field")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestHGetAll() {
+	cmd := exec.Command("redis-cli", "HSET", "hash_key", "f1", "v1", "f2", "v2")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	// Field order isn't guaranteed, so compare field-value pairs instead.
+	cmd = exec.Command("redis-cli", "HGETALL", "hash_key")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	lines := strings.Fields(string(out))
+	s.Require().Len(lines, 4)
+	s.Equal(map[string]string{"f1": "v1", "f2": "v2"}, map[string]string{
+		lines[0]: lines[1],
+		lines[2]: lines[3],
+	})
+
+	cmd = exec.Command("redis-cli", "HGETALL", "missing_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestHDel() {
+	cmd := exec.Command("redis-cli", "HSET", "hash_key", "f1", "v1", "f2", "v2")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "HDEL", "hash_key", "f1", "missing_field")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// Deleting the last field removes the key, so it can hold another type.
+	cmd = exec.Command("redis-cli", "HDEL", "hash_key", "f2")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "RPUSH", "hash_key", "a")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSAddSMembers() {
+	cmd := exec.Command("redis-cli", "SADD", "set_key", "a", "b", "a")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("2\n", string(out))
+
+	cmd = exec.Command("redis-cli", "SADD", "set_key", "b", "c")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// Set members are unordered.
+	cmd = exec.Command("redis-cli", "SMEMBERS", "set_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.ElementsMatch([]string{"a", "b", "c"}, strings.Fields(string(out)))
+}
+
+func (s *ComplianceTestSuite) TestSIsMember() {
+	cmd := exec.Command("redis-cli", "SADD", "set_key", "a")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "SISMEMBER", "set_key", "a")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "SISMEMBER", "set_key", "b")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.Command("redis-cli", "SISMEMBER", "missing_key", "a")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSInter() {
+	cmd := exec.Command("redis-cli", "SADD", "set1", "a", "b", "c", "d")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "SADD", "set2", "c", "d", "e")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "SINTER", "set1", "set2")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.ElementsMatch([]string{"c", "d"}, strings.Fields(string(out)))
+
+	// A missing key is an empty set, so the intersection is empty too.
+	cmd = exec.Command("redis-cli", "SINTER", "set1", "missing_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestZAddZRange() {
+	cmd := exec.Command("redis-cli", "ZADD", "zset_key", "3", "c", "1", "a", "2", "b")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANGE", "zset_key", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\nb\nc\n", string(out))
+
+	// Updating a score reorders the member but doesn't count as an addition.
+	cmd = exec.Command("redis-cli", "ZADD", "zset_key", "0.5", "c")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANGE", "zset_key", "0", "1", "WITHSCORES")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("c\n0.5\na\n1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestZAddTiesOrderedByMember() {
+	cmd := exec.Command("redis-cli", "ZADD", "zset_key", "1", "b", "1", "c", "1", "a")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "ZRANGE", "zset_key", "0", "-1")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\nb\nc\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestZAddOptions() {
+	cmd := exec.Command("redis-cli", "ZADD", "zset_key", "1", "a")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	// NX never updates existing members.
+	cmd = exec.Command("redis-cli", "ZADD", "zset_key", "NX", "5", "a", "2", "b")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// XX never adds new members; CH also counts updated ones.
+	cmd = exec.Command("redis-cli", "ZADD", "zset_key", "XX", "CH", "3", "a", "4", "c")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// GT only updates when the new score is greater.
+	cmd = exec.Command("redis-cli", "ZADD", "zset_key", "GT", "CH", "0", "a", "10", "b")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANGE", "zset_key", "0", "-1", "WITHSCORES")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\n3\nb\n10\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestZRangeByScore() {
+	cmd := exec.Command("redis-cli", "ZADD", "zset_key", "1", "a", "2", "b", "3", "c", "4", "d")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "ZRANGEBYSCORE", "zset_key", "2", "3")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("b\nc\n", string(out))
+
+	// "(" makes a bound exclusive.
+	cmd = exec.Command("redis-cli", "ZRANGEBYSCORE", "zset_key", "(1", "(4", "WITHSCORES")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("b\n2\nc\n3\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANGEBYSCORE", "zset_key", "-inf", "+inf", "LIMIT", "1", "2")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("b\nc\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANGEBYSCORE", "zset_key", "5", "+inf")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestZRank() {
+	cmd := exec.Command("redis-cli", "ZADD", "zset_key", "1", "a", "2", "b", "3", "c")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "ZRANK", "zset_key", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("2\n", string(out))
+
+	cmd = exec.Command("redis-cli", "ZRANK", "zset_key", "missing_member")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestWrongType() {
+	cmd := exec.Command("redis-cli", "SET", "string_key", "foo")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	cmd = exec.Command("redis-cli", "HSET", "hash_key", "f", "v")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	wrongTypeCmds := [][]string{
+		{"HSET", "string_key", "f", "v"},
+		{"HGETALL", "string_key"},
+		{"SADD", "string_key", "a"},
+		{"SINTER", "hash_key"},
+		{"ZADD", "string_key", "1", "a"},
+		{"ZRANGE", "hash_key", "0", "-1"},
+		{"RPUSH", "hash_key", "a"},
+		{"GET", "hash_key"},
+	}
+	for _, args := range wrongTypeCmds {
+		// redis-cli may exit with a non-zero status on error replies.
+		out, _ := exec.Command("redis-cli", args...).CombinedOutput()
+		s.Contains(string(out), "WRONGTYPE", args)
+	}
+
+	// SET overwrites a value of any type.
+	cmd = exec.Command("redis-cli", "SET", "hash_key", "foo")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestMultiExec() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("MULTI\nSET foo bar\nRPUSH list_key a b\nGET foo\nEXEC\n")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	// Each command is queued, then EXEC returns an array with every reply.
+	s.Equal("OK\nQUEUED\nQUEUED\nQUEUED\nOK\n2\nbar\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestMultiExecRunsRemainingCommandsAfterError() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("SET foo bar\nMULTI\nRPUSH foo a\nSET baz qux\nEXEC\n")
+	out, _ := cmd.CombinedOutput()
+	s.Contains(string(out), "WRONGTYPE")
+
+	cmd = exec.Command("redis-cli", "GET", "baz")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("qux\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestMultiExecAbortsOnQueueError() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("MULTI\nSET foo bar\nNOT_A_COMMAND\nEXEC\n")
+	out, _ := cmd.CombinedOutput()
+	s.Contains(string(out), "EXECABORT")
+
+	// None of the queued commands ran.
+	cmd = exec.Command("redis-cli", "GET", "foo")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestDiscard() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("MULTI\nSET foo bar\nDISCARD\nGET foo\n")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\nQUEUED\nOK\n\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestExecWithoutMulti() {
+	cmd := exec.Command("redis-cli", "EXEC")
+	out, _ := cmd.CombinedOutput()
+	s.Contains(string(out), "EXEC without MULTI")
+}
+
+func (s *ComplianceTestSuite) TestWatchAbortsExecWhenKeyChanges() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	watcher := exec.CommandContext(ctx, "redis-cli")
+	watcher.Stdout = &out
+	stdin, err := watcher.StdinPipe()
+	s.Require().NoError(err)
+	s.Require().NoError(watcher.Start())
+
+	_, err = stdin.Write([]byte("WATCH foo\n"))
+	s.Require().NoError(err)
+
+	// Give the watcher time to send WATCH before another client modifies the key.
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "SET", "foo", "changed")
+	_, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	_, err = stdin.Write([]byte("MULTI\nSET foo from_tx\nEXEC\n"))
+	s.Require().NoError(err)
+	s.Require().NoError(stdin.Close())
+	s.Require().NoError(watcher.Wait())
+
+	// redis-cli renders the null array returned by an aborted EXEC as an empty line.
+	s.Equal("OK\nOK\nQUEUED\n\n", out.String())
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "GET", "foo")
+	getOut, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("changed\n", string(getOut))
+}
+
+func (s *ComplianceTestSuite) TestWatchUnchangedKey() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("SET foo bar\nWATCH foo\nGET foo\nMULTI\nSET foo baz\nEXEC\n")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\nOK\nbar\nOK\nQUEUED\nOK\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestUnwatch() {
+	cmd := exec.Command("redis-cli")
+	cmd.Stdin = bytes.NewBufferString("WATCH foo\nSET foo bar\nUNWATCH\nMULTI\nSET foo baz\nEXEC\n")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("OK\nOK\nOK\nOK\nQUEUED\nOK\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestPublishWithoutSubscribers() {
+	cmd := exec.Command("redis-cli", "PUBLISH", "news", "hello")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestSubscribePublish() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscriber := exec.CommandContext(ctx, "redis-cli", "SUBSCRIBE", "news", "sports")
+	stdout, err := subscriber.StdoutPipe()
+	s.Require().NoError(err)
+	s.Require().NoError(subscriber.Start())
+	defer subscriber.Wait()
+	defer cancel()
+
+	lines := bufio.NewScanner(stdout)
+	readLines := func(n int) string {
+		var retval []string
+		for range n {
+			s.Require().True(lines.Scan(), "subscriber output ended early")
+			retval = append(retval, lines.Text())
+		}
+		return strings.Join(retval, "\n")
+	}
+
+	// Each subscription is confirmed with the number of channels the client
+	// is subscribed to.
+	s.Equal("subscribe\nnews\n1\nsubscribe\nsports\n2", readLines(6))
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "PUBLISH", "news", "hello")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	s.Equal("message\nnews\nhello", readLines(3))
+}
+
+func (s *ComplianceTestSuite) TestPSubscribePublish() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscriber := exec.CommandContext(ctx, "redis-cli", "PSUBSCRIBE", "news.*")
+	stdout, err := subscriber.StdoutPipe()
+	s.Require().NoError(err)
+	s.Require().NoError(subscriber.Start())
+	defer subscriber.Wait()
+	defer cancel()
+
+	lines := bufio.NewScanner(stdout)
+	readLines := func(n int) string {
+		var retval []string
+		for range n {
+			s.Require().True(lines.Scan(), "subscriber output ended early")
+			retval = append(retval, lines.Text())
+		}
+		return strings.Join(retval, "\n")
+	}
+
+	s.Equal("psubscribe\nnews.*\n1", readLines(3))
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "PUBLISH", "sports.football", "goal")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "PUBLISH", "news.tech", "hello")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	s.Equal("pmessage\nnews.*\nnews.tech\nhello", readLines(4))
+}
+
+func (s *ComplianceTestSuite) TestRPop() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	cmd = exec.Command("redis-cli", "RPOP", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("c\n", string(out))
+
+	// RPOP with a count returns the elements in the order they were popped.
+	cmd = exec.Command("redis-cli", "RPOP", "list_key", "5")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("b\na\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LLEN", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("0\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestLIndex() {
+	cmd := exec.Command("redis-cli", "RPUSH", "list_key", "a", "b", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	for index, want := range map[string]string{"0": "a\n", "-1": "c\n", "3": "\n", "-4": "\n"} {
+		cmd = exec.Command("redis-cli", "LINDEX", "list_key", index)
+		out, err = cmd.CombinedOutput()
+		s.Require().NoError(err)
+		s.Equal(want, string(out), "index %s", index)
+	}
+}
+
+func (s *ComplianceTestSuite) TestLMove() {
+	cmd := exec.Command("redis-cli", "RPUSH", "src", "a", "b", "c")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("3\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LMOVE", "src", "dst", "RIGHT", "LEFT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("c\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LMOVE", "src", "dst", "LEFT", "LEFT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LRANGE", "dst", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\nc\n", string(out))
+
+	// Moving within the same list rotates it.
+	cmd = exec.Command("redis-cli", "LMOVE", "dst", "dst", "LEFT", "RIGHT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("a\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LRANGE", "dst", "0", "-1")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("c\na\n", string(out))
+
+	cmd = exec.Command("redis-cli", "LMOVE", "missing_key", "dst", "LEFT", "RIGHT")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestBRPopMultipleKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	type result struct {
+		out string
+		err error
+	}
+	resCh := make(chan result, 1)
+
+	go func() {
+		cmd := exec.CommandContext(ctx, "redis-cli", "BRPOP", "first_key", "second_key", "0")
+		out, err := cmd.CombinedOutput()
+		resCh <- result{string(out), err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "RPUSH", "second_key", "a", "b")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	select {
+	case res := <-resCh:
+		s.Require().NoError(res.err)
+		s.Equal("second_key\nb\n", res.out)
+	case <-ctx.Done():
+		s.T().Fatal("BRPOP did not return after RPUSH")
+	}
+}
+
+func (s *ComplianceTestSuite) TestBLMove() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	type result struct {
+		out string
+		err error
+	}
+	resCh := make(chan result, 1)
+
+	go func() {
+		cmd := exec.CommandContext(ctx, "redis-cli", "BLMOVE", "jobs", "processing", "LEFT", "RIGHT", "0")
+		out, err := cmd.CombinedOutput()
+		resCh <- result{string(out), err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.CommandContext(ctx, "redis-cli", "LPUSH", "jobs", "job1")
+	_, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+
+	select {
+	case res := <-resCh:
+		s.Require().NoError(res.err)
+		s.Equal("job1\n", res.out)
+	case <-ctx.Done():
+		s.T().Fatal("BLMOVE did not return after LPUSH")
+	}
+
+	cmd = exec.CommandContext(ctx, "redis-cli", "LRANGE", "processing", "0", "-1")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("job1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestBLPopDisconnectedClientIsNotServed() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "redis-cli", "BLPOP", "list_key", "0")
+	s.Require().NoError(cmd.Start())
+
+	// Kill the client while it's blocked.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	_ = cmd.Wait()
+	time.Sleep(100 * time.Millisecond)
+
+	cmd = exec.Command("redis-cli", "RPUSH", "list_key", "foo")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	// The element must not have been handed to the disconnected client.
+	cmd = exec.Command("redis-cli", "LLEN", "list_key")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestRESP3() {
+	cmd := exec.Command("redis-cli", "-3", "HSET", "hash", "field", "value")
+	out, err := cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("1\n", string(out))
+
+	cmd = exec.Command("redis-cli", "-3", "HGETALL", "hash")
+	out, err = cmd.CombinedOutput()
+	s.Require().NoError(err)
+	s.Equal("field\nvalue\n", string(out))
+}
+
+func (s *ComplianceTestSuite) TestInlineCommands() {
+	conn, err := net.Dial("tcp", "127.0.0.1:6379")
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	// This is what a telnet session sends.
+	_, err = conn.Write([]byte("PING\r\nSET foo \"hello world\"\r\nGET foo\r\n"))
+	s.Require().NoError(err)
+
+	expected := "+PONG\r\n+OK\r\n$11\r\nhello world\r\n"
+	buf := make([]byte, len(expected))
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(conn, buf)
+	s.Require().NoError(err)
+	s.Equal(expected, string(buf))
+}
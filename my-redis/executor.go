@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -11,21 +12,94 @@ import (
 )
 
 type command struct {
-	name  string
-	args  [][]byte
-	reply chan []byte
+	name   string
+	args   [][]byte
+	client *client
+	reply  chan []byte
+	// free is set for the internal command that frees a disconnected
+	// client. It goes through the queue, so that it runs after the commands
+	// the client already submitted.
+	free bool
 }
 
 // executor parses and runs commands in a single thread.
 type executor struct {
 	store *store
+	cfg   config
 	queue chan command
+	// tasks holds internal work that must run on the executor goroutine,
+	// such as swapping in a freshly rewritten AOF file.
+	tasks chan func()
+	// aof is nil when append-only persistence is disabled.
+	aof *aof
+	// bgSaving is true while a BGSAVE is writing a snapshot.
+	bgSaving bool
+	// lastSave is the time of the last successful SAVE or BGSAVE.
+	lastSave time.Time
+	// inExec is true while the commands of a transaction are running.
+	inExec bool
+	// execPropagated is true once a MULTI has been propagated for the
+	// running transaction, so that it can be closed with an EXEC.
+	execPropagated bool
+	// channels and patterns hold the clients subscribed to each pub/sub
+	// channel and pattern.
+	channels map[string]map[*client]struct{}
+	patterns map[string]map[*client]struct{}
+	// blockedKeys holds the clients blocked on each key, in the order they
+	// blocked.
+	blockedKeys map[string][]*blockedClient
+	// readyKeys holds the keys with blocked clients that received elements
+	// during the current command.
+	readyKeys []string
+
+	// replID and replOffset identify the position in the replication stream
+	// sent to replicas.
+	replID     string
+	replOffset int64
+	// backlog is nil until the first replica connects.
+	backlog  *replBacklog
+	replicas map[*client]*replica
+	// numFullSyncs and numPartialSyncs count the PSYNC requests that were
+	// served with a full sync and a partial resync respectively.
+	numFullSyncs    int
+	numPartialSyncs int
+	// link is the connection to the primary when the server is a replica, and
+	// masterClient is the client that applies its replication stream.
+	link         *replicationLink
+	masterClient *client
+
+	// clients holds the clients connected to the server.
+	clients map[*client]struct{}
+	// startTime is when the server started, to report its uptime.
+	startTime time.Time
+	// numConns and numRejectedConns count the connections accepted and
+	// rejected because of maxclients since the server started.
+	numConns         int
+	numRejectedConns int
+	// numCommands counts the commands processed since the server started,
+	// and ops samples it to compute the number of commands per second.
+	numCommands int64
+	ops         opsTracker
 }
 
-func newExecutor(store *store) *executor {
+func newExecutor(store *store, cfg config) *executor {
 	ex := &executor{
 		store: store,
+		cfg:   cfg,
 		queue: make(chan command, 100),
+		tasks: make(chan func()),
+		// Redis reports the startup time as LASTSAVE until the first save.
+		lastSave: time.Now(),
+		channels: make(map[string]map[*client]struct{}),
+		patterns: make(map[string]map[*client]struct{}),
+
+		blockedKeys: make(map[string][]*blockedClient),
+
+		replID:   newReplID(),
+		replicas: make(map[*client]*replica),
+
+		clients:   make(map[*client]struct{}),
+		startTime: time.Now(),
 	}
 	go ex.loop()
 
@@ -33,122 +107,410 @@ func newExecutor(store *store) *executor {
 }
 
 func (ex *executor) loop() {
-	for cmd := range ex.queue {
-		switch cmd.name {
-		case "PING":
-			cmd.reply <- resp.SerializeSimpleString("PONG")
-		case "ECHO":
-			if len(cmd.args) == 0 {
-				cmd.reply <- resp.SerializeSimpleError("missing argument")
-				return
-			}
-			cmd.reply <- resp.SerializeBulkString(cmd.args[0])
-		case "GET":
-			if len(cmd.args) != 1 {
-				cmd.reply <- resp.SerializeSimpleError(fmt.Sprintf(
-					"invalid number of arguments: expect 1, got %d", len(cmd.args)))
-				return
-			}
+	activeExpireTicker := time.NewTicker(activeExpireCycleInterval)
+	defer activeExpireTicker.Stop()
+	cronTicker := time.NewTicker(serverCronInterval)
+	defer cronTicker.Stop()
 
-			key := string(cmd.args[0])
-			val, err := ex.store.get(key)
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
-			}
-			cmd.reply <- resp.SerializeBulkString(val)
-		case "SET":
-			setArgs, err := parseSetCmdArgs(cmd.args)
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
+	for {
+		select {
+		case cmd := <-ex.queue:
+			if cmd.free {
+				ex.free(cmd.client)
+				continue
 			}
 
-			err = ex.store.set(setArgs.key, setArgs.val, setArgs.expiredAt)
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
-			}
-			cmd.reply <- resp.SerializeSimpleString("OK")
-		case "RPUSH":
-			if len(cmd.args) < 2 {
-				cmd.reply <- resp.SerializeSimpleError(fmt.Sprintf(
-					"invalid number of arguments: expect at least 2, got %d", len(cmd.args)))
-				return
+			ex.numCommands++
+			cmd.client.lastInteraction = time.Now()
+			cmd.client.lastCmd = cmd.name
+
+			reply := ex.process(cmd)
+			if reply != nil {
+				ex.reply(cmd, reply)
 			}
+			ex.serveBlockedClients()
+		case task := <-ex.tasks:
+			task()
+		case <-activeExpireTicker.C:
+			ex.store.activeExpireCycle()
+		case now := <-cronTicker.C:
+			ex.ops.sample(now, ex.numCommands)
+			ex.closeIdleClients(now)
+		}
+	}
+}
 
-			key := string(cmd.args[0])
-			values := cmd.args[1:]
+// reply sends the reply of cmd to the client that sent it.
+func (ex *executor) reply(cmd command, reply []byte) {
+	reply = adaptNull(cmd.client, reply)
+	// A blocked client shouldn't be considered idle as soon as it's served.
+	cmd.client.lastInteraction = time.Now()
+	cmd.client.send(reply)
+	cmd.reply <- reply
+	close(cmd.reply)
+}
 
-			cnt, err := ex.store.rpush(key, values)
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
-			}
+// process runs a command sent by a client, or queues it if the client is in
+// the middle of a transaction.
+func (ex *executor) process(cmd command) []byte {
+	if cmd.client.closed {
+		// The client disconnected, so nobody reads the reply.
+		return []byte{}
+	}
 
-			cmd.reply <- resp.SerializeInteger(cnt)
-		case "LRANGE":
-			if len(cmd.args) != 3 {
-				cmd.reply <- resp.SerializeSimpleError(fmt.Sprintf(
-					"invalid number of arguments: expect 3, got %d", len(cmd.args)))
-				return
-			}
+	if errReply := checkSubscriberMode(cmd); errReply != nil {
+		return errReply
+	}
 
-			key := string(cmd.args[0])
-			start, err := strconv.Atoi(string(cmd.args[1]))
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
-			}
+	if cmd.client.master && cmd.client != ex.masterClient {
+		// The command comes from a primary that this server no longer
+		// replicates, or from before the last full sync.
+		return []byte{}
+	}
+	if ex.link != nil && !cmd.client.master && commandTable[cmd.name].write {
+		return resp.SerializeSimpleError(errReadOnlyReplica.Error())
+	}
 
-			stop, err := strconv.Atoi(string(cmd.args[2]))
-			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
-			}
+	if cmd.client.inMulti {
+		switch cmd.name {
+		case "MULTI", "EXEC", "DISCARD", "WATCH":
+		default:
+			return ex.queueCommand(cmd)
+		}
+	}
 
-			retval, err := ex.store.lrange(key, start, stop)
+	return ex.dispatch(cmd)
+}
+
+// dispatch runs a single command against the store and returns its
+// RESP-encoded reply. The reply is nil if the client was blocked, in which
+// case it's sent once the client is unblocked.
+func (ex *executor) dispatch(cmd command) []byte {
+	switch cmd.name {
+	case "PING":
+		// RESP2 subscribers get an array, since a simple string could be
+		// mistaken for a pushed message.
+		if cmd.client.subscribed() && cmd.client.protocol == 2 {
+			return resp.SerializeArray([][]byte{[]byte("pong"), {}})
+		}
+		return resp.SerializeSimpleString("PONG")
+	case "ECHO":
+		if len(cmd.args) == 0 {
+			return resp.SerializeSimpleError("missing argument")
+		}
+		return resp.SerializeBulkString(cmd.args[0])
+	case "GET":
+		if len(cmd.args) != 1 {
+			return resp.SerializeSimpleError(fmt.Sprintf(
+				"invalid number of arguments: expect 1, got %d", len(cmd.args)))
+		}
+
+		key := string(cmd.args[0])
+		val, err := ex.store.get(key)
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		return resp.SerializeBulkString(val)
+	case "SET":
+		setArgs, err := parseSetCmdArgs(cmd.args)
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+
+		var oldVal []byte
+		if setArgs.get {
+			oldVal, err = ex.store.get(setArgs.key)
 			if err != nil {
-				cmd.reply <- resp.SerializeSimpleError(err.Error())
-				return
+				return resp.SerializeSimpleError(err.Error())
 			}
+		}
 
-			cmd.reply <- resp.SerializeArray(retval)
-		default:
-			cmd.reply <- resp.SerializeSimpleError(
-				fmt.Sprintf("unsupported command: %s", cmd.name))
+		existing, exists := ex.store.lookup(setArgs.key)
+		if (setArgs.condition == setIfNotExists && exists) ||
+			(setArgs.condition == setIfExists && !exists) {
+			// The reply is the old value when GET is given, which is nil when
+			// the key doesn't exist.
+			return resp.SerializeBulkString(oldVal)
 		}
 
-		close(cmd.reply)
+		expiredAt := setArgs.expiredAt
+		if setArgs.keepTTL {
+			expiredAt = existing.expiredAt
+		}
+		ex.store.set(setArgs.key, setArgs.val, expiredAt)
+
+		// Relative expiries are rewritten into absolute ones so that replaying
+		// the command later doesn't extend the key's lifetime.
+		propagatedArgs := [][]byte{[]byte(setArgs.key), setArgs.val}
+		if !expiredAt.IsZero() {
+			propagatedArgs = append(propagatedArgs,
+				[]byte("PXAT"), strconv.AppendInt(nil, expiredAt.UnixMilli(), 10))
+		}
+		ex.propagate("SET", propagatedArgs)
+
+		if setArgs.get {
+			return resp.SerializeBulkString(oldVal)
+		}
+		return resp.SerializeSimpleString("OK")
+	case "RPUSH":
+		if len(cmd.args) < 2 {
+			return resp.SerializeSimpleError(fmt.Sprintf(
+				"invalid number of arguments: expect at least 2, got %d", len(cmd.args)))
+		}
+
+		key := string(cmd.args[0])
+		values := cmd.args[1:]
+
+		cnt, err := ex.store.rpush(key, values)
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		ex.propagate(cmd.name, cmd.args)
+		ex.signalKeyAsReady(key)
+
+		return resp.SerializeInteger(cnt)
+	case "LRANGE":
+		if len(cmd.args) != 3 {
+			return resp.SerializeSimpleError(fmt.Sprintf(
+				"invalid number of arguments: expect 3, got %d", len(cmd.args)))
+		}
+
+		key := string(cmd.args[0])
+		start, err := strconv.Atoi(string(cmd.args[1]))
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+
+		stop, err := strconv.Atoi(string(cmd.args[2]))
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+
+		retval, err := ex.store.lrange(key, start, stop)
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+
+		return resp.SerializeArray(retval)
+	case "LPUSH":
+		return ex.lpushCmd(cmd)
+	case "LPOP", "RPOP":
+		return ex.popCmd(cmd)
+	case "LLEN":
+		return ex.llenCmd(cmd)
+	case "LINDEX":
+		return ex.lindexCmd(cmd)
+	case "LMOVE":
+		return ex.lmoveCmd(cmd)
+	case "BLPOP", "BRPOP":
+		return ex.blockingPopCmd(cmd)
+	case "BLMOVE":
+		return ex.blmoveCmd(cmd)
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		return ex.expireCmd(cmd)
+	case "TTL", "PTTL":
+		return ex.ttlCmd(cmd)
+	case "PERSIST":
+		if len(cmd.args) != 1 {
+			return resp.SerializeSimpleError(fmt.Sprintf(
+				"invalid number of arguments: expect 1, got %d", len(cmd.args)))
+		}
+
+		if !ex.store.persist(string(cmd.args[0])) {
+			return resp.SerializeInteger(0)
+		}
+		ex.propagate(cmd.name, cmd.args)
+
+		return resp.SerializeInteger(1)
+	case "HSET":
+		return ex.hsetCmd(cmd)
+	case "HGET":
+		return ex.hgetCmd(cmd)
+	case "HGETALL":
+		return ex.hgetallCmd(cmd)
+	case "HDEL":
+		return ex.hdelCmd(cmd)
+	case "SADD":
+		return ex.saddCmd(cmd)
+	case "SMEMBERS":
+		return ex.smembersCmd(cmd)
+	case "SISMEMBER":
+		return ex.sismemberCmd(cmd)
+	case "SINTER":
+		return ex.sinterCmd(cmd)
+	case "ZADD":
+		return ex.zaddCmd(cmd)
+	case "ZRANGE":
+		return ex.zrangeCmd(cmd)
+	case "ZRANGEBYSCORE":
+		return ex.zrangeByScoreCmd(cmd)
+	case "ZRANK":
+		return ex.zrankCmd(cmd)
+	case "HELLO":
+		return ex.helloCmd(cmd)
+	case "MULTI":
+		return ex.multiCmd(cmd)
+	case "EXEC":
+		return ex.execCmd(cmd)
+	case "DISCARD":
+		return ex.discardCmd(cmd)
+	case "WATCH":
+		return ex.watchCmd(cmd)
+	case "UNWATCH":
+		return ex.unwatchCmd(cmd)
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		return ex.subscribeCmd(cmd)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return ex.unsubscribeCmd(cmd)
+	case "PUBLISH":
+		return ex.publishCmd(cmd)
+	case "REPLICAOF", "SLAVEOF":
+		return ex.replicaofCmd(cmd)
+	case "PSYNC":
+		return ex.psyncCmd(cmd)
+	case "REPLCONF":
+		return ex.replconfCmd(cmd)
+	case "ROLE":
+		return ex.roleCmd(cmd)
+	case "CLIENT":
+		return ex.clientCmd(cmd)
+	case "INFO":
+		return ex.infoCmd(cmd)
+	case "BGREWRITEAOF":
+		err := ex.bgRewriteAOF()
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		return resp.SerializeSimpleString("Background append only file rewriting started")
+	case "SAVE":
+		err := ex.save()
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		return resp.SerializeSimpleString("OK")
+	case "BGSAVE":
+		err := ex.bgSave()
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		return resp.SerializeSimpleString("Background saving started")
+	case "LASTSAVE":
+		return resp.SerializeInteger(int(ex.lastSave.Unix()))
+	default:
+		return resp.SerializeSimpleError(
+			fmt.Sprintf("unsupported command: %s", cmd.name))
 	}
 }
 
-// execute parses and runs the given command array and returns its
-// output.
-func (ex *executor) execute(rawCmd [][]byte) []byte {
+// wrongNumArgs returns the error reply for a command called with an
+// unexpected number of arguments.
+func wrongNumArgs(expect string, got int) []byte {
+	return resp.SerializeSimpleError(fmt.Sprintf(
+		"invalid number of arguments: expect %s, got %d", expect, got))
+}
+
+// propagate records a successfully executed write command so that it survives
+// a restart. Commands should be propagated in a form that yields the same
+// result when replayed at a later time.
+func (ex *executor) propagate(name string, args [][]byte) {
+	if ex.aof == nil && ex.backlog == nil {
+		return
+	}
+
+	// The writes of a transaction are wrapped in MULTI and EXEC, so that
+	// they are replayed atomically too.
+	if ex.inExec && !ex.execPropagated {
+		ex.execPropagated = true
+		ex.propagate("MULTI", nil)
+	}
+
+	if ex.aof != nil {
+		err := ex.aof.append(name, args)
+		if err != nil {
+			slog.Error("append command to AOF failed", "cmd", name, "err", err)
+		}
+	}
+
+	if ex.backlog != nil {
+		ex.feedReplicas(resp.SerializeArray(append([][]byte{[]byte(name)}, args...)))
+	}
+}
+
+// propagateToReplicas sends a command to the replicas only, for commands such
+// as PUBLISH that have an effect on replicas but don't change the dataset.
+func (ex *executor) propagateToReplicas(name string, args [][]byte) {
+	if ex.backlog == nil {
+		return
+	}
+
+	if ex.inExec && !ex.execPropagated {
+		ex.execPropagated = true
+		ex.propagate("MULTI", nil)
+	}
+	ex.feedReplicas(resp.SerializeArray(append([][]byte{[]byte(name)}, args...)))
+}
+
+// execute parses and runs the given command array on behalf of c and returns
+// its output. The output is also queued to be written to c's connection, if
+// it has one.
+func (ex *executor) execute(c *client, rawCmd [][]byte) []byte {
+	return <-ex.submit(c, rawCmd)
+}
+
+// submit queues the given command array to run on behalf of c and returns a
+// channel that receives its output.
+func (ex *executor) submit(c *client, rawCmd [][]byte) <-chan []byte {
 	if len(rawCmd) == 0 {
-		return resp.SerializeSimpleError("empty command")
+		res := resp.SerializeSimpleError("empty command")
+		c.send(res)
+
+		reply := make(chan []byte, 1)
+		reply <- res
+		return reply
 	}
 
 	name := strings.ToUpper(string(rawCmd[0]))
 	args := rawCmd[1:]
 
 	cmd := command{
-		name:  name,
-		args:  args,
-		reply: make(chan []byte, 1),
+		name:   name,
+		args:   args,
+		client: c,
+		reply:  make(chan []byte, 1),
 	}
 	ex.queue <- cmd
 
-	return <-cmd.reply
+	return cmd.reply
 }
 
+// setCondition limits when a SET command is allowed to write its value.
+type setCondition int
+
+const (
+	setAlways setCondition = iota
+	// setIfNotExists only sets the key if it doesn't already exist (NX).
+	setIfNotExists
+	// setIfExists only sets the key if it already exists (XX).
+	setIfExists
+)
+
 type setCmdArgs struct {
 	key       string
 	val       []byte
 	expiredAt time.Time
+	condition setCondition
+	// get makes SET return the old value stored at key.
+	get bool
+	// keepTTL retains the time to live of the existing key.
+	keepTTL bool
 }
 
+// parseSetCmdArgs parses the arguments of a SET command.
+//
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+//
+// https://redis.io/docs/latest/commands/set/
 func parseSetCmdArgs(args [][]byte) (retval setCmdArgs, err error) {
 	cur := 0
 	read := func() (retval []byte, isEOF bool) {
@@ -177,8 +539,7 @@ func parseSetCmdArgs(args [][]byte) (retval setCmdArgs, err error) {
 	}
 	retval.val = val
 
-	// TODO: parse according to Redis's syntax diagram.
-	// https://redis.io/docs/latest/commands/set/
+	hasExpiry := false
 	for {
 		// parse command options
 		optNameBytes, eof := read()
@@ -188,21 +549,49 @@ func parseSetCmdArgs(args [][]byte) (retval setCmdArgs, err error) {
 		optName := strings.ToUpper(string(optNameBytes))
 
 		switch optName {
-		case "PX":
-			expiryMillisBytes, eof := read()
+		case "NX", "XX":
+			if retval.condition != setAlways {
+				err = errors.New("syntax error: NX and XX options at the same time are not compatible")
+				return
+			}
+
+			retval.condition = setIfNotExists
+			if optName == "XX" {
+				retval.condition = setIfExists
+			}
+		case "GET":
+			retval.get = true
+		case "KEEPTTL":
+			if hasExpiry {
+				err = errors.New("syntax error: KEEPTTL can't be combined with an expire time")
+				return
+			}
+			retval.keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpiry || retval.keepTTL {
+				err = errors.New("syntax error: only one expire option is allowed")
+				return
+			}
+			hasExpiry = true
+
+			expiryBytes, eof := read()
 			if eof {
 				err = fmt.Errorf("reading value for option '%s': unexpected EOF", optNameBytes)
 				return
 			}
 
-			var expiryMillis int
-			expiryMillis, err = strconv.Atoi(string(expiryMillisBytes))
+			var expiry int64
+			expiry, err = strconv.ParseInt(string(expiryBytes), 10, 64)
 			if err != nil {
 				err = fmt.Errorf("convert duration to int: %w", err)
 				return
 			}
 
-			retval.expiredAt = time.Now().Add(time.Duration(expiryMillis) * time.Millisecond)
+			retval.expiredAt, err = expireTime(expiry, optName)
+			if err != nil || expiry <= 0 {
+				err = errors.New("invalid expire time in 'set' command")
+				return
+			}
 		default:
 			err = fmt.Errorf("invalid option %s", optName)
 			return
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+const (
+	// activeExpireCycleInterval is how often the executor looks for expired
+	// keys that nobody reads anymore. It matches Redis's default `hz 10`.
+	activeExpireCycleInterval = 100 * time.Millisecond
+	// activeExpireKeysPerLoop is the number of keys with an expiry sampled in
+	// each iteration of the active expiration cycle.
+	activeExpireKeysPerLoop = 20
+	// activeExpireCycleTimeLimit bounds how long a single cycle can block the
+	// executor, so that clients aren't starved when many keys expire at once.
+	activeExpireCycleTimeLimit = 25 * time.Millisecond
+)
+
+// expireCondition limits when an EXPIRE command is allowed to change the
+// expiry of a key.
+type expireCondition int
+
+const (
+	expireAlways expireCondition = iota
+	// expireIfNoExpiry only sets the expiry if the key has none (NX).
+	expireIfNoExpiry
+	// expireIfHasExpiry only sets the expiry if the key already has one (XX).
+	expireIfHasExpiry
+	// expireIfGreater only sets the expiry if it's later than the current
+	// one (GT). A key without expiry is treated as having an infinite TTL.
+	expireIfGreater
+	// expireIfLess only sets the expiry if it's earlier than the current one
+	// (LT). A key without expiry is treated as having an infinite TTL.
+	expireIfLess
+)
+
+// expireTime converts the argument of an expire option into an absolute time.
+// opt is one of EX, PX, EXAT or PXAT, which respectively take a number of
+// seconds or milliseconds from now, or a unix timestamp in seconds or
+// milliseconds.
+func expireTime(n int64, opt string) (time.Time, error) {
+	millis := n
+	if opt == "EX" || opt == "EXAT" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return time.Time{}, errors.New("expire time overflows")
+		}
+		millis = n * 1000
+	}
+
+	if opt == "EX" || opt == "PX" {
+		nowMillis := time.Now().UnixMilli()
+		if (millis > 0 && nowMillis > math.MaxInt64-millis) ||
+			(millis < 0 && nowMillis < math.MinInt64-millis) {
+			return time.Time{}, errors.New("expire time overflows")
+		}
+		millis += nowMillis
+	}
+
+	return time.UnixMilli(millis), nil
+}
+
+// expire sets the expiry of key to at, provided that cond holds. A time in
+// the past deletes the key right away. It reports whether the expiry was
+// changed.
+func (s *store) expire(key string, at time.Time, cond expireCondition) bool {
+	e, ok := s.lookup(key)
+	if !ok {
+		return false
+	}
+
+	hasExpiry := !e.expiredAt.IsZero()
+	switch cond {
+	case expireIfNoExpiry:
+		if hasExpiry {
+			return false
+		}
+	case expireIfHasExpiry:
+		if !hasExpiry {
+			return false
+		}
+	case expireIfGreater:
+		if !hasExpiry || !at.After(e.expiredAt) {
+			return false
+		}
+	case expireIfLess:
+		if hasExpiry && !at.Before(e.expiredAt) {
+			return false
+		}
+	}
+
+	if !at.After(time.Now()) {
+		s.del(key)
+		return true
+	}
+
+	e.expiredAt = at
+	s.put(key, e)
+	return true
+}
+
+// persist removes the expiry of key and reports whether it had one.
+func (s *store) persist(key string) bool {
+	e, ok := s.lookup(key)
+	if !ok || e.expiredAt.IsZero() {
+		return false
+	}
+
+	e.expiredAt = time.Time{}
+	s.put(key, e)
+	return true
+}
+
+// activeExpireCycle deletes expired keys that haven't been read since they
+// expired, and returns how many were deleted. Like Redis, it repeatedly
+// samples a few keys that have an expiry and keeps going as long as a large
+// part of the sample turns out to be expired.
+//
+// https://redis.io/docs/latest/commands/expire/#how-redis-expires-keys
+func (s *store) activeExpireCycle() int {
+	start := time.Now()
+	numExpired := 0
+
+	for {
+		now := time.Now()
+		sampled, expired := 0, 0
+		// Map iteration starts at a random position, which is a cheap way to
+		// pick a random sample of keys.
+		for key := range s.volatileKeys {
+			if sampled == activeExpireKeysPerLoop {
+				break
+			}
+			sampled++
+
+			if s.mp[key].isExpired(now) {
+				s.del(key)
+				expired++
+			}
+		}
+		numExpired += expired
+
+		// Stop once at most 25% of the sampled keys were expired, since the
+		// rest of the keyspace probably has few expired keys too.
+		if expired*4 <= sampled || time.Since(start) > activeExpireCycleTimeLimit {
+			return numExpired
+		}
+	}
+}
+
+// expireCmd handles EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+//
+// EXPIRE key seconds [NX | XX | GT | LT]
+//
+// https://redis.io/docs/latest/commands/expire/
+func (ex *executor) expireCmd(cmd command) []byte {
+	if len(cmd.args) != 2 && len(cmd.args) != 3 {
+		return resp.SerializeSimpleError(fmt.Sprintf(
+			"invalid number of arguments: expect 2 or 3, got %d", len(cmd.args)))
+	}
+
+	key := string(cmd.args[0])
+	n, err := strconv.ParseInt(string(cmd.args[1]), 10, 64)
+	if err != nil {
+		return resp.SerializeSimpleError(fmt.Sprintf("convert expire time to int: %v", err))
+	}
+
+	opt := map[string]string{
+		"EXPIRE":    "EX",
+		"PEXPIRE":   "PX",
+		"EXPIREAT":  "EXAT",
+		"PEXPIREAT": "PXAT",
+	}[cmd.name]
+	at, err := expireTime(n, opt)
+	if err != nil {
+		return resp.SerializeSimpleError(fmt.Sprintf(
+			"invalid expire time in '%s' command", strings.ToLower(cmd.name)))
+	}
+
+	cond := expireAlways
+	if len(cmd.args) == 3 {
+		switch strings.ToUpper(string(cmd.args[2])) {
+		case "NX":
+			cond = expireIfNoExpiry
+		case "XX":
+			cond = expireIfHasExpiry
+		case "GT":
+			cond = expireIfGreater
+		case "LT":
+			cond = expireIfLess
+		default:
+			return resp.SerializeSimpleError(fmt.Sprintf("unsupported option %s", cmd.args[2]))
+		}
+	}
+
+	if !ex.store.expire(key, at, cond) {
+		return resp.SerializeInteger(0)
+	}
+	ex.propagate("PEXPIREAT", [][]byte{cmd.args[0], strconv.AppendInt(nil, at.UnixMilli(), 10)})
+
+	return resp.SerializeInteger(1)
+}
+
+// ttlCmd handles TTL and PTTL. The reply is -2 if the key doesn't exist and
+// -1 if it exists but has no expiry.
+func (ex *executor) ttlCmd(cmd command) []byte {
+	if len(cmd.args) != 1 {
+		return resp.SerializeSimpleError(fmt.Sprintf(
+			"invalid number of arguments: expect 1, got %d", len(cmd.args)))
+	}
+
+	e, ok := ex.store.lookup(string(cmd.args[0]))
+	if !ok {
+		return resp.SerializeInteger(-2)
+	}
+	if e.expiredAt.IsZero() {
+		return resp.SerializeInteger(-1)
+	}
+
+	remainingMillis := max(time.Until(e.expiredAt).Milliseconds(), 0)
+	if cmd.name == "PTTL" {
+		return resp.SerializeInteger(int(remainingMillis))
+	}
+	return resp.SerializeInteger(int((remainingMillis + 500) / 1000))
+}
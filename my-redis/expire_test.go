@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := newStore()
+
+	expiredAt := time.Now().Add(-time.Second)
+	for i := range 1000 {
+		s.set("expired:"+strconv.Itoa(i), []byte("val"), expiredAt)
+	}
+	s.set("volatile", []byte("val"), time.Now().Add(time.Hour))
+	s.set("persistent", []byte("val"), time.Time{})
+
+	// Nearly every sampled key is expired, so a single cycle keeps sampling
+	// until all of them are gone.
+	require.Equal(t, 1000, s.activeExpireCycle())
+	require.Len(t, s.mp, 2)
+	require.Equal(t, map[string]struct{}{"volatile": {}}, s.volatileKeys)
+}
+
+func TestActiveExpireCycleStopsWhenFewKeysAreExpired(t *testing.T) {
+	s := newStore()
+
+	for i := range 1000 {
+		s.set("volatile:"+strconv.Itoa(i), []byte("val"), time.Now().Add(time.Hour))
+	}
+	s.set("expired", []byte("val"), time.Now().Add(-time.Second))
+
+	// At most one sampled key is expired, which is below the threshold for
+	// sampling again.
+	require.LessOrEqual(t, s.activeExpireCycle(), 1)
+	require.GreaterOrEqual(t, len(s.mp), 1000)
+}
+
+func TestExpireTime(t *testing.T) {
+	now := time.Now()
+
+	at, err := expireTime(10, "EX")
+	require.NoError(t, err)
+	require.WithinDuration(t, now.Add(10*time.Second), at, time.Second)
+
+	at, err = expireTime(1700000000000, "PXAT")
+	require.NoError(t, err)
+	require.Equal(t, time.UnixMilli(1700000000000), at)
+
+	_, err = expireTime(1<<62, "EX")
+	require.Error(t, err)
+}
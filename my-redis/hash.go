@@ -0,0 +1,155 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// hash maps fields to values.
+//
+// https://redis.io/docs/latest/develop/data-types/hashes/
+type hash map[string][]byte
+
+func (h hash) clone() any {
+	return maps.Clone(h)
+}
+
+// hset sets the given field-value pairs and returns the number of fields that
+// were newly added.
+func (s *store) hset(key string, fieldVals [][]byte) (int, error) {
+	h, exists, err := lookupTyped[hash](s, key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		h = hash{}
+		s.put(key, entry{val: h})
+	}
+
+	numAdded := 0
+	for i := 0; i < len(fieldVals); i += 2 {
+		field := string(fieldVals[i])
+		if _, ok := h[field]; !ok {
+			numAdded++
+		}
+		h[field] = fieldVals[i+1]
+	}
+	s.touch(key)
+
+	return numAdded, nil
+}
+
+func (s *store) hget(key string, field string) ([]byte, error) {
+	h, _, err := lookupTyped[hash](s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return h[field], nil
+}
+
+// hgetall returns every field followed by its value, ordered by field.
+func (s *store) hgetall(key string) ([][]byte, error) {
+	h, _, err := lookupTyped[hash](s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	retval := make([][]byte, 0, 2*len(h))
+	for _, field := range slices.Sorted(maps.Keys(h)) {
+		retval = append(retval, []byte(field), h[field])
+	}
+
+	return retval, nil
+}
+
+// hdel removes the given fields and returns how many of them existed. The key
+// is deleted once the hash is empty.
+func (s *store) hdel(key string, fields [][]byte) (int, error) {
+	h, exists, err := lookupTyped[hash](s, key)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	numDeleted := 0
+	for _, field := range fields {
+		if _, ok := h[string(field)]; ok {
+			delete(h, string(field))
+			numDeleted++
+		}
+	}
+
+	if len(h) == 0 {
+		s.del(key)
+	} else if numDeleted > 0 {
+		s.touch(key)
+	}
+
+	return numDeleted, nil
+}
+
+// hsetCmd handles HSET key field value [field value ...].
+func (ex *executor) hsetCmd(cmd command) []byte {
+	if len(cmd.args) < 3 || len(cmd.args)%2 != 1 {
+		return wrongNumArgs("key followed by field-value pairs", len(cmd.args))
+	}
+
+	numAdded, err := ex.store.hset(string(cmd.args[0]), cmd.args[1:])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	ex.propagate(cmd.name, cmd.args)
+
+	return resp.SerializeInteger(numAdded)
+}
+
+// hgetCmd handles HGET key field.
+func (ex *executor) hgetCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	val, err := ex.store.hget(string(cmd.args[0]), string(cmd.args[1]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return resp.SerializeBulkString(val)
+}
+
+// hgetallCmd handles HGETALL key.
+func (ex *executor) hgetallCmd(cmd command) []byte {
+	if len(cmd.args) != 1 {
+		return wrongNumArgs("1", len(cmd.args))
+	}
+
+	fieldVals, err := ex.store.hgetall(string(cmd.args[0]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	kv := make([][]byte, 0, len(fieldVals))
+	for _, b := range fieldVals {
+		kv = append(kv, resp.SerializeBulkString(b))
+	}
+	return mapReply(cmd.client, kv)
+}
+
+// hdelCmd handles HDEL key field [field ...].
+func (ex *executor) hdelCmd(cmd command) []byte {
+	if len(cmd.args) < 2 {
+		return wrongNumArgs("at least 2", len(cmd.args))
+	}
+
+	numDeleted, err := ex.store.hdel(string(cmd.args[0]), cmd.args[1:])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if numDeleted > 0 {
+		ex.propagate(cmd.name, cmd.args)
+	}
+
+	return resp.SerializeInteger(numDeleted)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// redisVersion is the version of Redis whose behavior the server follows. It's
+// reported to clients, which may enable features based on it.
+const redisVersion = "7.4.0"
+
+// helloCmd handles HELLO [protover [AUTH username password] [SETNAME
+// clientname]], which switches the protocol of the connection and returns
+// information about the server.
+//
+// https://redis.io/docs/latest/commands/hello/
+func (ex *executor) helloCmd(cmd command) []byte {
+	c := cmd.client
+
+	protocol := c.protocol
+	if len(cmd.args) > 0 {
+		var err error
+		protocol, err = strconv.Atoi(string(cmd.args[0]))
+		if err != nil {
+			return resp.SerializeSimpleError("Protocol version is not an integer or out of range")
+		}
+		if protocol != 2 && protocol != 3 {
+			return resp.SerializeSimpleError("NOPROTO unsupported protocol version")
+		}
+	}
+
+	var name []byte
+	hasName := false
+	for cur := 1; cur < len(cmd.args); cur++ {
+		switch strings.ToUpper(string(cmd.args[cur])) {
+		case "AUTH":
+			// There is no authentication, so any credentials are accepted.
+			if cur+2 >= len(cmd.args) {
+				return resp.SerializeSimpleError("syntax error in HELLO option 'auth'")
+			}
+			cur += 2
+		case "SETNAME":
+			if cur+1 >= len(cmd.args) {
+				return resp.SerializeSimpleError("syntax error in HELLO option 'setname'")
+			}
+			name, hasName = cmd.args[cur+1], true
+			cur++
+		default:
+			return resp.SerializeSimpleError("syntax error in HELLO option '" + string(cmd.args[cur]) + "'")
+		}
+	}
+
+	if hasName && !validClientName(name) {
+		return resp.SerializeSimpleError(errInvalidClientName.Error())
+	}
+
+	c.protocol = protocol
+	if hasName {
+		c.name = string(name)
+	}
+
+	role := "master"
+	if ex.link != nil {
+		role = "replica"
+	}
+
+	return mapReply(c, [][]byte{
+		resp.SerializeBulkString([]byte("server")), resp.SerializeBulkString([]byte("redis")),
+		resp.SerializeBulkString([]byte("version")), resp.SerializeBulkString([]byte(redisVersion)),
+		resp.SerializeBulkString([]byte("proto")), resp.SerializeInteger(protocol),
+		resp.SerializeBulkString([]byte("id")), resp.SerializeInteger(int(c.id)),
+		resp.SerializeBulkString([]byte("mode")), resp.SerializeBulkString([]byte("standalone")),
+		resp.SerializeBulkString([]byte("role")), resp.SerializeBulkString([]byte(role)),
+		resp.SerializeBulkString([]byte("modules")), resp.SerializeArray(nil),
+	})
+}
+
+// The helpers below encode the replies whose type depends on the protocol
+// that the client negotiated with HELLO. RESP2 clients get the closest RESP2
+// type instead.
+
+// mapReply returns a map of the given RESP-encoded keys and values, or a flat
+// array of them for RESP2 clients.
+func mapReply(c *client, kv [][]byte) []byte {
+	if c.protocol == 3 {
+		return resp.SerializeMap(kv)
+	}
+	return resp.SerializeRawArray(kv)
+}
+
+// setReply returns a set of the given members, or an array for RESP2 clients.
+func setReply(c *client, members [][]byte) []byte {
+	if c.protocol == 3 {
+		return resp.SerializeSet(members)
+	}
+	return resp.SerializeArray(members)
+}
+
+// pushReply returns a push frame of the given RESP-encoded elements, or an
+// array for RESP2 clients.
+func pushReply(c *client, elems [][]byte) []byte {
+	if c.protocol == 3 {
+		return resp.SerializePush(elems)
+	}
+	return resp.SerializeRawArray(elems)
+}
+
+// verbatimReply returns a plain text verbatim string, or a bulk string for
+// RESP2 clients.
+func verbatimReply(c *client, s string) []byte {
+	if c.protocol == 3 {
+		return resp.SerializeVerbatimString("txt", s)
+	}
+	return resp.SerializeBulkString([]byte(s))
+}
+
+// adaptNull replaces the RESP2 nulls of reply with the RESP3 null for RESP3
+// clients, including the ones nested in arrays. Handlers reply with RESP2
+// nulls, so that they don't need to check the protocol of the client.
+func adaptNull(c *client, reply []byte) []byte {
+	if c.protocol == 3 {
+		return resp.ReplaceNulls(reply)
+	}
+	return reply
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHello(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	c := newClient()
+
+	require.Equal(t, "-NOPROTO unsupported protocol version\r\n", string(executeAs(ex, c, "HELLO", "4")))
+
+	res := string(executeAs(ex, c, "HELLO", "3", "SETNAME", "worker"))
+	require.Regexp(t, `(?s)^%7\r\n\$6\r\nserver\r\n\$5\r\nredis\r\n.*\$5\r\nproto\r\n:3\r\n`, res)
+	require.Equal(t, "worker", c.name)
+
+	// Without arguments, HELLO keeps the current protocol.
+	require.Regexp(t, `^%7\r\n`, string(executeAs(ex, c, "HELLO")))
+
+	res = string(executeAs(ex, c, "HELLO", "2"))
+	require.Regexp(t, `^\*14\r\n`, res)
+}
+
+func TestRESP3Replies(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	c := newClient()
+	executeAs(ex, c, "HELLO", "3")
+
+	require.Equal(t, "_\r\n", string(executeAs(ex, c, "GET", "missing")))
+	require.Equal(t, "_\r\n", string(executeAs(ex, c, "LPOP", "missing", "2")))
+
+	executeAs(ex, c, "HSET", "hash", "field", "value")
+	require.Equal(t, "%1\r\n$5\r\nfield\r\n$5\r\nvalue\r\n", string(executeAs(ex, c, "HGETALL", "hash")))
+
+	executeAs(ex, c, "SADD", "set", "a")
+	require.Equal(t, "~1\r\n$1\r\na\r\n", string(executeAs(ex, c, "SMEMBERS", "set")))
+
+	executeAs(ex, c, "ZADD", "zset", "1.5", "a")
+	require.Equal(t, "*1\r\n*2\r\n$1\r\na\r\n,1.5\r\n",
+		string(executeAs(ex, c, "ZRANGE", "zset", "0", "-1", "WITHSCORES")))
+
+	// Nulls nested in a transaction reply are converted too.
+	executeAs(ex, c, "MULTI")
+	executeAs(ex, c, "GET", "missing")
+	require.Equal(t, "*1\r\n_\r\n", string(executeAs(ex, c, "EXEC")))
+
+	// So are the ones of blocking commands, which reply with a null array or
+	// a null bulk string inside a transaction.
+	executeAs(ex, c, "SET", "present", "value")
+	executeAs(ex, c, "MULTI")
+	executeAs(ex, c, "GET", "present")
+	executeAs(ex, c, "BLPOP", "missing", "0")
+	executeAs(ex, c, "BLMOVE", "missing", "other", "LEFT", "RIGHT", "0")
+	require.Equal(t, "*3\r\n$5\r\nvalue\r\n_\r\n_\r\n", string(executeAs(ex, c, "EXEC")))
+
+	// Subscription confirmations are push frames, and any command can run
+	// while subscribed.
+	require.Equal(t, ">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", string(executeAs(ex, c, "SUBSCRIBE", "news")))
+	require.Equal(t, "_\r\n", string(executeAs(ex, c, "GET", "missing")))
+	require.Equal(t, "+PONG\r\n", string(executeAs(ex, c, "PING")))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// serverCronInterval is how often the executor samples its statistics and
+	// closes idle clients.
+	serverCronInterval = 100 * time.Millisecond
+	// opsSamples is the number of samples averaged to compute the number of
+	// commands per second, which covers the last 1.6s like Redis.
+	opsSamples = 16
+)
+
+// opsTracker computes the instantaneous number of commands per second from
+// periodic samples of the number of processed commands.
+type opsTracker struct {
+	samples     [opsSamples]float64
+	next        int
+	lastTime    time.Time
+	lastCommand int64
+}
+
+// sample records that numCommands were processed in total at now.
+func (t *opsTracker) sample(now time.Time, numCommands int64) {
+	if !t.lastTime.IsZero() {
+		elapsed := now.Sub(t.lastTime).Seconds()
+		if elapsed > 0 {
+			t.samples[t.next] = float64(numCommands-t.lastCommand) / elapsed
+			t.next = (t.next + 1) % opsSamples
+		}
+	}
+
+	t.lastTime = now
+	t.lastCommand = numCommands
+}
+
+// perSec returns the average of the samples.
+func (t *opsTracker) perSec() int {
+	var sum float64
+	for _, s := range t.samples {
+		sum += s
+	}
+	return int(sum / opsSamples)
+}
+
+// The memory usage of the dataset is estimated from the size of keys and
+// values, plus a fixed overhead for each key and collection element that
+// accounts for map buckets, slice headers and skip list nodes.
+const (
+	keyOverhead  = 64
+	elemOverhead = 16
+)
+
+// memoryUsage returns an estimate of the number of bytes used by the dataset.
+// It walks the whole keyspace, which is fine for INFO but shouldn't be done
+// for every command.
+func (s *store) memoryUsage() int {
+	retval := 0
+	for key, e := range s.mp {
+		retval += keyOverhead + len(key) + valueSize(e.val)
+	}
+	return retval
+}
+
+func valueSize(val any) int {
+	retval := 0
+	switch val := val.(type) {
+	case []byte:
+		retval = len(val)
+	case [][]byte:
+		for _, elem := range val {
+			retval += elemOverhead + len(elem)
+		}
+	case set:
+		for member := range val {
+			retval += elemOverhead + len(member)
+		}
+	case hash:
+		for field, fieldVal := range val {
+			retval += elemOverhead + len(field) + len(fieldVal)
+		}
+	case *sortedSet:
+		// Members are stored in both the score map and the skip list.
+		for member := range val.scores {
+			retval += 4*elemOverhead + len(member)
+		}
+	}
+	return retval
+}
+
+// infoSections lists the sections of INFO in the order they're reported.
+var infoSections = []struct {
+	name  string
+	write func(ex *executor, sb *strings.Builder)
+}{
+	{"server", (*executor).writeServerInfo},
+	{"clients", (*executor).writeClientsInfo},
+	{"memory", (*executor).writeMemoryInfo},
+	{"stats", (*executor).writeStatsInfo},
+	{"replication", (*executor).writeReplicationInfo},
+	{"keyspace", (*executor).writeKeyspaceInfo},
+}
+
+// infoCmd handles INFO [section [section ...]]. Every section is reported
+// when none is given, or when "all", "everything" or "default" is given.
+//
+// https://redis.io/docs/latest/commands/info/
+func (ex *executor) infoCmd(cmd command) []byte {
+	all := len(cmd.args) == 0
+	requested := make(map[string]bool)
+	for _, arg := range cmd.args {
+		name := strings.ToLower(string(arg))
+		switch name {
+		case "all", "everything", "default":
+			all = true
+		default:
+			requested[name] = true
+		}
+	}
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !requested[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		fmt.Fprintf(&sb, "# %s%s\r\n", strings.ToUpper(section.name[:1]), section.name[1:])
+		section.write(ex, &sb)
+	}
+
+	return verbatimReply(cmd.client, sb.String())
+}
+
+func (ex *executor) writeServerInfo(sb *strings.Builder) {
+	uptime := time.Since(ex.startTime)
+	fmt.Fprintf(sb, "redis_version:%s\r\n", redisVersion)
+	sb.WriteString("redis_mode:standalone\r\n")
+	fmt.Fprintf(sb, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(sb, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(sb, "tcp_port:%d\r\n", ex.cfg.port)
+	fmt.Fprintf(sb, "uptime_in_seconds:%d\r\n", int(uptime.Seconds()))
+	fmt.Fprintf(sb, "uptime_in_days:%d\r\n", int(uptime.Hours()/24))
+}
+
+func (ex *executor) writeClientsInfo(sb *strings.Builder) {
+	numBlocked, numSubscribers := 0, 0
+	for c := range ex.clients {
+		if c.blocked != nil {
+			numBlocked++
+		}
+		if c.subscribed() {
+			numSubscribers++
+		}
+	}
+
+	fmt.Fprintf(sb, "connected_clients:%d\r\n", len(ex.clients))
+	fmt.Fprintf(sb, "maxclients:%d\r\n", ex.cfg.maxClients)
+	fmt.Fprintf(sb, "blocked_clients:%d\r\n", numBlocked)
+	fmt.Fprintf(sb, "pubsub_clients:%d\r\n", numSubscribers)
+}
+
+func (ex *executor) writeMemoryInfo(sb *strings.Builder) {
+	usedMemory := ex.store.memoryUsage()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fmt.Fprintf(sb, "used_memory:%d\r\n", usedMemory)
+	fmt.Fprintf(sb, "used_memory_human:%s\r\n", humanBytes(usedMemory))
+	fmt.Fprintf(sb, "used_memory_heap:%d\r\n", ms.HeapAlloc)
+	fmt.Fprintf(sb, "used_memory_sys:%d\r\n", ms.Sys)
+}
+
+func (ex *executor) writeStatsInfo(sb *strings.Builder) {
+	fmt.Fprintf(sb, "total_connections_received:%d\r\n", ex.numConns)
+	fmt.Fprintf(sb, "total_commands_processed:%d\r\n", ex.numCommands)
+	fmt.Fprintf(sb, "instantaneous_ops_per_sec:%d\r\n", ex.ops.perSec())
+	fmt.Fprintf(sb, "rejected_connections:%d\r\n", ex.numRejectedConns)
+	fmt.Fprintf(sb, "executor_queue_depth:%d\r\n", len(ex.queue))
+	fmt.Fprintf(sb, "sync_full:%d\r\n", ex.numFullSyncs)
+	fmt.Fprintf(sb, "sync_partial_ok:%d\r\n", ex.numPartialSyncs)
+	fmt.Fprintf(sb, "pubsub_channels:%d\r\n", len(ex.channels))
+	fmt.Fprintf(sb, "pubsub_patterns:%d\r\n", len(ex.patterns))
+}
+
+func (ex *executor) writeReplicationInfo(sb *strings.Builder) {
+	if ex.link != nil {
+		host, port, _ := net.SplitHostPort(ex.link.addr)
+		linkStatus := "down"
+		if ex.link.state.Load().(string) == "connected" {
+			linkStatus = "up"
+		}
+
+		sb.WriteString("role:slave\r\n")
+		fmt.Fprintf(sb, "master_host:%s\r\n", host)
+		fmt.Fprintf(sb, "master_port:%s\r\n", port)
+		fmt.Fprintf(sb, "master_link_status:%s\r\n", linkStatus)
+		fmt.Fprintf(sb, "slave_repl_offset:%d\r\n", ex.link.offset.Load())
+		sb.WriteString("slave_read_only:1\r\n")
+	} else {
+		sb.WriteString("role:master\r\n")
+	}
+
+	replicas := slices.SortedFunc(maps.Keys(ex.replicas), func(a, b *client) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(replicas))
+	for i, c := range replicas {
+		r := ex.replicas[c]
+		host, port := replicaAddr(c, r)
+		state := "wait_bgsave"
+		if r.online {
+			state = "online"
+		}
+		fmt.Fprintf(sb, "slave%d:ip=%s,port=%s,state=%s,offset=%d\r\n", i, host, port, state, r.ackOffset)
+	}
+
+	fmt.Fprintf(sb, "master_replid:%s\r\n", ex.replID)
+	fmt.Fprintf(sb, "master_repl_offset:%d\r\n", ex.replOffset)
+}
+
+func (ex *executor) writeKeyspaceInfo(sb *strings.Builder) {
+	if len(ex.store.mp) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", len(ex.store.mp), len(ex.store.volatileKeys))
+}
+
+// humanBytes formats n like Redis's used_memory_human, e.g. 1.50M.
+func humanBytes(n int) string {
+	const units = "KMGTP"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	size := float64(n) / 1024
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%c", size, units[i])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	ex := newExecutor(newStore(), config{maxClients: 10})
+	execute(ex, "SET", "foo", "bar")
+	execute(ex, "SET", "temp", "value", "EX", "100")
+	execute(ex, "RPUSH", "list", "a", "b")
+
+	res := string(execute(ex, "INFO"))
+	for _, section := range []string{"Server", "Clients", "Memory", "Stats", "Replication", "Keyspace"} {
+		require.Contains(t, res, "# "+section+"\r\n")
+	}
+	require.Contains(t, res, "maxclients:10\r\n")
+	require.Contains(t, res, "role:master\r\n")
+	require.Contains(t, res, "db0:keys=3,expires=1,avg_ttl=0\r\n")
+	require.Regexp(t, `total_commands_processed:4\r\n`, res)
+	require.Regexp(t, `executor_queue_depth:\d+\r\n`, res)
+
+	res = string(execute(ex, "INFO", "keyspace", "MEMORY"))
+	require.Regexp(t, `(?s)^\$\d+\r\n# Memory\r\nused_memory:\d+\r\n.*\r\n\r\n# Keyspace\r\ndb0:`, res)
+	require.NotContains(t, res, "# Server")
+}
+
+func TestMemoryUsage(t *testing.T) {
+	s := newStore()
+	require.Equal(t, 0, s.memoryUsage())
+
+	s.put("key", entry{val: []byte("value")})
+	small := s.memoryUsage()
+	require.Equal(t, keyOverhead+len("key")+len("value"), small)
+
+	s.put("list", entry{val: [][]byte{[]byte("a"), []byte("b")}})
+	require.Equal(t, small+keyOverhead+len("list")+2*(elemOverhead+1), s.memoryUsage())
+}
+
+func TestOpsTracker(t *testing.T) {
+	var ops opsTracker
+	now := time.Now()
+	ops.sample(now, 0)
+	for i := 1; i <= opsSamples; i++ {
+		ops.sample(now.Add(time.Duration(i)*serverCronInterval), int64(i*50))
+	}
+
+	// 50 commands every 100ms.
+	require.Equal(t, 500, ops.perSec())
+}
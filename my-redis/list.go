@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// listEnd is one of the two ends of a list.
+type listEnd int
+
+const (
+	listLeft listEnd = iota
+	listRight
+)
+
+func parseListEnd(b []byte) (listEnd, error) {
+	switch strings.ToUpper(string(b)) {
+	case "LEFT":
+		return listLeft, nil
+	case "RIGHT":
+		return listRight, nil
+	default:
+		return 0, errors.New("syntax error: expect LEFT or RIGHT")
+	}
+}
+
+// Lists are never modified in place, so that snapshots can share them with
+// the store. Every write creates a new slice or reslices the existing one.
+
+// lpush inserts the given elements at the head of the list stored at key one
+// after the other, so the last element ends up first.
+func (s *store) lpush(key string, newElems [][]byte) (int, error) {
+	e, exists := s.lookup(key)
+
+	var list [][]byte
+	if exists {
+		var ok bool
+		list, ok = e.val.([][]byte)
+		if !ok {
+			return 0, errWrongType
+		}
+	}
+
+	head := slices.Clone(newElems)
+	slices.Reverse(head)
+	list = slices.Concat(head, list)
+	s.put(key, entry{
+		val:       list,
+		expiredAt: e.expiredAt,
+	})
+
+	return len(list), nil
+}
+
+// pop removes and returns up to count elements from the given end of the list
+// stored at key. The key is deleted once the list is empty.
+func (s *store) pop(key string, end listEnd, count int) ([][]byte, error) {
+	e, exists := s.lookup(key)
+	if !exists {
+		return nil, nil
+	}
+
+	list, ok := e.val.([][]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+
+	if count == 0 {
+		return [][]byte{}, nil
+	}
+
+	count = min(count, len(list))
+	var popped [][]byte
+	if end == listLeft {
+		popped = list[:count]
+		list = list[count:]
+	} else {
+		popped = slices.Clone(list[len(list)-count:])
+		slices.Reverse(popped)
+		list = list[:len(list)-count]
+	}
+
+	if len(list) == 0 {
+		s.del(key)
+	} else {
+		s.put(key, entry{val: list, expiredAt: e.expiredAt})
+	}
+
+	return popped, nil
+}
+
+func (s *store) llen(key string) (int, error) {
+	list, _, err := lookupTyped[[][]byte](s, key)
+	return len(list), err
+}
+
+// lindex returns the element at index, where negative indexes count from the
+// tail of the list. It returns nil if index is out of range.
+func (s *store) lindex(key string, index int) ([]byte, error) {
+	list, _, err := lookupTyped[[][]byte](s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 {
+		index += len(list)
+	}
+	if index < 0 || index >= len(list) {
+		return nil, nil
+	}
+
+	return list[index], nil
+}
+
+// lmove pops an element from the given end of the list at src and pushes it
+// to the given end of the list at dst. It returns nil if src doesn't exist.
+func (s *store) lmove(src, dst string, from, to listEnd) ([]byte, error) {
+	_, srcExists, err := lookupTyped[[][]byte](s, src)
+	if err != nil || !srcExists {
+		return nil, err
+	}
+	// Check the destination before popping, so that a WRONGTYPE error doesn't
+	// lose the element.
+	_, _, err = lookupTyped[[][]byte](s, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	popped, err := s.pop(src, from, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == listLeft {
+		_, err = s.lpush(dst, popped)
+	} else {
+		_, err = s.rpush(dst, popped)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return popped[0], nil
+}
+
+// lpushCmd handles LPUSH key element [element ...].
+func (ex *executor) lpushCmd(cmd command) []byte {
+	if len(cmd.args) < 2 {
+		return wrongNumArgs("at least 2", len(cmd.args))
+	}
+
+	key := string(cmd.args[0])
+	length, err := ex.store.lpush(key, cmd.args[1:])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	ex.propagate(cmd.name, cmd.args)
+	ex.signalKeyAsReady(key)
+
+	return resp.SerializeInteger(length)
+}
+
+// popCmd handles LPOP key [count] and RPOP key [count].
+func (ex *executor) popCmd(cmd command) []byte {
+	if len(cmd.args) != 1 && len(cmd.args) != 2 {
+		return wrongNumArgs("1 or 2", len(cmd.args))
+	}
+
+	end := listLeft
+	if cmd.name == "RPOP" {
+		end = listRight
+	}
+
+	key := string(cmd.args[0])
+	count := 1
+	hasCount := len(cmd.args) == 2
+	if hasCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd.args[1]))
+		if err != nil || count < 0 {
+			return resp.SerializeSimpleError("value is out of range, must be positive")
+		}
+	}
+
+	popped, err := ex.store.pop(key, end, count)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if len(popped) > 0 {
+		ex.propagate(cmd.name, [][]byte{cmd.args[0], strconv.AppendInt(nil, int64(len(popped)), 10)})
+	}
+
+	if hasCount {
+		if popped == nil {
+			return resp.NullArray
+		}
+		return resp.SerializeArray(popped)
+	}
+	if len(popped) == 0 {
+		return resp.NullBulkString
+	}
+	return resp.SerializeBulkString(popped[0])
+}
+
+// llenCmd handles LLEN key.
+func (ex *executor) llenCmd(cmd command) []byte {
+	if len(cmd.args) != 1 {
+		return wrongNumArgs("1", len(cmd.args))
+	}
+
+	length, err := ex.store.llen(string(cmd.args[0]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return resp.SerializeInteger(length)
+}
+
+// lindexCmd handles LINDEX key index.
+func (ex *executor) lindexCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	index, err := strconv.Atoi(string(cmd.args[1]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	elem, err := ex.store.lindex(string(cmd.args[0]), index)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return resp.SerializeBulkString(elem)
+}
+
+// lmoveCmd handles LMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT>.
+func (ex *executor) lmoveCmd(cmd command) []byte {
+	if len(cmd.args) != 4 {
+		return wrongNumArgs("4", len(cmd.args))
+	}
+
+	elem, err := ex.lmove(cmd.args)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return resp.SerializeBulkString(elem)
+}
+
+// lmove runs LMOVE with the given arguments, which BLMOVE shares.
+func (ex *executor) lmove(args [][]byte) ([]byte, error) {
+	from, err := parseListEnd(args[2])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseListEnd(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	src, dst := string(args[0]), string(args[1])
+	elem, err := ex.store.lmove(src, dst, from, to)
+	if err != nil || elem == nil {
+		return nil, err
+	}
+	ex.propagate("LMOVE", args[:4])
+	ex.signalKeyAsReady(dst)
+
+	return elem, nil
+}
+
+// blockingPopCmd handles BLPOP key [key ...] timeout and BRPOP key [key ...]
+// timeout. It pops from the first non-empty list, or blocks the client until
+// one of the lists receives an element.
+//
+// https://redis.io/docs/latest/commands/blpop/
+func (ex *executor) blockingPopCmd(cmd command) []byte {
+	if len(cmd.args) < 2 {
+		return wrongNumArgs("at least 2", len(cmd.args))
+	}
+
+	timeout, err := parseBlockingTimeout(cmd.args[len(cmd.args)-1])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	end := listLeft
+	popName := "LPOP"
+	if cmd.name == "BRPOP" {
+		end = listRight
+		popName = "RPOP"
+	}
+
+	keys := make([]string, 0, len(cmd.args)-1)
+	for _, keyBytes := range cmd.args[:len(cmd.args)-1] {
+		key := string(keyBytes)
+		keys = append(keys, key)
+
+		popped, err := ex.store.pop(key, end, 1)
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		if len(popped) > 0 {
+			ex.propagate(popName, [][]byte{keyBytes})
+			return resp.SerializeArray([][]byte{keyBytes, popped[0]})
+		}
+	}
+
+	return ex.block(cmd, keys, timeout, resp.NullArray)
+}
+
+// blmoveCmd handles BLMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT>
+// timeout, the blocking variant of LMOVE.
+func (ex *executor) blmoveCmd(cmd command) []byte {
+	if len(cmd.args) != 5 {
+		return wrongNumArgs("5", len(cmd.args))
+	}
+
+	timeout, err := parseBlockingTimeout(cmd.args[4])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	elem, err := ex.lmove(cmd.args)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if elem != nil {
+		return resp.SerializeBulkString(elem)
+	}
+
+	return ex.block(cmd, []string{string(cmd.args[0])}, timeout, resp.NullBulkString)
+}
@@ -2,38 +2,113 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"os"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/tuananhlai/prototypes/my-redis/resp"
 )
 
 func main() {
-	l, err := net.Listen("tcp", "0.0.0.0:6379")
+	var cfg config
+	var appendFsync string
+	flag.BoolVar(&cfg.appendOnly, "appendonly", false, "persist write commands to an append-only file")
+	flag.StringVar(&cfg.appendFilename, "appendfilename", "appendonly.aof", "path of the append-only file")
+	flag.StringVar(&appendFsync, "appendfsync", "everysec", "fsync policy for the append-only file: always, everysec or no")
+	flag.StringVar(&cfg.dbFilename, "dbfilename", "dump.rdb", "path of the snapshot file written by SAVE and BGSAVE")
+	flag.IntVar(&cfg.port, "port", 6379, "port to accept connections on")
+	flag.StringVar(&cfg.replicaOf, "replicaof", "", `address of the primary to replicate from, as "host port"`)
+	flag.IntVar(&cfg.maxClients, "maxclients", 10000, "maximum number of connected clients, 0 for no limit")
+	flag.DurationVar(&cfg.timeout, "timeout", 0, "close connections idle for longer than this, 0 to disable")
+	flag.Parse()
+
+	var err error
+	cfg.appendFsync, err = parseFsyncPolicy(appendFsync)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to bind to port 6379")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to bind to port %d\n", cfg.port)
 		os.Exit(1)
 	}
 	defer l.Close()
 
-	fmt.Println("Start server on port 6379")
-	err = run(l)
+	fmt.Printf("Start server on port %d\n", cfg.port)
+	err = run(l, cfg)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func run(l net.Listener) error {
-	executor := newExecutor(newStore())
+type config struct {
+	appendOnly     bool
+	appendFilename string
+	appendFsync    fsyncPolicy
+	dbFilename     string
+	// port is only used to tell the primary where a replica accepts
+	// connections, since the listener is created by the caller of run.
+	port int
+	// replicaOf is the "host port" address of the primary to replicate from.
+	// The server is a primary when it's empty.
+	replicaOf string
+	// maxClients is the maximum number of connected clients. There's no
+	// limit when it's zero.
+	maxClients int
+	// timeout is how long a client can stay idle before its connection is
+	// closed. Idle clients are kept when it's zero.
+	timeout time.Duration
+}
+
+func run(l net.Listener, cfg config) error {
+	db := newStore()
+	// Like Redis, the AOF file takes precedence over the snapshot when both
+	// are available, since it's usually more up to date.
+	if !cfg.appendOnly {
+		mp, err := loadRDB(cfg.dbFilename)
+		if err != nil {
+			return fmt.Errorf("loading rdb: %v", err)
+		}
+		db.load(mp)
+	}
+
+	executor := newExecutor(db, cfg)
+	if cfg.appendOnly {
+		err := executor.enableAOF(cfg.appendFilename, cfg.appendFsync)
+		if err != nil {
+			return fmt.Errorf("enabling aof: %v", err)
+		}
+	}
+
+	if cfg.replicaOf != "" {
+		host, port, ok := strings.Cut(cfg.replicaOf, " ")
+		if !ok {
+			return fmt.Errorf("invalid replicaof address %q: expect \"host port\"", cfg.replicaOf)
+		}
+
+		res := executor.execute(newClient(), [][]byte{[]byte("REPLICAOF"), []byte(host), []byte(port)})
+		if res[0] == '-' {
+			return fmt.Errorf("starting replication: %s", res[1:len(res)-2])
+		}
+	}
+
+	return serve(l, executor)
+}
 
+// serve accepts client connections on l until it's closed.
+func serve(l net.Listener, executor *executor) error {
 	for {
-		// TODO: add connection timeout + limit number of concurrent clients.
 		conn, err := l.Accept()
 		if err != nil {
 			return fmt.Errorf("accepting connection: %v", err)
@@ -46,116 +121,321 @@ func run(l net.Listener) error {
 func handleConn(conn net.Conn, executor *executor) {
 	defer conn.Close()
 
-	bufrw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-	var res []byte
+	c := newConnClient(conn)
+	err := executor.addClient(c)
+	if err != nil {
+		conn.Write(resp.SerializeSimpleError(err.Error()))
+		return
+	}
+	defer executor.freeClient(c)
+
+	// The output is flushed before the client is freed and the connection
+	// closed, so that a client that half-closes the connection after sending
+	// its commands still gets their replies.
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		writeOutput(c, done)
+		close(flushed)
+	}()
+	defer func() {
+		close(done)
+		<-flushed
+	}()
+
+	// Commands are read by another goroutine, so that a disconnection is
+	// noticed while a blocking command is waiting for its reply.
+	requests := make(chan request)
+	disconnected := make(chan struct{})
+	go readRequests(conn, requests, disconnected, done)
 
+	for {
+		select {
+		case req := <-requests:
+			if req.err != nil {
+				c.send(resp.SerializeSimpleError(req.err.Error()))
+				continue
+			}
+
+			// The reply is written by writeOutput, but waiting for it keeps
+			// a client from queuing more commands than the executor can handle.
+			reply := executor.submit(c, req.cmd)
+			select {
+			case <-reply:
+			case <-disconnected:
+				// The reply is still written once the command ran. Freeing the
+				// client is queued after the command, and replies to it if it
+				// blocked.
+				executor.freeClient(c)
+				<-reply
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+// request is a command read from a connection, or the protocol error that
+// prevented reading it.
+type request struct {
+	cmd [][]byte
+	err error
+}
+
+// readRequests reads commands from conn until the connection is closed, which
+// closes disconnected, or until done is closed.
+func readRequests(conn net.Conn, requests chan<- request, disconnected chan<- struct{}, done <-chan struct{}) {
+	defer close(disconnected)
+
+	r := bufio.NewReader(conn)
 	for {
 		// A Redis command will always be an non-empty array, with the first argument
 		// being the command name.
-		cmd, err := resp.ParseArray(bufrw.Reader)
+		cmd, err := resp.ParseCommand(r)
 		if err != nil {
-			if err == io.EOF {
+			// Read errors, e.g. after the connection was closed because the
+			// client was too slow, end the connection. Other errors are
+			// protocol errors that are reported to the client.
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
 				return
 			}
+		}
 
-			res = resp.SerializeSimpleError(err.Error())
-		} else {
-			res = executor.execute(cmd)
+		select {
+		case requests <- request{cmd: cmd, err: err}:
+		case <-done:
+			return
 		}
+	}
+}
 
-		_, err = bufrw.Write(res)
+// writeOutput writes the replies and pushed messages of c to its connection
+// until done is closed, and then writes what is still queued. Replies and
+// pushes are written by a single goroutine, since pushes can arrive while
+// handleConn is waiting for the next command.
+func writeOutput(c *client, done <-chan struct{}) {
+	w := bufio.NewWriter(c.conn)
+	write := func(b []byte) bool {
+		_, err := w.Write(b)
 		if err != nil {
 			slog.Error("write to buffer failed", "err", err)
-			return
+			c.conn.Close()
+			return false
 		}
 
-		err = bufrw.Flush()
+		// Batch the output that is already waiting into a single write.
+		if len(c.out) > 0 {
+			return true
+		}
+
+		err = w.Flush()
 		if err != nil {
 			slog.Error("flush buffer to client connection failed", "err", err)
-			return
+			c.conn.Close()
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case b := <-c.out:
+			if !write(b) {
+				return
+			}
+		case <-done:
+			// A client that stopped reading can't hold the connection open
+			// forever.
+			c.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+			for {
+				select {
+				case b := <-c.out:
+					if !write(b) {
+						return
+					}
+				default:
+					w.Flush()
+					return
+				}
+			}
 		}
 	}
 }
 
+// drainTimeout bounds the time spent writing the remaining output of a
+// client that disconnected.
+const drainTimeout = 5 * time.Second
+
+// errWrongType is returned when a command is run against a key holding a data
+// type that the command doesn't support.
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type entry struct {
 	val       any
 	expiredAt time.Time
 }
 
+func (e entry) isExpired(now time.Time) bool {
+	return !e.expiredAt.IsZero() && now.After(e.expiredAt)
+}
+
 type store struct {
 	mp map[string]entry
+	// volatileKeys holds the keys that have an expiry, so that the active
+	// expiration cycle doesn't need to scan the whole keyspace.
+	volatileKeys map[string]struct{}
+	// watchers holds the clients that WATCH each key.
+	watchers map[string]map[*client]struct{}
 }
 
 func newStore() *store {
 	return &store{
-		mp: make(map[string]entry),
+		mp:           make(map[string]entry),
+		volatileKeys: make(map[string]struct{}),
+		watchers:     make(map[string]map[*client]struct{}),
 	}
 }
 
-func (s *store) set(key string, val []byte, expiredAt time.Time) error {
-	rawExistingVal, keyAlreadyExists := s.mp[key]
-	if keyAlreadyExists {
-		if _, isValueDataTypeCorrect := rawExistingVal.val.([]byte); !isValueDataTypeCorrect {
-			return fmt.Errorf("set key %s: wrong data type for existing value", key)
+// load replaces the whole dataset, e.g. with the content of a snapshot file.
+func (s *store) load(mp map[string]entry) {
+	for key := range s.watchers {
+		s.touch(key)
+	}
+
+	s.mp = make(map[string]entry, len(mp))
+	s.volatileKeys = make(map[string]struct{})
+	for key, e := range mp {
+		s.put(key, e)
+	}
+}
+
+// snapshot returns a point-in-time copy of the dataset that stays consistent
+// while the store keeps accepting writes. Strings and lists are never modified
+// in place, so they can be shared with the snapshot, while the other data
+// types are deep copied.
+func (s *store) snapshot() map[string]entry {
+	retval := maps.Clone(s.mp)
+	for key, e := range retval {
+		if c, ok := e.val.(cloner); ok {
+			e.val = c.clone()
+			retval[key] = e
 		}
 	}
+	return retval
+}
 
-	s.mp[key] = entry{
-		val:       val,
-		expiredAt: expiredAt,
+// cloner is implemented by data types that are modified in place.
+type cloner interface {
+	clone() any
+}
+
+// lookupTyped returns the value stored at key if it's a T. It returns
+// errWrongType if the key holds another data type.
+func lookupTyped[T any](s *store, key string) (val T, exists bool, err error) {
+	e, ok := s.lookup(key)
+	if !ok {
+		return val, false, nil
+	}
+
+	val, ok = e.val.(T)
+	if !ok {
+		return val, false, errWrongType
 	}
 
-	return nil
+	return val, true, nil
 }
 
-func (s *store) get(key string) ([]byte, error) {
+// lookup returns the entry stored at key. Expired keys are deleted lazily
+// here and reported as missing.
+func (s *store) lookup(key string) (entry, bool) {
 	e, ok := s.mp[key]
 	if !ok {
-		return nil, nil
+		return entry{}, false
 	}
 
-	val, ok := e.val.([]byte)
-	if !ok {
-		return nil, fmt.Errorf("get key %s: invalid data type for value", key)
+	if e.isExpired(time.Now()) {
+		s.del(key)
+		return entry{}, false
+	}
+
+	return e, true
+}
+
+// put stores e at key, replacing any existing value regardless of its type.
+func (s *store) put(key string, e entry) {
+	s.touch(key)
+	s.mp[key] = e
+	if e.expiredAt.IsZero() {
+		delete(s.volatileKeys, key)
+	} else {
+		s.volatileKeys[key] = struct{}{}
+	}
+}
+
+// del removes key from the store and reports whether it existed.
+func (s *store) del(key string) bool {
+	_, ok := s.mp[key]
+	if ok {
+		s.touch(key)
 	}
+	delete(s.mp, key)
+	delete(s.volatileKeys, key)
+	return ok
+}
 
-	if !e.expiredAt.IsZero() && time.Now().After(e.expiredAt) {
-		delete(s.mp, key)
+func (s *store) set(key string, val []byte, expiredAt time.Time) {
+	s.put(key, entry{
+		val:       val,
+		expiredAt: expiredAt,
+	})
+}
+
+func (s *store) get(key string) ([]byte, error) {
+	e, ok := s.lookup(key)
+	if !ok {
 		return nil, nil
 	}
 
+	val, ok := e.val.([]byte)
+	if !ok {
+		return nil, errWrongType
+	}
+
 	return val, nil
 }
 
 func (s *store) rpush(key string, newElems [][]byte) (int, error) {
 	var updatedVal [][]byte
 
-	rawExistingVal, keyAlreadyExists := s.mp[key]
+	rawExistingVal, keyAlreadyExists := s.lookup(key)
 	if keyAlreadyExists {
 		existingVal, isValueDataTypeCorrect := rawExistingVal.val.([][]byte)
 		if !isValueDataTypeCorrect {
-			return 0, fmt.Errorf("rpush %s: wrong data type for existing value", key)
+			return 0, errWrongType
 		}
 		updatedVal = existingVal
 	}
 
 	updatedVal = slices.Concat(updatedVal, newElems)
-	s.mp[key] = entry{
-		val: updatedVal,
-	}
+	s.put(key, entry{
+		val:       updatedVal,
+		expiredAt: rawExistingVal.expiredAt,
+	})
 
 	return len(updatedVal), nil
 }
 
 func (s *store) lrange(key string, start, stop int) ([][]byte, error) {
-	rawExistingVal, keyAlreadyExists := s.mp[key]
+	rawExistingVal, keyAlreadyExists := s.lookup(key)
 	if !keyAlreadyExists {
 		return nil, nil
 	}
 
 	existingVal, isValueDataTypeCorrect := rawExistingVal.val.([][]byte)
 	if !isValueDataTypeCorrect {
-		return nil, fmt.Errorf("lrange %s: wrong data type for existing value", key)
+		return nil, errWrongType
 	}
 
 	if start < 0 {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// queueCommand adds cmd to the client's transaction instead of running it.
+func (ex *executor) queueCommand(cmd command) []byte {
+	c := cmd.client
+
+	err := checkArity(cmd)
+	if err != nil {
+		c.multiAborted = true
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	c.queued = append(c.queued, cmd)
+	return resp.SerializeSimpleString("QUEUED")
+}
+
+// multiCmd handles MULTI.
+//
+// https://redis.io/docs/latest/develop/interact/transactions/
+func (ex *executor) multiCmd(cmd command) []byte {
+	if cmd.client.inMulti {
+		return resp.SerializeSimpleError("MULTI calls can not be nested")
+	}
+
+	cmd.client.inMulti = true
+	return resp.SerializeSimpleString("OK")
+}
+
+// execCmd handles EXEC. The queued commands run one after another without
+// any other client's command in between, since the executor is single
+// threaded. A failing command doesn't stop the rest of the transaction.
+func (ex *executor) execCmd(cmd command) []byte {
+	c := cmd.client
+	if !c.inMulti {
+		return resp.SerializeSimpleError("EXEC without MULTI")
+	}
+	defer ex.resetTransaction(c)
+
+	if c.multiAborted {
+		return resp.SerializeSimpleError("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	// Looking up the watched keys deletes the ones that expired since WATCH,
+	// which marks the client as dirty.
+	for key := range c.watchedKeys {
+		ex.store.lookup(key)
+	}
+	if c.dirty {
+		return resp.NullArray
+	}
+
+	ex.inExec = true
+	replies := make([][]byte, 0, len(c.queued))
+	for _, queuedCmd := range c.queued {
+		replies = append(replies, ex.dispatch(queuedCmd))
+	}
+	ex.inExec = false
+
+	if ex.execPropagated {
+		ex.execPropagated = false
+		ex.propagate("EXEC", nil)
+	}
+
+	return resp.SerializeRawArray(replies)
+}
+
+// discardCmd handles DISCARD.
+func (ex *executor) discardCmd(cmd command) []byte {
+	if !cmd.client.inMulti {
+		return resp.SerializeSimpleError("DISCARD without MULTI")
+	}
+
+	ex.resetTransaction(cmd.client)
+	return resp.SerializeSimpleString("OK")
+}
+
+// watchCmd handles WATCH key [key ...].
+func (ex *executor) watchCmd(cmd command) []byte {
+	if cmd.client.inMulti {
+		return resp.SerializeSimpleError("WATCH inside MULTI is not allowed")
+	}
+	if len(cmd.args) == 0 {
+		return wrongNumArgs("at least 1", len(cmd.args))
+	}
+
+	for _, key := range cmd.args {
+		// Purge the key first if it already expired, so that its deletion
+		// doesn't count as a modification.
+		ex.store.lookup(string(key))
+		ex.store.watch(cmd.client, string(key))
+	}
+
+	return resp.SerializeSimpleString("OK")
+}
+
+// unwatchCmd handles UNWATCH.
+func (ex *executor) unwatchCmd(cmd command) []byte {
+	ex.store.unwatchAll(cmd.client)
+	return resp.SerializeSimpleString("OK")
+}
+
+func (ex *executor) resetTransaction(c *client) {
+	c.inMulti = false
+	c.queued = nil
+	c.multiAborted = false
+	ex.store.unwatchAll(c)
+}
+
+// watch marks key as watched by c, so that c becomes dirty when key is
+// modified.
+func (s *store) watch(c *client, key string) {
+	if _, ok := c.watchedKeys[key]; ok {
+		return
+	}
+
+	c.watchedKeys[key] = struct{}{}
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[*client]struct{})
+	}
+	s.watchers[key][c] = struct{}{}
+}
+
+// unwatchAll forgets every key watched by c and clears its dirty flag.
+func (s *store) unwatchAll(c *client) {
+	for key := range c.watchedKeys {
+		delete(s.watchers[key], c)
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+
+	clear(c.watchedKeys)
+	c.dirty = false
+}
+
+// touch signals that key was modified, which invalidates the transactions of
+// every client watching it.
+func (s *store) touch(key string) {
+	for c := range s.watchers[key] {
+		c.dirty = true
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// subscriberModeCommands are the only commands a client may send while it's
+// subscribed to a channel or pattern.
+var subscriberModeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// checkSubscriberMode returns an error reply if c is in subscriber mode and
+// cmd isn't allowed there. RESP3 clients can send any command, since pushed
+// messages can't be mistaken for replies.
+func checkSubscriberMode(cmd command) []byte {
+	if !cmd.client.subscribed() || cmd.client.protocol == 3 || subscriberModeCommands[cmd.name] {
+		return nil
+	}
+
+	return resp.SerializeSimpleError(fmt.Sprintf(
+		"Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context",
+		strings.ToLower(cmd.name)))
+}
+
+// subscribeCmd handles SUBSCRIBE channel [channel ...] and PSUBSCRIBE pattern
+// [pattern ...]. The reply holds one confirmation per channel, each with the
+// number of subscriptions the client has afterwards.
+//
+// https://redis.io/docs/latest/develop/interact/pubsub/
+func (ex *executor) subscribeCmd(cmd command) []byte {
+	if len(cmd.args) == 0 {
+		return wrongNumArgs("at least 1", len(cmd.args))
+	}
+
+	kind, clientSubs, subs := ex.subscriptions(cmd)
+
+	var retval []byte
+	for _, arg := range cmd.args {
+		name := string(arg)
+		if _, ok := clientSubs[name]; !ok {
+			clientSubs[name] = struct{}{}
+			if subs[name] == nil {
+				subs[name] = make(map[*client]struct{})
+			}
+			subs[name][cmd.client] = struct{}{}
+		}
+
+		retval = append(retval, subscriptionReply(kind, arg, cmd.client)...)
+	}
+
+	return retval
+}
+
+// unsubscribeCmd handles UNSUBSCRIBE [channel ...] and PUNSUBSCRIBE
+// [pattern ...]. Without arguments, the client unsubscribes from every channel
+// or pattern respectively.
+func (ex *executor) unsubscribeCmd(cmd command) []byte {
+	kind, clientSubs, subs := ex.subscriptions(cmd)
+
+	names := cmd.args
+	if len(names) == 0 {
+		for name := range clientSubs {
+			names = append(names, []byte(name))
+		}
+	}
+	if len(names) == 0 {
+		return subscriptionReply(kind, nil, cmd.client)
+	}
+
+	var retval []byte
+	for _, arg := range names {
+		name := string(arg)
+		delete(clientSubs, name)
+		delete(subs[name], cmd.client)
+		if len(subs[name]) == 0 {
+			delete(subs, name)
+		}
+
+		retval = append(retval, subscriptionReply(kind, arg, cmd.client)...)
+	}
+
+	return retval
+}
+
+// subscriptions returns the reply kind of a (un)subscribe command, along with
+// the client's subscriptions and the executor's subscribers that it changes.
+func (ex *executor) subscriptions(cmd command) (
+	kind string,
+	clientSubs map[string]struct{},
+	subs map[string]map[*client]struct{},
+) {
+	kind = strings.ToLower(cmd.name)
+	if strings.HasPrefix(cmd.name, "P") {
+		return kind, cmd.client.patterns, ex.patterns
+	}
+	return kind, cmd.client.channels, ex.channels
+}
+
+func subscriptionReply(kind string, name []byte, c *client) []byte {
+	return pushReply(c, [][]byte{
+		resp.SerializeBulkString([]byte(kind)),
+		adaptNull(c, resp.SerializeBulkString(name)),
+		resp.SerializeInteger(len(c.channels) + len(c.patterns)),
+	})
+}
+
+// publishCmd handles PUBLISH channel message. The reply is the number of
+// clients that received the message. A client subscribed to both the channel
+// and matching patterns receives the message once for each of them. Like in
+// Redis, the message is also sent to the replicas, so that their subscribers
+// receive it too.
+func (ex *executor) publishCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	channel, message := cmd.args[0], cmd.args[1]
+
+	numReceivers := 0
+	for c := range ex.channels[string(channel)] {
+		c.send(pushReply(c, [][]byte{
+			resp.SerializeBulkString([]byte("message")),
+			resp.SerializeBulkString(channel),
+			resp.SerializeBulkString(message),
+		}))
+		numReceivers++
+	}
+
+	for pattern, subscribers := range ex.patterns {
+		if !globMatch(pattern, string(channel)) {
+			continue
+		}
+
+		for c := range subscribers {
+			c.send(pushReply(c, [][]byte{
+				resp.SerializeBulkString([]byte("pmessage")),
+				resp.SerializeBulkString([]byte(pattern)),
+				resp.SerializeBulkString(channel),
+				resp.SerializeBulkString(message),
+			}))
+			numReceivers++
+		}
+	}
+
+	ex.propagateToReplicas(cmd.name, cmd.args)
+	return resp.SerializeInteger(numReceivers)
+}
+
+// unsubscribeAll removes every subscription of c without replying.
+func (ex *executor) unsubscribeAll(c *client) {
+	for channel := range c.channels {
+		delete(ex.channels[channel], c)
+		if len(ex.channels[channel]) == 0 {
+			delete(ex.channels, channel)
+		}
+	}
+	for pattern := range c.patterns {
+		delete(ex.patterns[pattern], c)
+		if len(ex.patterns[pattern]) == 0 {
+			delete(ex.patterns, pattern)
+		}
+	}
+
+	clear(c.channels)
+	clear(c.patterns)
+}
+
+// maxGlobNesting limits the recursion of globMatch on patterns with many
+// stars, like in Redis.
+const maxGlobNesting = 1000
+
+// globMatch reports whether s matches the glob-style pattern used by
+// PSUBSCRIBE. It supports `*`, `?`, `[...]` classes with ranges and `^`
+// negation, and `\` to escape special characters.
+func globMatch(pattern, s string) bool {
+	var skipLonger bool
+	return globMatchNested(pattern, s, 0, &skipLonger)
+}
+
+// globMatchNested is globMatch for a pattern nested in nesting stars. Once the
+// rest of a pattern doesn't match any suffix of s, skipLonger is set so that
+// the enclosing stars stop trying to match longer prefixes, which could only
+// leave shorter suffixes. Without it, patterns like `*a*a*a*a*b` take
+// exponential time.
+func globMatchNested(pattern, s string, nesting int, skipLonger *bool) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Consecutive stars are equivalent to a single one.
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatchNested(pattern, s[i:], nesting+1, skipLonger) {
+					return true
+				}
+				if *skipLonger {
+					return false
+				}
+			}
+			*skipLonger = true
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+
+	return len(s) == 0
+}
+
+// matchClass matches b against the character class at the start of pattern,
+// right after the opening bracket. It returns the pattern that follows the
+// class. Like Redis, an unterminated class extends to the end of the pattern.
+func matchClass(pattern string, b byte) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= b && b <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing bracket.
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
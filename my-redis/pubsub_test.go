@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"news", "news", true},
+		{"news", "new", false},
+		{"news.*", "news.tech", true},
+		{"news.*", "news.", true},
+		{"news.*", "sports.tech", false},
+		{"*", "", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a/*", "a/b/c", true},
+		{"*a*b", "xaybzb", true},
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 100), false},
+		{strings.Repeat("*a", 2000), strings.Repeat("a", 2000), false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, globMatch(tt.pattern, tt.s), "pattern %q, string %q", tt.pattern, tt.s)
+	}
+}
+
+func TestSubscriberMode(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	c := newClient()
+	run := func(args ...string) string {
+		return string(executeAs(ex, c, args...))
+	}
+
+	require.Equal(t, "*3\r\n$10\r\npsubscribe\r\n$1\r\n*\r\n:1\r\n", run("PSUBSCRIBE", "*"))
+	require.Equal(t, "-Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n",
+		run("GET", "foo"))
+	require.Equal(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", run("PING"))
+
+	// The client stays in subscriber mode until it has no channel or pattern
+	// left.
+	require.Equal(t, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:1\r\n", run("UNSUBSCRIBE"))
+	require.Equal(t, "*3\r\n$12\r\npunsubscribe\r\n$1\r\n*\r\n:0\r\n", run("PUNSUBSCRIBE"))
+	require.Equal(t, "$-1\r\n", run("GET", "foo"))
+}
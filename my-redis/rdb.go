@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+)
+
+// The snapshot file is loosely modeled after Redis's RDB format. It starts with
+// a magic string and a version, followed by one record per key and an EOF
+// opcode with a CRC64 checksum of everything before it.
+//
+// Each record is laid out as:
+//
+//	[rdbOpExpireTimeMs <unix ms as uint64 LE>] <value type> <key> <value>
+//
+// Strings are encoded as a uvarint length followed by the raw bytes. Lists and
+// sets are encoded as a uvarint element count followed by each element as a
+// string. Hashes are encoded the same way, with each field followed by its
+// value, and sorted sets with each member followed by its score as a float64
+// in little endian.
+//
+// https://rdb.fnordig.de/file_format.html
+const (
+	rdbMagic   = "MYRDB"
+	rdbVersion = "0001"
+
+	rdbOpExpireTimeMs byte = 0xFC
+	rdbOpEOF          byte = 0xFF
+
+	rdbTypeString    byte = 0
+	rdbTypeList      byte = 1
+	rdbTypeSet       byte = 2
+	rdbTypeSortedSet byte = 3
+	rdbTypeHash      byte = 4
+)
+
+var rdbCRCTable = crc64.MakeTable(crc64.ECMA)
+
+// save writes a snapshot of the store to disk, blocking every other command
+// until it's done.
+func (ex *executor) save() error {
+	if ex.bgSaving {
+		return errors.New("background save already in progress")
+	}
+
+	err := writeRDBFile(ex.cfg.dbFilename, ex.store.mp)
+	if err != nil {
+		return err
+	}
+
+	ex.lastSave = time.Now()
+	return nil
+}
+
+// bgSave writes a snapshot of the store to disk in a separate goroutine, so
+// that clients can keep being served in the meantime.
+func (ex *executor) bgSave() error {
+	if ex.bgSaving {
+		return errors.New("background save already in progress")
+	}
+
+	snapshot := ex.store.snapshot()
+	ex.bgSaving = true
+
+	go func() {
+		err := writeRDBFile(ex.cfg.dbFilename, snapshot)
+		ex.tasks <- func() {
+			ex.bgSaving = false
+			if err != nil {
+				slog.Error("background save failed", "err", err)
+				return
+			}
+
+			ex.lastSave = time.Now()
+			slog.Info("background save finished", "path", ex.cfg.dbFilename)
+		}
+	}()
+
+	return nil
+}
+
+// writeRDBFile writes the given dataset to a temporary file, then renames it
+// to path so that readers never observe a half-written snapshot.
+func writeRDBFile(path string, mp map[string]entry) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create rdb file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	err = writeRDB(f, mp)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return fmt.Errorf("fsync rdb file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("rename rdb file: %w", err)
+	}
+
+	return nil
+}
+
+// writeRDB encodes the given dataset into w. Keys that have already expired
+// are skipped.
+func writeRDB(w io.Writer, mp map[string]entry) error {
+	crc := crc64.New(rdbCRCTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	_, err := bw.WriteString(rdbMagic + rdbVersion)
+	if err != nil {
+		return fmt.Errorf("write rdb header: %w", err)
+	}
+
+	now := time.Now()
+	for key, e := range mp {
+		if e.isExpired(now) {
+			continue
+		}
+
+		if !e.expiredAt.IsZero() {
+			bw.WriteByte(rdbOpExpireTimeMs)
+			bw.Write(binary.LittleEndian.AppendUint64(nil, uint64(e.expiredAt.UnixMilli())))
+		}
+
+		switch val := e.val.(type) {
+		case []byte:
+			bw.WriteByte(rdbTypeString)
+			writeRDBString(bw, []byte(key))
+			writeRDBString(bw, val)
+		case [][]byte:
+			bw.WriteByte(rdbTypeList)
+			writeRDBString(bw, []byte(key))
+			bw.Write(binary.AppendUvarint(nil, uint64(len(val))))
+			for _, elem := range val {
+				writeRDBString(bw, elem)
+			}
+		case set:
+			bw.WriteByte(rdbTypeSet)
+			writeRDBString(bw, []byte(key))
+			bw.Write(binary.AppendUvarint(nil, uint64(len(val))))
+			for member := range val {
+				writeRDBString(bw, []byte(member))
+			}
+		case *sortedSet:
+			bw.WriteByte(rdbTypeSortedSet)
+			writeRDBString(bw, []byte(key))
+			bw.Write(binary.AppendUvarint(nil, uint64(val.zsl.length)))
+			for x := val.zsl.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+				writeRDBString(bw, []byte(x.member))
+				bw.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(x.score)))
+			}
+		case hash:
+			bw.WriteByte(rdbTypeHash)
+			writeRDBString(bw, []byte(key))
+			bw.Write(binary.AppendUvarint(nil, uint64(len(val))))
+			for field, fieldVal := range val {
+				writeRDBString(bw, []byte(field))
+				writeRDBString(bw, fieldVal)
+			}
+		default:
+			return fmt.Errorf("save key %s: unsupported data type %T", key, val)
+		}
+	}
+
+	err = bw.WriteByte(rdbOpEOF)
+	if err != nil {
+		return fmt.Errorf("write rdb file: %w", err)
+	}
+
+	// The checksum must be computed over everything written so far, so flush
+	// before reading it and write it past the CRC writer.
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("write rdb file: %w", err)
+	}
+
+	_, err = w.Write(binary.LittleEndian.AppendUint64(nil, crc.Sum64()))
+	if err != nil {
+		return fmt.Errorf("write rdb checksum: %w", err)
+	}
+
+	return nil
+}
+
+func writeRDBString(w *bufio.Writer, b []byte) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	w.Write(b)
+}
+
+// loadRDB reads the snapshot file at path. A missing file is treated as an
+// empty dataset.
+func loadRDB(path string) (map[string]entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return make(map[string]entry), nil
+		}
+		return nil, fmt.Errorf("read rdb file: %w", err)
+	}
+
+	mp, err := readRDB(data)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("loaded data from rdb file", "path", path, "keys", len(mp))
+	return mp, nil
+}
+
+// readRDB decodes a snapshot produced by writeRDB.
+func readRDB(data []byte) (map[string]entry, error) {
+	headerLen := len(rdbMagic) + len(rdbVersion)
+	if len(data) < headerLen+1+8 {
+		return nil, errors.New("rdb file is too short")
+	}
+	if string(data[:len(rdbMagic)]) != rdbMagic {
+		return nil, errors.New("rdb file has an invalid magic string")
+	}
+	if version := string(data[len(rdbMagic):headerLen]); version != rdbVersion {
+		return nil, fmt.Errorf("unsupported rdb version %s", version)
+	}
+
+	body, checksum := data[:len(data)-8], data[len(data)-8:]
+	if crc64.Checksum(body, rdbCRCTable) != binary.LittleEndian.Uint64(checksum) {
+		return nil, errors.New("rdb file checksum mismatch")
+	}
+
+	r := bytes.NewReader(body[headerLen:])
+	mp := make(map[string]entry)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read rdb opcode: %w", err)
+		}
+		if op == rdbOpEOF {
+			break
+		}
+
+		var e entry
+		if op == rdbOpExpireTimeMs {
+			var expiredAtMillis uint64
+			err = binary.Read(r, binary.LittleEndian, &expiredAtMillis)
+			if err != nil {
+				return nil, fmt.Errorf("read expire time: %w", err)
+			}
+			e.expiredAt = time.UnixMilli(int64(expiredAtMillis))
+
+			op, err = r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("read value type: %w", err)
+			}
+		}
+
+		key, err := readRDBString(r)
+		if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+
+		switch op {
+		case rdbTypeString:
+			e.val, err = readRDBString(r)
+			if err != nil {
+				return nil, fmt.Errorf("read string value of key %s: %w", key, err)
+			}
+		case rdbTypeList:
+			var numElems uint64
+			numElems, err = binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("read list length of key %s: %w", key, err)
+			}
+
+			list := make([][]byte, 0, numElems)
+			for range numElems {
+				elem, err := readRDBString(r)
+				if err != nil {
+					return nil, fmt.Errorf("read list element of key %s: %w", key, err)
+				}
+				list = append(list, elem)
+			}
+			e.val = list
+		case rdbTypeSet:
+			var numMembers uint64
+			numMembers, err = binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("read set length of key %s: %w", key, err)
+			}
+
+			st := make(set, numMembers)
+			for range numMembers {
+				member, err := readRDBString(r)
+				if err != nil {
+					return nil, fmt.Errorf("read set member of key %s: %w", key, err)
+				}
+				st[string(member)] = struct{}{}
+			}
+			e.val = st
+		case rdbTypeSortedSet:
+			var numMembers uint64
+			numMembers, err = binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("read sorted set length of key %s: %w", key, err)
+			}
+
+			z := newSortedSet()
+			for range numMembers {
+				member, err := readRDBString(r)
+				if err != nil {
+					return nil, fmt.Errorf("read sorted set member of key %s: %w", key, err)
+				}
+
+				var scoreBits uint64
+				err = binary.Read(r, binary.LittleEndian, &scoreBits)
+				if err != nil {
+					return nil, fmt.Errorf("read sorted set score of key %s: %w", key, err)
+				}
+				z.set(string(member), math.Float64frombits(scoreBits))
+			}
+			e.val = z
+		case rdbTypeHash:
+			var numFields uint64
+			numFields, err = binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("read hash length of key %s: %w", key, err)
+			}
+
+			h := make(hash, numFields)
+			for range numFields {
+				field, err := readRDBString(r)
+				if err != nil {
+					return nil, fmt.Errorf("read hash field of key %s: %w", key, err)
+				}
+				fieldVal, err := readRDBString(r)
+				if err != nil {
+					return nil, fmt.Errorf("read hash value of key %s: %w", key, err)
+				}
+				h[string(field)] = fieldVal
+			}
+			e.val = h
+		default:
+			return nil, fmt.Errorf("unknown value type %d for key %s", op, key)
+		}
+
+		mp[string(key)] = e
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.New("unexpected data after rdb EOF opcode")
+	}
+
+	return mp, nil
+}
+
+func readRDBString(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRDBRoundTrip(t *testing.T) {
+	expiredAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	mp := map[string]entry{
+		"str":     {val: []byte("hello")},
+		"empty":   {val: []byte{}},
+		"list":    {val: [][]byte{[]byte("a"), []byte("b")}},
+		"ttl":     {val: []byte("x"), expiredAt: expiredAt},
+		"expired": {val: []byte("y"), expiredAt: time.Now().Add(-time.Second)},
+		"hash":    {val: hash{"f1": []byte("v1"), "f2": []byte("v2")}},
+		"set":     {val: set{"a": {}, "b": {}}},
+	}
+
+	z := newSortedSet()
+	z.set("a", 1.5)
+	z.set("b", math.Inf(-1))
+	mp["zset"] = entry{val: z}
+
+	var buf bytes.Buffer
+	err := writeRDB(&buf, mp)
+	require.NoError(t, err)
+
+	got, err := readRDB(buf.Bytes())
+	require.NoError(t, err)
+
+	// Skip lists have random levels, so sorted sets are compared by content.
+	gotZ := got["zset"].val.(*sortedSet)
+	require.Equal(t, z.scores, gotZ.scores)
+	require.Equal(t, []string{"b", "a"}, []string{
+		gotZ.zsl.byRank(1).member,
+		gotZ.zsl.byRank(2).member,
+	})
+
+	delete(mp, "expired")
+	delete(mp, "zset")
+	delete(got, "zset")
+	require.Equal(t, mp, got)
+}
+
+func TestRDBChecksumMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeRDB(&buf, map[string]entry{"foo": {val: []byte("bar")}})
+	require.NoError(t, err)
+
+	data := buf.Bytes()
+	data[len(rdbMagic)+len(rdbVersion)+3] ^= 0xFF
+
+	_, err = readRDB(data)
+	require.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestBGSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+
+	ex := newExecutor(newStore(), config{dbFilename: path})
+	execute(ex, "SET", "foo", "bar")
+	execute(ex, "RPUSH", "list", "a", "b")
+
+	lastSave := string(execute(ex, "LASTSAVE"))
+	time.Sleep(time.Second)
+
+	require.Equal(t, "+Background saving started\r\n", string(execute(ex, "BGSAVE")))
+	require.Eventually(t, func() bool {
+		return string(execute(ex, "LASTSAVE")) != lastSave
+	}, time.Second, 10*time.Millisecond)
+
+	mp, err := loadRDB(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]entry{
+		"foo":  {val: []byte("bar")},
+		"list": {val: [][]byte{[]byte("a"), []byte("b")}},
+	}, mp)
+}
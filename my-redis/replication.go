@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// replBacklogSize is the number of bytes of the replication stream kept for
+// partial resyncs. A replica that falls further behind needs a full sync.
+const replBacklogSize = 1 << 20
+
+// replicaAckInterval is how often a replica reports its offset to the primary.
+const replicaAckInterval = time.Second
+
+// errReadOnlyReplica is returned when a client sends a write command to a
+// replica.
+var errReadOnlyReplica = errors.New("READONLY You can't write against a read only replica.")
+
+// replica is the state that a primary keeps about each connected replica.
+type replica struct {
+	// online is false while the replica waits for the snapshot of a full
+	// sync. Until then, the replication stream is only kept in the backlog.
+	online bool
+	// ackOffset is the offset last acknowledged by the replica.
+	ackOffset int64
+	// listeningPort is the port the replica accepts clients on.
+	listeningPort string
+}
+
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replBacklog holds the end of the replication stream, so that a replica that
+// briefly disconnected can resume from its last offset.
+type replBacklog struct {
+	buf  []byte
+	size int
+	// endOffset is the replication offset right after the last byte in buf.
+	endOffset int64
+}
+
+func newReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		size:      size,
+		endOffset: offset,
+	}
+}
+
+func (b *replBacklog) write(p []byte) {
+	b.buf = append(b.buf, p...)
+	b.endOffset += int64(len(p))
+
+	// Trimming only once the buffer doubles keeps the copies amortized.
+	if len(b.buf) > 2*b.size {
+		b.buf = bytes.Clone(b.buf[len(b.buf)-b.size:])
+	}
+}
+
+// since returns the stream that follows offset. It returns false if part of
+// it is no longer in the backlog.
+func (b *replBacklog) since(offset int64) ([]byte, bool) {
+	startOffset := b.endOffset - int64(len(b.buf))
+	if offset < startOffset || offset > b.endOffset {
+		return nil, false
+	}
+
+	return bytes.Clone(b.buf[offset-startOffset:]), true
+}
+
+// feedReplicas appends an encoded write command to the replication stream.
+func (ex *executor) feedReplicas(b []byte) {
+	ex.backlog.write(b)
+	ex.replOffset += int64(len(b))
+
+	for c, r := range ex.replicas {
+		if r.online {
+			c.send(b)
+		}
+	}
+}
+
+// psyncCmd handles PSYNC replicationid offset, sent by a replica to start
+// receiving the replication stream. The replica resumes from offset if the
+// backlog still holds it, and gets a full snapshot of the dataset otherwise.
+//
+// https://redis.io/docs/latest/operate/oss_and_stack/management/replication/
+func (ex *executor) psyncCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+	// Replicas apply the stream of their primary without keeping a backlog,
+	// so they can't serve one.
+	if ex.link != nil {
+		return resp.SerializeSimpleError("PSYNC is not supported by replicas")
+	}
+
+	c := cmd.client
+	if ex.backlog == nil {
+		ex.backlog = newReplBacklog(replBacklogSize, ex.replOffset)
+	}
+
+	// Like Redis, the offset is the one of the first byte that the replica
+	// is missing.
+	replID := string(cmd.args[0])
+	psyncOffset, err := strconv.ParseInt(string(cmd.args[1]), 10, 64)
+	if err == nil && replID == ex.replID {
+		stream, ok := ex.backlog.since(psyncOffset - 1)
+		if ok {
+			ex.replicas[c] = &replica{online: true, listeningPort: c.listeningPort}
+			ex.numPartialSyncs++
+			slog.Info("partial resync accepted", "offset", psyncOffset, "backlog", len(stream))
+			return append(resp.SerializeSimpleString("CONTINUE "+ex.replID), stream...)
+		}
+	}
+
+	ex.replicas[c] = &replica{listeningPort: c.listeningPort}
+	ex.numFullSyncs++
+
+	snapshot := ex.store.snapshot()
+	offset := ex.replOffset
+	go func() {
+		var buf bytes.Buffer
+		err := writeRDB(&buf, snapshot)
+		ex.tasks <- func() {
+			ex.finishFullSync(c, offset, buf.Bytes(), err)
+		}
+	}()
+
+	return resp.SerializeSimpleString(fmt.Sprintf("FULLRESYNC %s %d", ex.replID, offset))
+}
+
+// finishFullSync sends the snapshot taken at offset to the replica, followed
+// by the commands that were propagated since then.
+func (ex *executor) finishFullSync(c *client, offset int64, rdb []byte, rdbErr error) {
+	r, ok := ex.replicas[c]
+	if !ok {
+		// The replica disconnected in the meantime.
+		return
+	}
+
+	if rdbErr != nil {
+		slog.Error("encode snapshot for full sync failed", "err", rdbErr)
+		c.close()
+		return
+	}
+
+	stream, ok := ex.backlog.since(offset)
+	if !ok {
+		slog.Warn("replication backlog overflowed during full sync, dropping replica")
+		c.close()
+		return
+	}
+
+	// Unlike a bulk string, the snapshot isn't followed by CRLF.
+	c.send(append(fmt.Appendf(nil, "$%d\r\n", len(rdb)), rdb...))
+	c.send(stream)
+	r.online = true
+}
+
+// replconfCmd handles REPLCONF option value [option value ...], which a
+// replica uses to configure its replication stream.
+func (ex *executor) replconfCmd(cmd command) []byte {
+	if len(cmd.args)%2 != 0 || len(cmd.args) == 0 {
+		return wrongNumArgs("option-value pairs", len(cmd.args))
+	}
+
+	r := ex.replicas[cmd.client]
+	for i := 0; i < len(cmd.args); i += 2 {
+		val := string(cmd.args[i+1])
+		switch strings.ToUpper(string(cmd.args[i])) {
+		case "ACK":
+			if r != nil {
+				r.ackOffset, _ = strconv.ParseInt(val, 10, 64)
+			}
+			// Acknowledgements don't get a reply, since the connection is
+			// used for the replication stream.
+			return []byte{}
+		case "LISTENING-PORT":
+			cmd.client.listeningPort = val
+		}
+	}
+
+	return resp.SerializeSimpleString("OK")
+}
+
+// replicaofCmd handles REPLICAOF host port and REPLICAOF NO ONE.
+//
+// https://redis.io/docs/latest/commands/replicaof/
+func (ex *executor) replicaofCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	host, port := string(cmd.args[0]), string(cmd.args[1])
+	if strings.EqualFold(host, "NO") && strings.EqualFold(port, "ONE") {
+		if ex.link != nil {
+			// The promoted replica starts a new replication history, which its
+			// own replicas have to fully sync from.
+			ex.replOffset = ex.link.offset.Load()
+			ex.replID = newReplID()
+			ex.stopReplication()
+			slog.Info("promoted to primary", "replid", ex.replID, "offset", ex.replOffset)
+		}
+		return resp.SerializeSimpleString("OK")
+	}
+
+	_, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return resp.SerializeSimpleError("Invalid master port")
+	}
+
+	addr := net.JoinHostPort(host, port)
+	if ex.link != nil && ex.link.addr == addr {
+		return resp.SerializeSimpleString("OK Already connected to specified master")
+	}
+
+	ex.stopReplication()
+	// The replicas of this server have to sync again, since its dataset is
+	// about to be replaced.
+	for c := range ex.replicas {
+		c.close()
+	}
+	clear(ex.replicas)
+	ex.backlog = nil
+
+	ex.link = newReplicationLink(ex, addr)
+	slog.Info("replicating from primary", "addr", addr)
+	return resp.SerializeSimpleString("OK")
+}
+
+func (ex *executor) stopReplication() {
+	if ex.link == nil {
+		return
+	}
+
+	ex.link.cancel()
+	ex.link = nil
+	ex.masterClient = nil
+}
+
+// roleCmd handles ROLE.
+//
+// https://redis.io/docs/latest/commands/role/
+func (ex *executor) roleCmd(cmd command) []byte {
+	if ex.link != nil {
+		host, port, _ := net.SplitHostPort(ex.link.addr)
+		portNum, _ := strconv.Atoi(port)
+		return resp.SerializeRawArray([][]byte{
+			resp.SerializeBulkString([]byte("slave")),
+			resp.SerializeBulkString([]byte(host)),
+			resp.SerializeInteger(portNum),
+			resp.SerializeBulkString([]byte(ex.link.state.Load().(string))),
+			resp.SerializeInteger(int(ex.link.offset.Load())),
+		})
+	}
+
+	replicas := make([][]byte, 0, len(ex.replicas))
+	for c, r := range ex.replicas {
+		host, port := replicaAddr(c, r)
+		replicas = append(replicas, resp.SerializeArray([][]byte{
+			[]byte(host),
+			[]byte(port),
+			strconv.AppendInt(nil, r.ackOffset, 10),
+		}))
+	}
+
+	return resp.SerializeRawArray([][]byte{
+		resp.SerializeBulkString([]byte("master")),
+		resp.SerializeInteger(int(ex.replOffset)),
+		resp.SerializeRawArray(replicas),
+	})
+}
+
+// replicaAddr returns the address at which a replica accepts connections. The
+// port is the one announced with REPLCONF listening-port, if any.
+func replicaAddr(c *client, r *replica) (host, port string) {
+	port = r.listeningPort
+	if c.conn != nil {
+		var remotePort string
+		host, remotePort, _ = net.SplitHostPort(c.conn.RemoteAddr().String())
+		if port == "" {
+			port = remotePort
+		}
+	}
+	return host, port
+}
+
+// replicationLink is the connection of a replica to its primary. It runs in
+// its own goroutine and applies the replication stream through the executor,
+// reconnecting when the connection drops.
+type replicationLink struct {
+	ex     *executor
+	addr   string
+	cancel context.CancelFunc
+
+	// state is one of "connect", "connecting", "sync" and "connected",
+	// like the replica state reported by ROLE.
+	state atomic.Value
+	// offset is the position in the primary's replication stream up to
+	// which commands were applied.
+	offset atomic.Int64
+	// replID is the replication ID of the primary. It's only accessed from
+	// the link goroutine.
+	replID string
+}
+
+func newReplicationLink(ex *executor, addr string) *replicationLink {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &replicationLink{
+		ex:     ex,
+		addr:   addr,
+		cancel: cancel,
+	}
+	l.state.Store("connect")
+
+	go l.run(ctx)
+	return l
+}
+
+func (l *replicationLink) run(ctx context.Context) {
+	// The master client applies the commands of the replication stream. It's
+	// kept across partial resyncs, so that a transaction that was cut off by
+	// a disconnection carries on.
+	var masterClient *client
+
+	for {
+		err := l.sync(ctx, &masterClient)
+		if ctx.Err() != nil {
+			return
+		}
+
+		l.state.Store("connect")
+		slog.Warn("replication link lost, reconnecting", "addr", l.addr, "err", err)
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sync connects to the primary, asks it to resume the replication stream, and
+// applies the stream until the connection fails.
+func (l *replicationLink) sync(ctx context.Context, masterClient **client) error {
+	l.state.Store("connecting")
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return fmt.Errorf("dial primary: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	cr := &countingReader{r: conn}
+	r := bufio.NewReader(cr)
+	request := func(args ...string) (string, error) {
+		rawCmd := make([][]byte, 0, len(args))
+		for _, arg := range args {
+			rawCmd = append(rawCmd, []byte(arg))
+		}
+		_, err := conn.Write(resp.SerializeArray(rawCmd))
+		if err != nil {
+			return "", err
+		}
+
+		line, err := readLine(r)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, "-") {
+			return "", fmt.Errorf("%s: %s", args[0], line[1:])
+		}
+		return line, nil
+	}
+
+	_, err = request("PING")
+	if err != nil {
+		return fmt.Errorf("ping primary: %w", err)
+	}
+	if l.ex.cfg.port != 0 {
+		_, err = request("REPLCONF", "listening-port", strconv.Itoa(l.ex.cfg.port))
+		if err != nil {
+			return fmt.Errorf("send listening port: %w", err)
+		}
+	}
+
+	replID, psyncOffset := "?", "-1"
+	if *masterClient != nil {
+		replID = l.replID
+		psyncOffset = strconv.FormatInt(l.offset.Load()+1, 10)
+	}
+
+	l.state.Store("sync")
+	line, err := request("PSYNC", replID, psyncOffset)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(line[1:])
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid PSYNC reply %q", line)
+	}
+
+	switch fields[0] {
+	case "FULLRESYNC":
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid PSYNC reply %q", line)
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PSYNC reply %q: %w", line, err)
+		}
+
+		err = l.loadSnapshot(r, masterClient)
+		if err != nil {
+			return err
+		}
+
+		l.replID = fields[1]
+		l.offset.Store(offset)
+		slog.Info("full sync with primary finished", "replid", l.replID, "offset", offset)
+	case "CONTINUE":
+		// The primary only sends its replication ID when it changed.
+		if len(fields) == 2 {
+			l.replID = fields[1]
+		}
+		slog.Info("partial resync with primary accepted", "offset", l.offset.Load())
+	default:
+		return fmt.Errorf("invalid PSYNC reply %q", line)
+	}
+
+	l.state.Store("connected")
+
+	ackDone := make(chan struct{})
+	defer close(ackDone)
+	go l.sendAcks(conn, ackDone)
+
+	applied := cr.n - int64(r.Buffered())
+	for {
+		cmd, err := resp.ParseArray(r)
+		if err != nil {
+			return fmt.Errorf("read replication stream: %w", err)
+		}
+
+		l.ex.execute(*masterClient, cmd)
+
+		consumed := cr.n - int64(r.Buffered())
+		l.offset.Add(consumed - applied)
+		applied = consumed
+	}
+}
+
+// loadSnapshot reads the snapshot sent by the primary for a full sync and
+// replaces the dataset with it.
+func (l *replicationLink) loadSnapshot(r *bufio.Reader, masterClient **client) error {
+	var header string
+	var err error
+	// Skip the newlines that Redis sends to keep the connection alive while
+	// it's preparing the snapshot.
+	for header == "" {
+		header, err = readLine(r)
+		if err != nil {
+			return fmt.Errorf("read snapshot header: %w", err)
+		}
+	}
+
+	size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if err != nil || !strings.HasPrefix(header, "$") {
+		return fmt.Errorf("invalid snapshot header %q", header)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	mp, err := readRDB(data)
+	if err != nil {
+		return fmt.Errorf("parse snapshot: %w", err)
+	}
+
+	c := newClient()
+	c.master = true
+	*masterClient = c
+
+	l.ex.tasks <- func() {
+		if l.ex.link != l {
+			return
+		}
+
+		l.ex.store.load(mp)
+		l.ex.masterClient = c
+		// The AOF no longer matches the dataset, so it's rewritten from it.
+		if l.ex.aof != nil && !l.ex.aof.rewriting {
+			err := l.ex.bgRewriteAOF()
+			if err != nil {
+				slog.Error("rewrite aof after full sync failed", "err", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sendAcks periodically reports the applied offset to the primary until done
+// is closed.
+func (l *replicationLink) sendAcks(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(replicaAckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ack := resp.SerializeArray([][]byte{
+				[]byte("REPLCONF"),
+				[]byte("ACK"),
+				strconv.AppendInt(nil, l.offset.Load(), 10),
+			})
+			_, err := conn.Write(ack)
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// readLine reads a line terminated by CRLF or LF, without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
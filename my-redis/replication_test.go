@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplBacklog(t *testing.T) {
+	b := newReplBacklog(4, 10)
+	b.write([]byte("abc"))
+
+	stream, ok := b.since(10)
+	require.True(t, ok)
+	require.Equal(t, "abc", string(stream))
+
+	stream, ok = b.since(13)
+	require.True(t, ok)
+	require.Empty(t, stream)
+
+	_, ok = b.since(14)
+	require.False(t, ok)
+
+	// Once the buffer grows past twice its size, only the last size bytes
+	// are kept.
+	b.write([]byte("defghi"))
+	stream, ok = b.since(15)
+	require.True(t, ok)
+	require.Equal(t, "fghi", string(stream))
+
+	_, ok = b.since(14)
+	require.False(t, ok)
+}
+
+func TestPSync(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	replicaClient := newClient()
+
+	res := string(executeAs(ex, replicaClient, "PSYNC", "?", "-1"))
+	require.Regexp(t, `^\+FULLRESYNC [0-9a-f]{40} 0\r\n$`, res)
+	replID := res[len("+FULLRESYNC ") : len("+FULLRESYNC ")+40]
+
+	require.Equal(t, "+OK\r\n", string(execute(ex, "SET", "foo", "bar")))
+	require.Equal(t, ":1\r\n", string(execute(ex, "RPUSH", "list", "a")))
+
+	// Resuming from the middle of the stream returns the commands that follow.
+	setCmd := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	rpushCmd := "*3\r\n$5\r\nRPUSH\r\n$4\r\nlist\r\n$1\r\na\r\n"
+	offset := strconv.Itoa(len(setCmd) + 1)
+	require.Equal(t, "+CONTINUE "+replID+"\r\n"+rpushCmd,
+		string(executeAs(ex, newClient(), "PSYNC", replID, offset)))
+
+	// An unknown replication ID requires a full sync.
+	res = string(executeAs(ex, newClient(), "PSYNC", "unknown", offset))
+	require.Equal(t, "+FULLRESYNC "+replID+" "+strconv.Itoa(len(setCmd)+len(rpushCmd))+"\r\n", res)
+}
+
+func TestPublishPropagation(t *testing.T) {
+	ex := newExecutor(newStore(), config{})
+	res := string(executeAs(ex, newClient(), "PSYNC", "?", "-1"))
+	replID := res[len("+FULLRESYNC ") : len("+FULLRESYNC ")+40]
+
+	// Messages are sent to the replicas even without subscribers, since the
+	// replicas may have some.
+	require.Equal(t, ":0\r\n", string(execute(ex, "PUBLISH", "news", "hello")))
+	require.Equal(t, "+CONTINUE "+replID+"\r\n*3\r\n$7\r\nPUBLISH\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		string(executeAs(ex, newClient(), "PSYNC", replID, "1")))
+}
+
+func TestReplication(t *testing.T) {
+	primary := newExecutor(newStore(), config{})
+	primaryListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer primaryListener.Close()
+	go serve(primaryListener, primary)
+
+	// The replica connects through a proxy, which lets the test cut the
+	// replication link.
+	proxy := newTestProxy(t, primaryListener.Addr().String())
+	defer proxy.Close()
+
+	require.Equal(t, "+OK\r\n", string(execute(primary, "SET", "before_sync", "1")))
+
+	replica := newExecutor(newStore(), config{})
+	host, port, err := net.SplitHostPort(proxy.Addr().String())
+	require.NoError(t, err)
+	require.Equal(t, "+OK\r\n", string(execute(replica, "REPLICAOF", host, port)))
+
+	eventuallyEqual := func(want string, args ...string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			return string(execute(replica, args...)) == want
+		}, 3*time.Second, 10*time.Millisecond)
+	}
+
+	// The dataset is copied with a full sync, and later writes are streamed.
+	eventuallyEqual("$1\r\n1\r\n", "GET", "before_sync")
+	require.Equal(t, "+OK\r\n", string(execute(primary, "SET", "after_sync", "2")))
+	eventuallyEqual("$1\r\n2\r\n", "GET", "after_sync")
+
+	require.Equal(t, "-READONLY You can't write against a read only replica.\r\n",
+		string(execute(replica, "SET", "foo", "bar")))
+
+	// A replica that briefly disconnects resumes where it left off.
+	proxy.closeConns()
+	require.Equal(t, "+OK\r\n", string(execute(primary, "SET", "while_disconnected", "3")))
+	eventuallyEqual("$1\r\n3\r\n", "GET", "while_disconnected")
+
+	syncs := make(chan [2]int)
+	primary.tasks <- func() {
+		syncs <- [2]int{primary.numFullSyncs, primary.numPartialSyncs}
+	}
+	require.Equal(t, [2]int{1, 1}, <-syncs)
+
+	// After a promotion, the replica accepts writes and no longer follows its
+	// old primary.
+	require.Equal(t, "+OK\r\n", string(execute(replica, "REPLICAOF", "NO", "ONE")))
+	require.Equal(t, "+OK\r\n", string(execute(replica, "SET", "foo", "bar")))
+	require.Equal(t, "+OK\r\n", string(execute(primary, "SET", "after_promotion", "4")))
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, "$-1\r\n", string(execute(replica, "GET", "after_promotion")))
+}
+
+// testProxy forwards TCP connections to a target address.
+type testProxy struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestProxy(t *testing.T, target string) *testProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	p := &testProxy{Listener: l}
+	go func() {
+		for {
+			src, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			dst, err := net.Dial("tcp", target)
+			if err != nil {
+				src.Close()
+				continue
+			}
+
+			p.mu.Lock()
+			p.conns = append(p.conns, src, dst)
+			p.mu.Unlock()
+
+			go io.Copy(dst, src)
+			go io.Copy(src, dst)
+		}
+	}()
+
+	return p
+}
+
+// closeConns closes the proxied connections, while still accepting new ones.
+func (p *testProxy) closeConns() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
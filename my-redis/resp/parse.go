@@ -3,6 +3,8 @@ package resp
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -11,8 +13,21 @@ import (
 const (
 	respTypeArray      = '*'
 	respTypeBulkString = '$'
+
+	// RESP3 types.
+	respTypeNull           = '_'
+	respTypeMap            = '%'
+	respTypeSet            = '~'
+	respTypePush           = '>'
+	respTypeDouble         = ','
+	respTypeBoolean        = '#'
+	respTypeBigNumber      = '('
+	respTypeVerbatimString = '='
 )
 
+// maxInlineLen is the maximum length of an inline command, like in Redis.
+const maxInlineLen = 64 * 1024
+
 // ParseArray consumes RESP-encoded bytes from the given reader and construct an array from it.
 func ParseArray(r *bufio.Reader) ([][]byte, error) {
 	respType, err := r.ReadByte()
@@ -28,6 +43,163 @@ func ParseArray(r *bufio.Reader) ([][]byte, error) {
 	}
 }
 
+// ParseCommand consumes a single command from the given reader. Besides the
+// array of bulk strings that clients send, it accepts inline commands, which
+// are space-separated arguments on a single line, as typed in a telnet
+// session. Empty inline commands are skipped.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#inline-commands
+func ParseCommand(r *bufio.Reader) ([][]byte, error) {
+	for {
+		respType, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if respType == respTypeArray {
+			return readArray(r)
+		}
+
+		err = r.UnreadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line, err := readInlineLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		args, err := splitInlineArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// readInlineLine reads a line terminated by LF or CRLF, without the
+// terminator.
+func readInlineLine(r *bufio.Reader) ([]byte, error) {
+	var retval []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		retval = append(retval, chunk...)
+		if len(retval) > maxInlineLen {
+			return nil, errors.New("Protocol error: too big inline request")
+		}
+		if !isPrefix {
+			return retval, nil
+		}
+	}
+}
+
+// splitInlineArgs splits an inline command into arguments. Like redis-cli,
+// an argument can be wrapped in double quotes, which support escape sequences
+// such as \n and \x41, or in single quotes, which only support \'.
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	var retval [][]byte
+
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return retval, nil
+		}
+
+		// An empty argument, e.g. "", must not be nil, which stands for a
+		// null bulk string in replies.
+		arg := []byte{}
+		switch line[i] {
+		case '"':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errors.New("Protocol error: unbalanced quotes in request")
+				}
+
+				c := line[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					case 'x':
+						if i+2 < len(line) {
+							if b, err := hex.DecodeString(string(line[i+1 : i+3])); err == nil {
+								c = b[0]
+								i += 2
+								break
+							}
+						}
+						c = 'x'
+					default:
+						c = line[i]
+					}
+				}
+
+				arg = append(arg, c)
+				i++
+			}
+		case '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errors.New("Protocol error: unbalanced quotes in request")
+				}
+
+				c := line[i]
+				if c == '\'' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					c = '\''
+				}
+
+				arg = append(arg, c)
+				i++
+			}
+		default:
+			for i < len(line) && !isSpace(line[i]) {
+				arg = append(arg, line[i])
+				i++
+			}
+		}
+
+		// A closing quote must be followed by a space or the end of the line.
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, errors.New("Protocol error: unbalanced quotes in request")
+		}
+
+		retval = append(retval, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
 // readArray consumes resp-array serialized bytes from the given reader
 // and parse it into a Go slice.
 func readArray(r *bufio.Reader) ([][]byte, error) {
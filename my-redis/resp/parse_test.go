@@ -2,6 +2,7 @@ package resp_test
 
 import (
 	"bufio"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -35,3 +36,43 @@ func TestParseUnsupportedType(t *testing.T) {
 		t.Fatal("expected error for unsupported type")
 	}
 }
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected [][]byte
+	}{
+		{"array", "*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n", [][]byte{[]byte("ECHO"), []byte("hello")}},
+		{"inline", "ECHO hello\r\n", [][]byte{[]byte("ECHO"), []byte("hello")}},
+		{"inline with LF only", "PING\n", [][]byte{[]byte("PING")}},
+		{"empty lines are skipped", "\r\n  \r\nPING\r\n", [][]byte{[]byte("PING")}},
+		{"extra spaces", "  SET   foo\tbar \r\n", [][]byte{[]byte("SET"), []byte("foo"), []byte("bar")}},
+		{"double quotes", `SET foo "hello world\n\x41"` + "\r\n",
+			[][]byte{[]byte("SET"), []byte("foo"), []byte("hello world\nA")}},
+		{"single quotes", `SET foo 'it\'s'` + "\r\n", [][]byte{[]byte("SET"), []byte("foo"), []byte("it's")}},
+		{"empty quoted argument", `SET foo ""` + "\r\n", [][]byte{[]byte("SET"), []byte("foo"), {}}},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		got, err := resp.ParseCommand(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParseCommandUnbalancedQuotes(t *testing.T) {
+	for _, input := range []string{`SET foo "bar` + "\r\n", `SET foo "bar"baz` + "\r\n", `SET foo 'bar` + "\r\n"} {
+		r := bufio.NewReader(strings.NewReader(input))
+		_, err := resp.ParseCommand(r)
+		if err == nil || !strings.Contains(err.Error(), "unbalanced quotes") {
+			t.Errorf("%q: expected unbalanced quotes error, got %v", input, err)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package resp
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 )
 
 var NullBulkString = []byte{respTypeBulkString, '-', '1', '\r', '\n'}
 
+var NullArray = []byte{respTypeArray, '-', '1', '\r', '\n'}
+
 // SerializeBulkString encodes the given bytes into a RESP bulk string. If it is null,
 // a serialized null bulk string will be returned.
 //
@@ -49,3 +55,161 @@ func SerializeArray(v [][]byte) []byte {
 	}
 	return retval
 }
+
+// SerializeRawArray creates a RESP-encoded array from elements that are already
+// RESP-encoded, which allows elements of different types.
+func SerializeRawArray(v [][]byte) []byte {
+	retval := fmt.Appendf(nil, "%c%d\r\n", respTypeArray, len(v))
+	for _, elem := range v {
+		retval = append(retval, elem...)
+	}
+	return retval
+}
+
+// Null is the RESP3 null, which replaces both the null bulk string and the
+// null array of RESP2.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#nulls
+var Null = []byte{respTypeNull, '\r', '\n'}
+
+// SerializeMap creates a RESP3 map from a list of alternating keys and values
+// that are already RESP-encoded.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#maps
+func SerializeMap(kv [][]byte) []byte {
+	retval := fmt.Appendf(nil, "%c%d\r\n", respTypeMap, len(kv)/2)
+	for _, elem := range kv {
+		retval = append(retval, elem...)
+	}
+	return retval
+}
+
+// SerializeSet creates a RESP3 set from the given string array.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#sets
+func SerializeSet(v [][]byte) []byte {
+	retval := fmt.Appendf(nil, "%c%d\r\n", respTypeSet, len(v))
+	for _, elem := range v {
+		retval = append(retval, SerializeBulkString(elem)...)
+	}
+	return retval
+}
+
+// SerializePush creates a RESP3 push frame, used for out-of-band data such as
+// pub/sub messages, from elements that are already RESP-encoded.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#pushes
+func SerializePush(v [][]byte) []byte {
+	retval := fmt.Appendf(nil, "%c%d\r\n", respTypePush, len(v))
+	for _, elem := range v {
+		retval = append(retval, elem...)
+	}
+	return retval
+}
+
+// SerializeDouble creates a RESP3 double.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#doubles
+func SerializeDouble(f float64) []byte {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Appendf(nil, "%c%s\r\n", respTypeDouble, s)
+}
+
+// SerializeBoolean creates a RESP3 boolean.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#booleans
+func SerializeBoolean(b bool) []byte {
+	if b {
+		return []byte{respTypeBoolean, 't', '\r', '\n'}
+	}
+	return []byte{respTypeBoolean, 'f', '\r', '\n'}
+}
+
+// SerializeBigNumber creates a RESP3 big number, for integers that don't fit
+// in 64 bits.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#big-numbers
+func SerializeBigNumber(n *big.Int) []byte {
+	return fmt.Appendf(nil, "%c%s\r\n", respTypeBigNumber, n.String())
+}
+
+// SerializeVerbatimString creates a RESP3 verbatim string, which tells the
+// client how to display s. The format is a three-letter type such as "txt"
+// for plain text or "mkd" for markdown.
+//
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#verbatim-strings
+func SerializeVerbatimString(format string, s string) []byte {
+	return fmt.Appendf(nil, "%c%d\r\n%s:%s\r\n", respTypeVerbatimString, len(format)+1+len(s), format, s)
+}
+
+// ReplaceNulls returns reply with every RESP2 null bulk string and null array
+// replaced by the RESP3 null, including the ones nested in aggregates such as
+// the reply of EXEC. reply is returned as is if it isn't a valid frame.
+func ReplaceNulls(reply []byte) []byte {
+	if !bytes.Contains(reply, []byte("-1\r\n")) {
+		return reply
+	}
+	out := make([]byte, 0, len(reply))
+	for rest := reply; len(rest) > 0; {
+		var ok bool
+		out, rest, ok = replaceNulls(out, rest)
+		if !ok {
+			return reply
+		}
+	}
+	return out
+}
+
+// replaceNulls appends the first frame of src to dst with its nulls replaced,
+// and returns the rest of src.
+func replaceNulls(dst, src []byte) ([]byte, []byte, bool) {
+	end := bytes.Index(src, []byte("\r\n"))
+	if end < 1 {
+		return dst, src, false
+	}
+	header, rest := src[:end+2], src[end+2:]
+
+	var children int
+	switch src[0] {
+	case respTypeBulkString, respTypeVerbatimString:
+		n, err := strconv.Atoi(string(src[1:end]))
+		if n == -1 {
+			return append(dst, Null...), rest, true
+		}
+		if err != nil || n < 0 || len(rest) < n+2 {
+			return dst, src, false
+		}
+		return append(append(dst, header...), rest[:n+2]...), rest[n+2:], true
+	case respTypeArray, respTypeSet, respTypePush, respTypeMap:
+		n, err := strconv.Atoi(string(src[1:end]))
+		if n == -1 {
+			return append(dst, Null...), rest, true
+		}
+		if err != nil || n < 0 {
+			return dst, src, false
+		}
+		children = n
+		if src[0] == respTypeMap {
+			children *= 2
+		}
+	}
+
+	dst = append(dst, header...)
+	for range children {
+		var ok bool
+		if dst, rest, ok = replaceNulls(dst, rest); !ok {
+			return dst, src, false
+		}
+	}
+	return dst, rest, true
+}
@@ -1,6 +1,8 @@
 package resp_test
 
 import (
+	"math"
+	"math/big"
 	"reflect"
 	"testing"
 
@@ -27,3 +29,56 @@ func TestSerializeArray(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, got)
 	}
 }
+
+func TestSerializeRESP3Types(t *testing.T) {
+	tests := []struct {
+		name     string
+		got      []byte
+		expected string
+	}{
+		{"null", resp.Null, "_\r\n"},
+		{"map", resp.SerializeMap([][]byte{
+			resp.SerializeBulkString([]byte("proto")),
+			resp.SerializeInteger(3),
+		}), "%1\r\n$5\r\nproto\r\n:3\r\n"},
+		{"set", resp.SerializeSet([][]byte{[]byte("a"), []byte("b")}), "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"push", resp.SerializePush([][]byte{
+			resp.SerializeBulkString([]byte("message")),
+		}), ">1\r\n$7\r\nmessage\r\n"},
+		{"double", resp.SerializeDouble(1.5), ",1.5\r\n"},
+		{"double inf", resp.SerializeDouble(math.Inf(-1)), ",-inf\r\n"},
+		{"double nan", resp.SerializeDouble(math.NaN()), ",nan\r\n"},
+		{"boolean true", resp.SerializeBoolean(true), "#t\r\n"},
+		{"boolean false", resp.SerializeBoolean(false), "#f\r\n"},
+		{"big number", resp.SerializeBigNumber(new(big.Int).Lsh(big.NewInt(1), 64)), "(18446744073709551616\r\n"},
+		{"verbatim string", resp.SerializeVerbatimString("txt", "Some string"), "=15\r\ntxt:Some string\r\n"},
+	}
+
+	for _, tt := range tests {
+		if string(tt.got) != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, tt.got)
+		}
+	}
+}
+
+func TestReplaceNulls(t *testing.T) {
+	tests := []struct {
+		reply    string
+		expected string
+	}{
+		{"$-1\r\n", "_\r\n"},
+		{"*-1\r\n", "_\r\n"},
+		{"$3\r\n-1\r\n\r\n", "$3\r\n-1\r\n\r\n"},
+		{"*3\r\n$-1\r\n*-1\r\n:-1\r\n", "*3\r\n_\r\n_\r\n:-1\r\n"},
+		{"*2\r\n*2\r\n$1\r\na\r\n$-1\r\n%1\r\n$1\r\nk\r\n*-1\r\n", "*2\r\n*2\r\n$1\r\na\r\n_\r\n%1\r\n$1\r\nk\r\n_\r\n"},
+		// Invalid frames are left as they are.
+		{"*2\r\n$-1\r\n", "*2\r\n$-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		got := string(resp.ReplaceNulls([]byte(tt.reply)))
+		if got != tt.expected {
+			t.Errorf("ReplaceNulls(%q): expected %q, got %q", tt.reply, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// set is an unordered collection of unique members.
+//
+// https://redis.io/docs/latest/develop/data-types/sets/
+type set map[string]struct{}
+
+func (st set) clone() any {
+	return maps.Clone(st)
+}
+
+// members returns the members of the set in lexicographical order. Redis
+// doesn't guarantee any order, but a stable one makes replies easier to read.
+func (st set) members() [][]byte {
+	retval := make([][]byte, 0, len(st))
+	for _, member := range slices.Sorted(maps.Keys(st)) {
+		retval = append(retval, []byte(member))
+	}
+	return retval
+}
+
+// sadd adds the given members and returns how many of them were new.
+func (s *store) sadd(key string, members [][]byte) (int, error) {
+	st, exists, err := lookupTyped[set](s, key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		st = set{}
+		s.put(key, entry{val: st})
+	}
+
+	numAdded := 0
+	for _, member := range members {
+		if _, ok := st[string(member)]; !ok {
+			st[string(member)] = struct{}{}
+			numAdded++
+		}
+	}
+	if numAdded > 0 {
+		s.touch(key)
+	}
+
+	return numAdded, nil
+}
+
+func (s *store) smembers(key string) ([][]byte, error) {
+	st, _, err := lookupTyped[set](s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return st.members(), nil
+}
+
+func (s *store) sismember(key string, member string) (bool, error) {
+	st, _, err := lookupTyped[set](s, key)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := st[member]
+	return ok, nil
+}
+
+// sinter returns the members that are in every given set. A missing key is
+// treated as an empty set.
+func (s *store) sinter(keys []string) ([][]byte, error) {
+	sets := make([]set, 0, len(keys))
+	for _, key := range keys {
+		st, _, err := lookupTyped[set](s, key)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, st)
+	}
+
+	// Iterating over the smallest set keeps the number of lookups down.
+	slices.SortFunc(sets, func(a, b set) int {
+		return len(a) - len(b)
+	})
+
+	retval := set{}
+	for member := range sets[0] {
+		inAll := true
+		for _, other := range sets[1:] {
+			if _, ok := other[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			retval[member] = struct{}{}
+		}
+	}
+
+	return retval.members(), nil
+}
+
+// saddCmd handles SADD key member [member ...].
+func (ex *executor) saddCmd(cmd command) []byte {
+	if len(cmd.args) < 2 {
+		return wrongNumArgs("at least 2", len(cmd.args))
+	}
+
+	numAdded, err := ex.store.sadd(string(cmd.args[0]), cmd.args[1:])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if numAdded > 0 {
+		ex.propagate(cmd.name, cmd.args)
+	}
+
+	return resp.SerializeInteger(numAdded)
+}
+
+// smembersCmd handles SMEMBERS key.
+func (ex *executor) smembersCmd(cmd command) []byte {
+	if len(cmd.args) != 1 {
+		return wrongNumArgs("1", len(cmd.args))
+	}
+
+	members, err := ex.store.smembers(string(cmd.args[0]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return setReply(cmd.client, members)
+}
+
+// sismemberCmd handles SISMEMBER key member.
+func (ex *executor) sismemberCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	ok, err := ex.store.sismember(string(cmd.args[0]), string(cmd.args[1]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if ok {
+		return resp.SerializeInteger(1)
+	}
+
+	return resp.SerializeInteger(0)
+}
+
+// sinterCmd handles SINTER key [key ...].
+func (ex *executor) sinterCmd(cmd command) []byte {
+	if len(cmd.args) < 1 {
+		return wrongNumArgs("at least 1", len(cmd.args))
+	}
+
+	keys := make([]string, 0, len(cmd.args))
+	for _, key := range cmd.args {
+		keys = append(keys, string(key))
+	}
+
+	members, err := ex.store.sinter(keys)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return setReply(cmd.client, members)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math/rand/v2"
+)
+
+const (
+	// skipListMaxLevel is enough for 2^64 elements with skipListP = 1/4.
+	skipListMaxLevel = 32
+	// skipListP is the probability of a node having one more level.
+	skipListP = 0.25
+)
+
+// skipList keeps the members of a sorted set ordered by score, then by
+// member, so that lookups by rank and by score range take O(log n). It's a
+// port of Redis's zskiplist: every forward link also records its span, i.e.
+// the number of nodes it skips over, which is what makes rank queries cheap.
+//
+// https://github.com/redis/redis/blob/unstable/src/t_zset.c
+type skipList struct {
+	head   *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	levels   []skipListLevel
+}
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipListNode{levels: make([]skipListLevel, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts strictly before the given element.
+func (n *skipListNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a new element. The caller must make sure that the member isn't
+// already in the list.
+func (sl *skipList) insert(score float64, member string) {
+	var update [skipListMaxLevel]*skipListNode
+	// rank[i] is the number of nodes between the head and update[i].
+	var rank [skipListMaxLevel]int
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkipListLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipListNode{
+		member: member,
+		score:  score,
+		levels: make([]skipListLevel, level),
+	}
+	for i := range level {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// Levels above the new node now skip over one more node.
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes the given element and reports whether it was found.
+func (sl *skipList) delete(score float64, member string) bool {
+	var update [skipListMaxLevel]*skipListNode
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := range sl.level {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.head.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+
+	return true
+}
+
+// rank returns the 1-based rank of the given element, or 0 if it isn't in
+// the list.
+func (sl *skipList) rank(score float64, member string) int {
+	rank := 0
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && (x.levels[i].forward.before(score, member) ||
+			(x.levels[i].forward.score == score && x.levels[i].forward.member == member)) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if x != sl.head && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+// byRank returns the node with the given 1-based rank, or nil if it's out of
+// range.
+func (sl *skipList) byRank(rank int) *skipListNode {
+	traversed := 0
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if traversed == rank && x != sl.head {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// scoreRange is an interval of scores, where either end may be exclusive.
+type scoreRange struct {
+	min, max         float64
+	minExcl, maxExcl bool
+}
+
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minExcl {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxExcl {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// firstInRange returns the first node whose score is within r, or nil if
+// there is none.
+func (sl *skipList) firstInRange(r scoreRange) *skipListNode {
+	if r.min > r.max || (r.min == r.max && (r.minExcl || r.maxExcl)) {
+		return nil
+	}
+	if sl.tail == nil || !r.aboveMin(sl.tail.score) {
+		return nil
+	}
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !r.aboveMin(x.levels[i].forward.score) {
+			x = x.levels[i].forward
+		}
+	}
+
+	x = x.levels[0].forward
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+
+	return x
+}
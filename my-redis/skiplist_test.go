@@ -0,0 +1,70 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipList(t *testing.T) {
+	sl := newSkipList()
+	// expected mirrors the content of the skip list in sorted order.
+	var expected []scoreMember
+
+	compare := func(a, b scoreMember) int {
+		return cmp.Or(cmp.Compare(a.score, b.score), cmp.Compare(a.member, b.member))
+	}
+
+	for i := range 1000 {
+		if len(expected) > 0 && rand.IntN(3) == 0 {
+			idx := rand.IntN(len(expected))
+			require.True(t, sl.delete(expected[idx].score, expected[idx].member))
+			expected = slices.Delete(expected, idx, idx+1)
+			continue
+		}
+
+		elem := scoreMember{score: float64(rand.IntN(100)), member: strconv.Itoa(i)}
+		sl.insert(elem.score, elem.member)
+		idx, _ := slices.BinarySearchFunc(expected, elem, compare)
+		expected = slices.Insert(expected, idx, elem)
+	}
+
+	require.Equal(t, len(expected), sl.length)
+	require.False(t, sl.delete(-1, "missing"))
+
+	for i, elem := range expected {
+		require.Equal(t, i+1, sl.rank(elem.score, elem.member))
+
+		node := sl.byRank(i + 1)
+		require.Equal(t, elem, scoreMember{score: node.score, member: node.member})
+	}
+	require.Nil(t, sl.byRank(len(expected)+1))
+
+	var got []scoreMember
+	for x := sl.tail; x != nil; x = x.backward {
+		got = append(got, scoreMember{score: x.score, member: x.member})
+	}
+	slices.Reverse(got)
+	require.Equal(t, expected, got)
+}
+
+func TestSkipListFirstInRange(t *testing.T) {
+	sl := newSkipList()
+	for i := range 10 {
+		sl.insert(float64(i), strconv.Itoa(i))
+	}
+
+	node := sl.firstInRange(scoreRange{min: 3, max: 5})
+	require.Equal(t, "3", node.member)
+
+	node = sl.firstInRange(scoreRange{min: 3, max: 5, minExcl: true})
+	require.Equal(t, "4", node.member)
+
+	require.Nil(t, sl.firstInRange(scoreRange{min: 3.5, max: 3.9}))
+	require.Nil(t, sl.firstInRange(scoreRange{min: 10, max: 20}))
+	require.Nil(t, sl.firstInRange(scoreRange{min: 5, max: 5, maxExcl: true}))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tuananhlai/prototypes/my-redis/resp"
+)
+
+// sortedSet is a set of unique members ordered by an associated score. Like
+// in Redis, a map gives O(1) score lookups by member, while a skip list keeps
+// the members ordered for rank and range queries.
+//
+// https://redis.io/docs/latest/develop/data-types/sorted-sets/
+type sortedSet struct {
+	scores map[string]float64
+	zsl    *skipList
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		scores: make(map[string]float64),
+		zsl:    newSkipList(),
+	}
+}
+
+func (z *sortedSet) clone() any {
+	retval := newSortedSet()
+	for x := z.zsl.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+		retval.scores[x.member] = x.score
+		retval.zsl.insert(x.score, x.member)
+	}
+	return retval
+}
+
+// set updates the score of member, adding it if needed.
+func (z *sortedSet) set(member string, score float64) {
+	if oldScore, ok := z.scores[member]; ok {
+		z.zsl.delete(oldScore, member)
+	}
+
+	z.scores[member] = score
+	z.zsl.insert(score, member)
+}
+
+// zaddFlags holds the options of a ZADD command.
+type zaddFlags struct {
+	// nx only adds new members, xx only updates existing ones.
+	nx, xx bool
+	// gt and lt only update existing members if the new score is greater or
+	// less than the current one.
+	gt, lt bool
+	// ch counts changed members in addition to added ones in the reply.
+	ch bool
+}
+
+type scoreMember struct {
+	score  float64
+	member string
+}
+
+// zadd adds or updates the given members and returns the number of members
+// that were added, plus the ones whose score changed if flags.ch is set.
+func (s *store) zadd(key string, flags zaddFlags, elems []scoreMember) (int, error) {
+	z, exists, err := lookupTyped[*sortedSet](s, key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if flags.xx {
+			return 0, nil
+		}
+		z = newSortedSet()
+		s.put(key, entry{val: z})
+	}
+
+	numAdded, numUpdated := 0, 0
+	for _, elem := range elems {
+		oldScore, ok := z.scores[elem.member]
+		switch {
+		case !ok && !flags.xx:
+			z.set(elem.member, elem.score)
+			numAdded++
+		case ok && !flags.nx && oldScore != elem.score:
+			if (flags.gt && elem.score <= oldScore) || (flags.lt && elem.score >= oldScore) {
+				continue
+			}
+			z.set(elem.member, elem.score)
+			numUpdated++
+		}
+	}
+
+	// Flags like XX can leave a newly created key empty.
+	if len(z.scores) == 0 {
+		s.del(key)
+	} else if numAdded+numUpdated > 0 {
+		s.touch(key)
+	}
+
+	if flags.ch {
+		return numAdded + numUpdated, nil
+	}
+	return numAdded, nil
+}
+
+// zrange returns the members between the 0-based ranks start and stop, both
+// inclusive. Negative ranks count from the end of the set.
+func (s *store) zrange(key string, start, stop int) ([]scoreMember, error) {
+	z, exists, err := lookupTyped[*sortedSet](s, key)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	length := z.zsl.length
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if start >= length || start > stop {
+		return nil, nil
+	}
+	stop = min(stop, length-1)
+
+	retval := make([]scoreMember, 0, stop-start+1)
+	x := z.zsl.byRank(start + 1)
+	for range stop - start + 1 {
+		retval = append(retval, scoreMember{score: x.score, member: x.member})
+		x = x.levels[0].forward
+	}
+
+	return retval, nil
+}
+
+// zrangeByScore returns the members whose score is within r, skipping the
+// first offset ones and returning at most count members. A negative count
+// means no limit.
+func (s *store) zrangeByScore(key string, r scoreRange, offset, count int) ([]scoreMember, error) {
+	z, exists, err := lookupTyped[*sortedSet](s, key)
+	if err != nil || !exists || offset < 0 {
+		return nil, err
+	}
+
+	var retval []scoreMember
+	x := z.zsl.firstInRange(r)
+	for ; x != nil && offset > 0; offset-- {
+		x = x.levels[0].forward
+	}
+	for ; x != nil && count != 0 && r.belowMax(x.score); count-- {
+		retval = append(retval, scoreMember{score: x.score, member: x.member})
+		x = x.levels[0].forward
+	}
+
+	return retval, nil
+}
+
+// zrank returns the 0-based rank of member, or -1 if it isn't in the set.
+func (s *store) zrank(key string, member string) (int, error) {
+	z, _, err := lookupTyped[*sortedSet](s, key)
+	if err != nil {
+		return 0, err
+	}
+
+	score, ok := z.scores[member]
+	if !ok {
+		return -1, nil
+	}
+
+	return z.zsl.rank(score, member) - 1, nil
+}
+
+// parseScore parses a sorted set score, which may also be -inf or +inf.
+func parseScore(b []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errors.New("value is not a valid float")
+	}
+	return score, nil
+}
+
+// formatScore formats a score the same way Redis does in replies.
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	default:
+		return strconv.AppendFloat(nil, score, 'g', -1, 64)
+	}
+}
+
+// parseScoreRange parses the min and max arguments of ZRANGEBYSCORE. A bound
+// prefixed with "(" is exclusive.
+func parseScoreRange(minArg, maxArg []byte) (r scoreRange, err error) {
+	parseBound := func(b []byte) (score float64, excl bool, err error) {
+		if len(b) > 0 && b[0] == '(' {
+			excl = true
+			b = b[1:]
+		}
+		score, err = parseScore(b)
+		return
+	}
+
+	r.min, r.minExcl, err = parseBound(minArg)
+	if err != nil {
+		return r, errors.New("min or max is not a float")
+	}
+	r.max, r.maxExcl, err = parseBound(maxArg)
+	if err != nil {
+		return r, errors.New("min or max is not a float")
+	}
+
+	return r, nil
+}
+
+// serializeScoreMembers returns the members, optionally followed by their
+// scores. RESP3 clients get a [member, score] pair for each member instead.
+func serializeScoreMembers(c *client, elems []scoreMember, withScores bool) []byte {
+	if withScores && c.protocol == 3 {
+		retval := make([][]byte, 0, len(elems))
+		for _, elem := range elems {
+			retval = append(retval, resp.SerializeRawArray([][]byte{
+				resp.SerializeBulkString([]byte(elem.member)),
+				resp.SerializeDouble(elem.score),
+			}))
+		}
+		return resp.SerializeRawArray(retval)
+	}
+
+	retval := make([][]byte, 0, 2*len(elems))
+	for _, elem := range elems {
+		retval = append(retval, []byte(elem.member))
+		if withScores {
+			retval = append(retval, formatScore(elem.score))
+		}
+	}
+	return resp.SerializeArray(retval)
+}
+
+// zaddCmd handles ZADD key [NX | XX] [GT | LT] [CH] score member [score member ...].
+//
+// https://redis.io/docs/latest/commands/zadd/
+func (ex *executor) zaddCmd(cmd command) []byte {
+	if len(cmd.args) < 3 {
+		return wrongNumArgs("at least 3", len(cmd.args))
+	}
+
+	var flags zaddFlags
+	cur := 1
+parseFlags:
+	for ; cur < len(cmd.args); cur++ {
+		switch strings.ToUpper(string(cmd.args[cur])) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			flags.ch = true
+		default:
+			break parseFlags
+		}
+	}
+
+	if flags.nx && flags.xx {
+		return resp.SerializeSimpleError("XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.lt) || (flags.nx && (flags.gt || flags.lt)) {
+		return resp.SerializeSimpleError("GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	scoreMemberArgs := cmd.args[cur:]
+	if len(scoreMemberArgs) == 0 || len(scoreMemberArgs)%2 != 0 {
+		return resp.SerializeSimpleError("syntax error")
+	}
+
+	elems := make([]scoreMember, 0, len(scoreMemberArgs)/2)
+	for i := 0; i < len(scoreMemberArgs); i += 2 {
+		score, err := parseScore(scoreMemberArgs[i])
+		if err != nil {
+			return resp.SerializeSimpleError(err.Error())
+		}
+		elems = append(elems, scoreMember{score: score, member: string(scoreMemberArgs[i+1])})
+	}
+
+	cnt, err := ex.store.zadd(string(cmd.args[0]), flags, elems)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	ex.propagate(cmd.name, cmd.args)
+
+	return resp.SerializeInteger(cnt)
+}
+
+// zrangeCmd handles ZRANGE key start stop [WITHSCORES].
+func (ex *executor) zrangeCmd(cmd command) []byte {
+	if len(cmd.args) != 3 && len(cmd.args) != 4 {
+		return wrongNumArgs("3 or 4", len(cmd.args))
+	}
+
+	start, err := strconv.Atoi(string(cmd.args[1]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	stop, err := strconv.Atoi(string(cmd.args[2]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	withScores := false
+	if len(cmd.args) == 4 {
+		if !strings.EqualFold(string(cmd.args[3]), "WITHSCORES") {
+			return resp.SerializeSimpleError("syntax error")
+		}
+		withScores = true
+	}
+
+	elems, err := ex.store.zrange(string(cmd.args[0]), start, stop)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return serializeScoreMembers(cmd.client, elems, withScores)
+}
+
+// zrangeByScoreCmd handles ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count].
+func (ex *executor) zrangeByScoreCmd(cmd command) []byte {
+	if len(cmd.args) < 3 {
+		return wrongNumArgs("at least 3", len(cmd.args))
+	}
+
+	r, err := parseScoreRange(cmd.args[1], cmd.args[2])
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	withScores := false
+	offset, count := 0, -1
+	for cur := 3; cur < len(cmd.args); cur++ {
+		switch strings.ToUpper(string(cmd.args[cur])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if cur+2 >= len(cmd.args) {
+				return resp.SerializeSimpleError("syntax error")
+			}
+
+			offset, err = strconv.Atoi(string(cmd.args[cur+1]))
+			if err != nil {
+				return resp.SerializeSimpleError(err.Error())
+			}
+			count, err = strconv.Atoi(string(cmd.args[cur+2]))
+			if err != nil {
+				return resp.SerializeSimpleError(err.Error())
+			}
+			cur += 2
+		default:
+			return resp.SerializeSimpleError("syntax error")
+		}
+	}
+
+	elems, err := ex.store.zrangeByScore(string(cmd.args[0]), r, offset, count)
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+
+	return serializeScoreMembers(cmd.client, elems, withScores)
+}
+
+// zrankCmd handles ZRANK key member.
+func (ex *executor) zrankCmd(cmd command) []byte {
+	if len(cmd.args) != 2 {
+		return wrongNumArgs("2", len(cmd.args))
+	}
+
+	rank, err := ex.store.zrank(string(cmd.args[0]), string(cmd.args[1]))
+	if err != nil {
+		return resp.SerializeSimpleError(err.Error())
+	}
+	if rank < 0 {
+		return resp.NullBulkString
+	}
+
+	return resp.SerializeInteger(rank)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// readChunked decodes a body sent with Transfer-Encoding: chunked. Each chunk
+// starts with its size in hexadecimal, optionally followed by extensions,
+// which are ignored. The last chunk has a size of zero and is followed by the
+// trailers, which are returned as lowercase header names.
+//
+// Example input:
+//
+//	4\r\n
+//	Wiki\r\n
+//	5;name=value\r\n
+//	pedia\r\n
+//	0\r\n
+//	Expires: Wed, 21 Oct 2015 07:28:00 GMT\r\n
+//	\r\n
+//
+// The size of the body and of each line are bounded by limits.
+func readChunked(reader *bufio.Reader, limits Limits) (body []byte, trailers map[string]string, err error) {
+	for {
+		budget := limits.MaxHeaderBytes
+		sizeLine, err := readLine(reader, &budget)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading chunk size: %w", err)
+		}
+
+		size, err := parseChunkSize(sizeLine)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		if size > limits.MaxBodyBytes-int64(len(body)) {
+			return nil, nil, errBodyTooLarge
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, nil, fmt.Errorf("error reading chunk: %w", err)
+		}
+		body = append(body, chunk...)
+
+		// Every chunk is followed by a CRLF.
+		end := make([]byte, 2)
+		if _, err := io.ReadFull(reader, end); err != nil {
+			return nil, nil, fmt.Errorf("error reading chunk: %w", err)
+		}
+		if string(end) != "\r\n" {
+			return nil, nil, badRequest("chunk is longer than its size %d", size)
+		}
+	}
+
+	trailers = make(map[string]string)
+	budget := limits.MaxHeaderBytes
+	for numTrailers := 0; ; numTrailers++ {
+		trailerLine, err := readLine(reader, &budget)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading trailer: %w", err)
+		}
+		if trailerLine == "\r\n" || trailerLine == "\n" {
+			break
+		}
+		if numTrailers == limits.MaxHeaders {
+			return nil, nil, errTooManyHeaders
+		}
+
+		key, value, err := parseHeader(trailerLine)
+		if err != nil {
+			return nil, nil, err
+		}
+		trailers[key] = value
+	}
+
+	return body, trailers, nil
+}
+
+// parseChunkSize ...
+// Example input: 1a;name=value\r\n
+func parseChunkSize(sizeLine string) (int64, error) {
+	size, _, _ := strings.Cut(strings.TrimRight(sizeLine, "\r\n"), ";")
+	size = strings.TrimSpace(size)
+
+	n, err := strconv.ParseInt(size, 16, 64)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid chunk size: %q", size)
+	}
+	return n, nil
+}
+
+// ChunkedWriter streams a response body as chunks, so that the size of the
+// body doesn't need to be known before writing it. Each call to Write sends a
+// chunk, and Close sends the last chunk along with the trailers.
+type ChunkedWriter struct {
+	w        *bufio.Writer
+	trailers map[string]string
+	closed   bool
+}
+
+// writeChunkedResponse writes the status line and headers of a response
+// whose body is streamed with the returned writer, which must be closed once
+// the body is complete.
+func writeChunkedResponse(w *bufio.Writer, status int, header Header, keepAlive bool) (*ChunkedWriter, error) {
+	header = maps.Clone(header)
+	header.Del("Content-Length")
+	header.Set("Transfer-Encoding", "chunked")
+	writeHead(w, status, header, keepAlive)
+
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing response: %w", err)
+	}
+	return &ChunkedWriter{w: w, trailers: make(map[string]string)}, nil
+}
+
+// Write sends p as a single chunk. Empty writes are skipped, since an empty
+// chunk would end the body.
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, fmt.Errorf("write to a closed chunked writer")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	fmt.Fprintf(cw.w, "%x\r\n", len(p))
+	cw.w.Write(p)
+	cw.w.WriteString("\r\n")
+	if err := cw.w.Flush(); err != nil {
+		return 0, fmt.Errorf("error writing chunk: %w", err)
+	}
+	return len(p), nil
+}
+
+// SetTrailer sets a trailer sent after the last chunk.
+func (cw *ChunkedWriter) SetTrailer(key, value string) {
+	cw.trailers[key] = value
+}
+
+// Close writes the last chunk and the trailers. They're sent once the
+// underlying writer is flushed.
+func (cw *ChunkedWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	cw.w.WriteString("0\r\n")
+	for key, value := range cw.trailers {
+		fmt.Fprintf(cw.w, "%s: %s\r\n", key, value)
+	}
+	_, err := cw.w.WriteString("\r\n")
+	if err != nil {
+		return fmt.Errorf("error writing last chunk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadChunked(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		body     string
+		trailers map[string]string
+	}{
+		{"chunks", "4\r\nWiki\r\n5\r\npedia\r\n0\r\n\r\n", "Wikipedia", map[string]string{}},
+		{"extensions", "4;name=value\r\nWiki\r\n0;last\r\n\r\n", "Wiki", map[string]string{}},
+		{"hex size", "a\r\n0123456789\r\n0\r\n\r\n", "0123456789", map[string]string{}},
+		{"uppercase hex size", "A\r\n0123456789\r\n0\r\n\r\n", "0123456789", map[string]string{}},
+		{"empty body", "0\r\n\r\n", "", map[string]string{}},
+		{"trailers", "4\r\nWiki\r\n0\r\nExpires: never\r\nX-Sum: 42\r\n\r\n", "Wiki",
+			map[string]string{"expires": "never", "x-sum": "42"}},
+		{"LF only after trailers", "4\r\nWiki\r\n0\r\n\n", "Wiki", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		body, trailers, err := readChunked(r, defaultLimits)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.name, tt.body, body)
+		}
+		if !reflect.DeepEqual(trailers, tt.trailers) {
+			t.Errorf("%s: expected trailers %v, got %v", tt.name, tt.trailers, trailers)
+		}
+	}
+}
+
+func TestReadChunkedErrors(t *testing.T) {
+	limits := defaultLimits
+	limits.MaxBodyBytes = 8
+	limits.MaxHeaderBytes = 32
+	limits.MaxHeaders = 2
+
+	tests := []struct {
+		name   string
+		input  string
+		status int
+	}{
+		{"invalid size", "xyz\r\n", 400},
+		{"negative size", "-1\r\n", 400},
+		{"chunk longer than its size", "2\r\nabc\r\n0\r\n\r\n", 400},
+		{"body over the limit", "5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n", 413},
+		{"huge size", "7fffffffffffffff\r\n", 413},
+		{"size line over the limit", "4;" + strings.Repeat("x", 40) + "\r\nWiki\r\n0\r\n\r\n", 431},
+		{"trailers over the limit", "0\r\nA: " + strings.Repeat("x", 40) + "\r\n\r\n", 431},
+		{"too many trailers", "0\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", 431},
+		{"truncated chunk", "4\r\nWi", 0},
+		{"missing last chunk", "4\r\nWiki\r\n", 0},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		_, _, err := readChunked(r, limits)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		var reqErr *requestError
+		status := 0
+		if errors.As(err, &reqErr) {
+			status = reqErr.status
+		}
+		if status != tt.status {
+			t.Errorf("%s: expected status %d, got %d (%v)", tt.name, tt.status, status, err)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sniffLen is the number of bytes used to detect the content type of a
+	// file without a known extension.
+	sniffLen = 512
+	// maxRanges bounds the number of ranges of a request, since each range
+	// has its own part in the response.
+	maxRanges = 16
+	// minGzipSize is the size under which files aren't compressed, since the
+	// gzip header would outweigh the savings.
+	minGzipSize = 1024
+)
+
+// FileServer serves the files under root. It must be registered on a pattern
+// ending with {path...}, which holds the path of the file relative to root.
+//
+// Directories are served by their index.html, or listed. Files support Range
+// requests, revalidation with If-None-Match and If-Modified-Since, and gzip
+// compression of text files.
+type FileServer struct {
+	root string
+}
+
+func NewFileServer(root string) *FileServer {
+	return &FileServer{root: root}
+}
+
+func (fsrv *FileServer) ServeHTTP(w ResponseWriter, r *Request) {
+	// The path is already percent-decoded. Cleaning it as an absolute path
+	// removes the .. segments, so the file can't be outside root.
+	name := path.Clean("/" + r.PathValue("path"))
+	fullPath := filepath.Join(fsrv.root, filepath.FromSlash(name))
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeError(w, 404, "not found")
+			return
+		}
+		writeError(w, 500, "error reading file")
+		return
+	}
+
+	if info.IsDir() {
+		// Relative links in the directory only work with a trailing slash.
+		if !strings.HasSuffix(r.Path(), "/") {
+			w.Header().Set("Location", (&url.URL{Path: r.Path() + "/"}).EscapedPath())
+			w.WriteHeader(301)
+			return
+		}
+
+		index := filepath.Join(fullPath, "index.html")
+		if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+			serveFile(w, r, index, indexInfo)
+			return
+		}
+		serveDir(w, r, fullPath, name)
+		return
+	}
+
+	serveFile(w, r, fullPath, info)
+}
+
+func writeError(w ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(msg))
+}
+
+// serveDir lists the entries of dir, directories first. name is the path of
+// dir relative to the root.
+func serveDir(w ResponseWriter, r *Request, dir, name string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		writeError(w, 500, "error reading directory")
+		return
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		if a.IsDir() != b.IsDir() {
+			if a.IsDir() {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	title := html.EscapeString(r.Path())
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n", title)
+	fmt.Fprintf(w, "<h1>Index of %s</h1>\n<ul>\n", title)
+	if name != "/" {
+		fmt.Fprintf(w, "<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		// A ./ prefix keeps a name with a colon from being read as a scheme.
+		link := "./" + (&url.URL{Path: entryName}).EscapedPath()
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(entryName))
+	}
+	fmt.Fprintf(w, "</ul>\n</body>\n</html>\n")
+}
+
+// serveFile serves the file at name, whose info is given.
+func serveFile(w ResponseWriter, r *Request, name string, info fs.FileInfo) {
+	f, err := os.Open(name)
+	if err != nil {
+		writeError(w, 500, "error opening file")
+		return
+	}
+	defer f.Close()
+
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(304)
+		return
+	}
+
+	contentType, err := detectContentType(f, name)
+	if err != nil {
+		writeError(w, 500, "error reading file")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	size := info.Size()
+	rangeHeader := r.Header("Range")
+	if rangeHeader != "" && ifRangeMatches(r, etag, modTime) {
+		ranges, err := parseRanges(rangeHeader, size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, 416, err.Error())
+			return
+		}
+		if ranges != nil {
+			serveRanges(w, r, f, ranges, size, contentType)
+			return
+		}
+	}
+
+	if size >= minGzipSize && compressible(contentType) && acceptsGzip(r) {
+		// The compressed body has another representation, so it needs its
+		// own tag. Its size isn't known up front, so it's streamed.
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(200)
+		if err := w.Flush(); err != nil || r.Method() == MethodHead {
+			return
+		}
+		// Every write is a chunk, so the small writes of gzip are buffered.
+		bw := bufio.NewWriterSize(w, 32<<10)
+		gz := gzip.NewWriter(bw)
+		io.Copy(gz, f)
+		gz.Close()
+		bw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(200)
+	if err := w.Flush(); err != nil || r.Method() == MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
+
+// notModified reports whether the client's copy of the file is up to date.
+// If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *Request, etag string, modTime time.Time) bool {
+	if r.Method() != MethodGet && r.Method() != MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header("If-None-Match"); ifNoneMatch != "" {
+		for tag := range strings.SplitSeq(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			// The tag of the compressed body matches too, since both are
+			// current.
+			if tag == "*" || tag == etag || tag == strings.TrimSuffix(etag, `"`)+`-gzip"` {
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header("If-Modified-Since")); err == nil {
+		return !modTime.After(since)
+	}
+	return false
+}
+
+// ifRangeMatches reports whether the Range header applies, i.e. If-Range is
+// missing or still matches the file. Otherwise the whole file is sent.
+func ifRangeMatches(r *Request, etag string, modTime time.Time) bool {
+	ifRange := r.Header("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	if t, err := http.ParseTime(ifRange); err == nil {
+		return modTime.Equal(t)
+	}
+	return false
+}
+
+// detectContentType returns the content type of a file from its extension,
+// or from its first bytes if the extension is unknown.
+func detectContentType(f *os.File, name string) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.HasPrefix(mediaType, "text/") || slices.Contains([]string{
+		"application/javascript",
+		"application/json",
+		"application/xml",
+		"image/svg+xml",
+	}, mediaType)
+}
+
+// acceptsGzip reports whether the client accepts gzip, i.e. lists it in
+// Accept-Encoding without q=0.
+func acceptsGzip(r *Request) bool {
+	for coding := range strings.SplitSeq(r.Header("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// byteRange is a range of bytes of a file, from start to end, inclusive.
+type byteRange struct {
+	start, end int64
+}
+
+func (br byteRange) length() int64 {
+	return br.end - br.start + 1
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, size)
+}
+
+// parseRanges parses a Range header for a file of the given size. Ranges past
+// the end of the file are dropped, and an error is returned if none is left.
+// Overlapping and adjacent ranges are merged, sorted by offset, so that a
+// client can't make the server send the same bytes many times.
+// It returns nil ranges if the header must be ignored, e.g. for an unknown
+// unit.
+// Example input: bytes=0-99, 200-, -50
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		var br byteRange
+		if first == "" {
+			// A suffix range holds the last bytes of the file.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			br = byteRange{start: max(size-n, 0), end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, end: min(end, size-1)}
+		}
+		ranges = append(ranges, br)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("range not satisfiable")
+	}
+	ranges = mergeRanges(ranges)
+	if len(ranges) > maxRanges {
+		// Serving the whole file is cheaper than many small parts.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// mergeRanges sorts ranges by start, and merges the ones that overlap or are
+// adjacent.
+func mergeRanges(ranges []byteRange) []byteRange {
+	slices.SortFunc(ranges, func(a, b byteRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	merged := ranges[:1]
+	for _, br := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if br.start <= last.end+1 {
+			last.end = max(last.end, br.end)
+			continue
+		}
+		merged = append(merged, br)
+	}
+	return merged
+}
+
+// serveRanges replies 206 with the requested ranges of f, in a
+// multipart/byteranges body if there are several.
+func serveRanges(w ResponseWriter, r *Request, f *os.File, ranges []byteRange, size int64, contentType string) {
+	if len(ranges) == 1 {
+		br := ranges[0]
+		w.Header().Set("Content-Range", br.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(br.length(), 10))
+		w.WriteHeader(206)
+		if err := w.Flush(); err != nil || r.Method() == MethodHead {
+			return
+		}
+		io.Copy(w, io.NewSectionReader(f, br.start, br.length()))
+		return
+	}
+
+	// The headers of the parts are known up front, so the length of the body
+	// is too, and the parts are streamed from the file.
+	boundary := newBoundary()
+	heads := make([]string, len(ranges))
+	length := int64(0)
+	for i, br := range ranges {
+		heads[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, br.contentRange(size))
+		length += int64(len(heads[i])) + br.length()
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length += int64(len(tail))
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(206)
+	if err := w.Flush(); err != nil || r.Method() == MethodHead {
+		return
+	}
+	for i, br := range ranges {
+		io.WriteString(w, heads[i])
+		if _, err := io.Copy(w, io.NewSectionReader(f, br.start, br.length())); err != nil {
+			// The body falls short of its length, so the connection is
+			// closed.
+			return
+		}
+	}
+	io.WriteString(w, tail)
+}
+
+func newBoundary() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
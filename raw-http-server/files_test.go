@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveFiles parses the raw request and serves it with a FileServer of root,
+// returning the raw response.
+func serveFiles(t *testing.T, root, rawRequest string) string {
+	t.Helper()
+	req, err := parse(bufio.NewReader(strings.NewReader(rawRequest)), defaultLimits)
+	if err != nil {
+		t.Fatalf("parse(%q): %v", rawRequest, err)
+	}
+
+	router := NewRouter()
+	router.Handle("GET /files/{path...}", NewFileServer(root))
+	var out strings.Builder
+	bw := bufio.NewWriter(&out)
+	w := newResponse(bw, req, false)
+	router.ServeHTTP(w, req)
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	bw.Flush()
+	return out.String()
+}
+
+func TestFileServerEscapedPath(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "my dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "my dir", "a b.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := serveFiles(t, root, "GET /files/my%20dir/a%20b.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(resp, "\r\n\r\nhello") {
+		t.Errorf("expected the file, got %q", resp)
+	}
+
+	// The links of the listing lead back to the file.
+	resp = serveFiles(t, root, "GET /files/my%20dir/ HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.Contains(resp, `<a href="./a%20b.txt">a b.txt</a>`) {
+		t.Errorf("expected a link to a b.txt, got %q", resp)
+	}
+
+	resp = serveFiles(t, root, "GET /files/my%20dir HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.Contains(resp, "Location: /files/my%20dir/\r\n") {
+		t.Errorf("expected a redirect to the escaped directory, got %q", resp)
+	}
+}
+
+func TestParseRejectsEscapedPath(t *testing.T) {
+	for _, path := range []string{"/files/..%2F..%2Fetc/passwd", "/files/a.txt%00", "/files/%zz"} {
+		input := "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
+		_, err := parse(bufio.NewReader(strings.NewReader(input)), defaultLimits)
+		if reqErr, ok := err.(*requestError); !ok || reqErr.status != 400 {
+			t.Errorf("parse of %s returned %v, want a 400 error", path, err)
+		}
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		ranges []byteRange
+	}{
+		{"first bytes", "bytes=0-99", 1000, []byteRange{{0, 99}}},
+		{"open range", "bytes=900-", 1000, []byteRange{{900, 999}}},
+		{"suffix range", "bytes=-50", 1000, []byteRange{{950, 999}}},
+		{"suffix longer than the file", "bytes=-5000", 1000, []byteRange{{0, 999}}},
+		{"end past the file", "bytes=900-5000", 1000, []byteRange{{900, 999}}},
+		{"several ranges", "bytes=0-9, 20-29,-5", 1000, []byteRange{{0, 9}, {20, 29}, {995, 999}}},
+		{"range past the file dropped", "bytes=0-9,2000-3000", 1000, []byteRange{{0, 9}}},
+		{"unknown unit", "items=0-9", 1000, nil},
+		{"missing dash", "bytes=10", 1000, nil},
+		{"end before start", "bytes=10-5", 1000, nil},
+		{"invalid number", "bytes=a-5", 1000, nil},
+		{"unsorted ranges", "bytes=20-29,0-9", 1000, []byteRange{{0, 9}, {20, 29}}},
+		{"overlapping ranges", "bytes=0-9,5-14,-5", 1000, []byteRange{{0, 14}, {995, 999}}},
+		{"adjacent ranges", "bytes=0-9,10-19,21-", 1000, []byteRange{{0, 19}, {21, 999}}},
+		{"contained range", "bytes=0-99,10-19", 1000, []byteRange{{0, 99}}},
+		{"repeated ranges merged", "bytes=" + strings.Repeat("0-999,", 100), 1000, []byteRange{{0, 999}}},
+		{"too many ranges", "bytes=" + tooManyRanges(), 1000, nil},
+	}
+
+	for _, tt := range tests {
+		ranges, err := parseRanges(tt.header, tt.size)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ranges, tt.ranges) {
+			t.Errorf("%s: parseRanges(%q, %d) = %v, want %v", tt.name, tt.header, tt.size, ranges, tt.ranges)
+		}
+	}
+}
+
+// tooManyRanges returns maxRanges+1 ranges that can't be merged.
+func tooManyRanges() string {
+	var parts []string
+	for i := range maxRanges + 1 {
+		parts = append(parts, fmt.Sprintf("%d-%d", i*10, i*10+1))
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestParseRangesNotSatisfiable(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+	}{
+		{"bytes=1000-", 1000},
+		{"bytes=2000-3000", 1000},
+		{"bytes=-0", 1000},
+		{"bytes=0-9", 0},
+		{"bytes=-10", 0},
+		{"bytes=", 1000},
+	}
+
+	for _, tt := range tests {
+		if ranges, err := parseRanges(tt.header, tt.size); err == nil {
+			t.Errorf("parseRanges(%q, %d) = %v, want an error", tt.header, tt.size, ranges)
+		}
+	}
+}
+
+func TestFileServerMultipartRanges(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("0123456789abcdefghij"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := serveFiles(t, root, "GET /files/a.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=15-,0-2,1-4\r\n\r\n")
+	head, body, ok := strings.Cut(resp, "\r\n\r\n")
+	if !ok || !strings.HasPrefix(head, "HTTP/1.1 206 Partial Content\r\n") {
+		t.Fatalf("expected a 206 response, got %q", resp)
+	}
+	if want := fmt.Sprintf("Content-Length: %d\r\n", len(body)); !strings.Contains(head+"\r\n", want) {
+		t.Errorf("expected %q in the headers of a %d bytes body, got %q", want, len(body), head)
+	}
+	// The overlapping ranges are merged into a single part.
+	if strings.Count(body, "Content-Range:") != 2 {
+		t.Errorf("expected 2 parts, got %q", body)
+	}
+	for _, part := range []string{"Content-Range: bytes 0-4/20\r\n\r\n01234\r\n", "Content-Range: bytes 15-19/20\r\n\r\nfghij\r\n"} {
+		if !strings.Contains(body, part) {
+			t.Errorf("expected part %q, got %q", part, body)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/textproto"
+	"strconv"
+)
+
+// Handler responds to a request.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Header holds the headers of a response. Keys are canonicalized, e.g.
+// content-type becomes Content-Type.
+type Header map[string][]string
+
+func (h Header) Get(key string) string {
+	values := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// ResponseWriter builds the response to a request. The response is buffered
+// and sent with a Content-Length once the handler returns, unless the handler
+// calls Flush to stream it.
+type ResponseWriter interface {
+	// Header returns the headers sent with the response. Changes after the
+	// response was flushed have no effect.
+	Header() Header
+	// WriteHeader sets the status code of the response. Only the first call
+	// has an effect, and Write calls it with 200 if it wasn't called.
+	WriteHeader(status int)
+	Write(p []byte) (int, error)
+	// Flush sends what was written so far, and streams the rest of the body
+	// with chunked transfer encoding.
+	Flush() error
+	// Reset discards the status, headers and body written so far. It reports
+	// false if they were already sent by Flush.
+	Reset() bool
+}
+
+// response is the ResponseWriter of a request read from a connection.
+type response struct {
+	w *bufio.Writer
+	// proto is the version of the request.
+	proto string
+	// head is set for HEAD requests, whose response has no body.
+	head      bool
+	keepAlive bool
+
+	header Header
+	status int
+	body   bytes.Buffer
+	// stream sends the body once the response was flushed.
+	stream io.WriteCloser
+}
+
+// newResponse returns the response to a request, written to w. keepAlive
+// reports whether the connection stays open after the response.
+func newResponse(w *bufio.Writer, req *Request, keepAlive bool) *response {
+	return &response{
+		w:         w,
+		proto:     req.Proto(),
+		head:      req.Method() == MethodHead,
+		keepAlive: keepAlive,
+		header:    make(Header),
+	}
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	w.WriteHeader(200)
+	if w.stream != nil {
+		return w.stream.Write(p)
+	}
+	return w.body.Write(p)
+}
+
+// Flush sends the headers and streams the rest of the body. If the handler
+// set Content-Length, the body is sent as is. Otherwise it's sent in chunks,
+// except to HTTP/1.0 clients, which don't support chunked encoding, so the
+// end of the body is marked by closing the connection.
+func (w *response) Flush() error {
+	w.WriteHeader(200)
+	if w.stream != nil {
+		return nil
+	}
+
+	contentLength := w.header.Get("Content-Length")
+	switch {
+	case w.head || !bodyAllowed(w.status):
+		writeHead(w.w, w.status, w.header, !w.closeConnection())
+		w.stream = &identityWriter{w: io.Discard, length: -1}
+	case contentLength != "":
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid content length: %s", contentLength)
+		}
+		header := maps.Clone(w.header)
+		header.Del("Transfer-Encoding")
+		writeHead(w.w, w.status, header, !w.closeConnection())
+		w.stream = &identityWriter{w: w.w, length: length}
+	case w.proto == "HTTP/1.0":
+		w.keepAlive = false
+		header := maps.Clone(w.header)
+		header.Del("Transfer-Encoding")
+		writeHead(w.w, w.status, header, false)
+		w.stream = &identityWriter{w: w.w, length: -1}
+	default:
+		var err error
+		w.stream, err = writeChunkedResponse(w.w, w.status, w.header, !w.closeConnection())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.stream.Write(w.body.Bytes())
+	w.body.Reset()
+	if err == nil {
+		err = w.w.Flush()
+	}
+	return err
+}
+
+func (w *response) Reset() bool {
+	if w.stream != nil {
+		return false
+	}
+	w.header = make(Header)
+	w.status = 0
+	w.body.Reset()
+	return true
+}
+
+// finish writes the response, or ends the body if it was flushed. The
+// response stays buffered in w, so that the responses to pipelined requests
+// can be sent together.
+func (w *response) finish() error {
+	if w.stream != nil {
+		return w.stream.Close()
+	}
+	w.WriteHeader(200)
+	if w.head {
+		// The body of a HEAD response is omitted, but its length is sent
+		// unless the handler set it.
+		header := maps.Clone(w.header)
+		if header.Get("Content-Length") == "" && bodyAllowed(w.status) {
+			header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		}
+		writeHead(w.w, w.status, header, !w.closeConnection())
+		return nil
+	}
+	return writeResponse(w.w, w.status, w.header, w.body.Bytes(), !w.closeConnection())
+}
+
+// bodyAllowed reports whether a response with status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+// closeConnection reports whether the connection must be closed after the
+// response, because the client or the handler asked for it.
+func (w *response) closeConnection() bool {
+	return !w.keepAlive || hasToken(w.header.Get("Connection"), "close")
+}
+
+// identityWriter streams a body as is, flushing every write. When length
+// isn't -1, the body must have exactly length bytes, since the client relies
+// on it to find the end of the body.
+type identityWriter struct {
+	w       io.Writer
+	length  int64
+	written int64
+}
+
+func (iw *identityWriter) Write(p []byte) (int, error) {
+	if iw.length >= 0 && iw.written+int64(len(p)) > iw.length {
+		return 0, fmt.Errorf("response body is longer than its content length %d", iw.length)
+	}
+
+	n, err := iw.w.Write(p)
+	iw.written += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("error writing response: %w", err)
+	}
+	if bw, ok := iw.w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err != nil {
+			return n, fmt.Errorf("error writing response: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// Close checks that the body has the announced length.
+func (iw *identityWriter) Close() error {
+	if iw.length >= 0 && iw.written != iw.length {
+		return fmt.Errorf("response body has %d bytes, but its content length is %d", iw.written, iw.length)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+)
+
+// Limits bounds the resources used by a connection, so that a client can't
+// make the server buffer an unbounded request or hold a connection forever.
+type Limits struct {
+	// MaxHeaderBytes bounds the size of the request line and the headers, and
+	// separately the size of the trailers.
+	MaxHeaderBytes int
+	// MaxHeaders bounds the number of headers, and separately the number of
+	// trailers.
+	MaxHeaders int
+	// MaxBodyBytes bounds the size of the body, after decoding chunks.
+	MaxBodyBytes int64
+	// IdleTimeout is how long the server waits for the next request on a
+	// persistent connection.
+	IdleTimeout time.Duration
+	// ReadTimeout is how long the server waits for the rest of a request once
+	// it started.
+	ReadTimeout time.Duration
+}
+
+var defaultLimits = Limits{
+	MaxHeaderBytes: 64 << 10,
+	MaxHeaders:     100,
+	MaxBodyBytes:   10 << 20,
+	IdleTimeout:    10 * time.Second,
+	ReadTimeout:    10 * time.Second,
+}
+
+// requestError is an invalid request, which is answered with status before
+// the connection is closed.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+var (
+	errHeaderTooLarge = &requestError{status: 431, msg: "request header too large"}
+	errTooManyHeaders = &requestError{status: 431, msg: "too many request headers"}
+	errBodyTooLarge   = &requestError{status: 413, msg: "request body too large"}
+)
+
+func badRequest(format string, args ...any) error {
+	return &requestError{status: 400, msg: fmt.Sprintf(format, args...)}
+}
+
+// readLine reads a line ending with \n, counting its size against budget, the
+// number of bytes the line may use. It returns errHeaderTooLarge once the
+// budget is exceeded, without buffering the rest of the line.
+func readLine(reader *bufio.Reader, budget *int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > *budget {
+			return "", errHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		*budget -= len(line)
+		return string(line), nil
+	}
+}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,83 +26,90 @@ const (
 )
 
 func main() {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	certFile := flag.String("tls-cert", "", "path of the TLS certificate, which enables TLS along with -tls-key")
+	keyFile := flag.String("tls-key", "", "path of the TLS private key")
+	grace := flag.Duration("grace", 30*time.Second, "how long in-flight requests may take to finish on shutdown")
+	root := flag.String("root", "public", "directory of the files served under /files/")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	log.Println("server started on", addr)
-	for {
-		conn, err := listener.AcceptTCP()
+	if *certFile != "" || *keyFile != "" {
+		tlsConfig, err := newTLSConfig(*certFile, *keyFile)
 		if err != nil {
-			log.Println("error accepting connection:", err)
-			continue
+			log.Fatal("error loading TLS certificate: ", err)
 		}
-
-		log.Println("connection accepted")
-		go handleConnection(conn)
+		listener = tls.NewListener(listener, tlsConfig)
 	}
-}
 
-func handleConnection(conn *net.TCPConn) {
-	// Set a read deadline to prevent the connection from being kept open
-	// indefinitely when the client stops sending requests.
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	server := NewServer(Chain(newApp(*root), Logging, Recovery), defaultLimits)
 
-	defer func() {
-		err := conn.Close()
-		if err != nil {
-			log.Println("error closing connection:", err)
-		}
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	r := bufio.NewReader(conn)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	log.Println("server started on", addr)
 
-	for {
-		req, err := parse(r)
-		if err != nil {
-			// EOF means client closed the connection
-			if err == io.EOF {
-				log.Println("client closed connection")
-			} else {
-				log.Println("error parsing request:", err)
-			}
-			return
-		}
-		log.Printf("request: %+v, body: %s", req, string(req.Body()))
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
 
-		// In HTTP/1.1, keep-alive is default unless Connection: close is present
-		connection := req.Header("connection")
-		keepAlive := connection == "keep-alive"
+	log.Printf("shutting down, waiting up to %s for in-flight requests", *grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("error shutting down:", err)
+		return
+	}
+	log.Println("server stopped")
+}
 
-		// Write response with appropriate Connection header
-		err = writeResponse(conn, 200, []byte("OK"), keepAlive)
-		if err != nil {
-			log.Println("error writing response:", err)
-			return
+// newApp returns the routes served by the example app, including the files
+// under root.
+func newApp(root string) Handler {
+	router := NewRouter()
+	router.Handle("GET /files/{path...}", NewFileServer(root))
+	router.HandleFunc("GET /", func(w ResponseWriter, r *Request) {
+		w.Write([]byte("OK"))
+	})
+	router.Handle("GET /hello/{name}", Timeout(5*time.Second)(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "hello, %s", r.PathValue("name"))
+	})))
+	router.HandleFunc("POST /echo", func(w ResponseWriter, r *Request) {
+		if contentType := r.Header("Content-Type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
 		}
-
-		// If client explicitly requested close, close the connection
-		if connection == "close" {
-			return
+		w.Write(r.Body())
+	})
+	// The body is streamed in chunks, since its size isn't known up front.
+	router.HandleFunc("GET /stream", func(w ResponseWriter, r *Request) {
+		for i := range 5 {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
 		}
-
-		// For keep-alive, continue to read the next request
-		// The connection will be closed if:
-		// 1. Client sends Connection: close
-		// 2. Client closes the connection (EOF)
-		// 3. An error occurs during parsing
-	}
+	})
+	router.HandleFunc("GET /panic", func(w ResponseWriter, r *Request) {
+		panic("something went wrong")
+	})
+	return router
 }
 
 type Method string
 
 const (
 	MethodGet     Method = "GET"
+	MethodHead    Method = "HEAD"
 	MethodPost    Method = "POST"
 	MethodPut     Method = "PUT"
 	MethodDelete  Method = "DELETE"
@@ -103,6 +119,7 @@ const (
 
 var validMethods = map[Method]bool{
 	MethodGet:     true,
+	MethodHead:    true,
 	MethodPost:    true,
 	MethodPut:     true,
 	MethodDelete:  true,
@@ -111,16 +128,44 @@ var validMethods = map[Method]bool{
 }
 
 type Request struct {
-	method  Method
-	path    string
+	ctx    context.Context
+	method Method
+	path   string
+	query  string
+	// proto is the version of the request, HTTP/1.0 or HTTP/1.1.
+	proto   string
 	headers map[string]string
 	body    []byte
+	// trailers holds the headers sent after a chunked body.
+	trailers map[string]string
+	// params holds the path parameters of the route matching the request.
+	params map[string]string
+}
+
+// Context returns the context of the request, which is canceled when the
+// request times out.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a copy of the request with its context set to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
 }
 
 func (r *Request) Header(key string) string {
 	return r.headers[strings.ToLower(key)]
 }
 
+func (r *Request) Trailer(key string) string {
+	return r.trailers[strings.ToLower(key)]
+}
+
 func (r *Request) Method() Method {
 	return r.method
 }
@@ -129,28 +174,73 @@ func (r *Request) Body() []byte {
 	return r.body
 }
 
+// Path returns the path of the request, percent-decoded.
 func (r *Request) Path() string {
 	return r.path
 }
 
-// parse transforms the raw HTTP request into a Request object.
-func parse(reader *bufio.Reader) (*Request, error) {
+func (r *Request) Proto() string {
+	return r.proto
+}
+
+// KeepAlive reports whether the client wants to send more requests on the
+// connection. HTTP/1.1 connections are persistent unless the client sends
+// Connection: close, while HTTP/1.0 ones need Connection: keep-alive.
+func (r *Request) KeepAlive() bool {
+	connection := r.Header("Connection")
+	if hasToken(connection, "close") {
+		return false
+	}
+	return r.proto == "HTTP/1.1" || hasToken(connection, "keep-alive")
+}
+
+// hasToken reports whether a comma-separated header value contains token.
+// Example input: keep-alive, Upgrade
+func hasToken(value, token string) bool {
+	for part := range strings.SplitSeq(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the raw query string of the request, without the leading ?.
+func (r *Request) Query() string {
+	return r.query
+}
+
+// PathValue returns the value of a path parameter of the route matching the
+// request, e.g. id for the pattern /users/{id}.
+func (r *Request) PathValue(name string) string {
+	return r.params[name]
+}
+
+// parse transforms the raw HTTP request into a Request object. Invalid
+// requests and requests over limits return a *requestError.
+func parse(reader *bufio.Reader, limits Limits) (*Request, error) {
 	var r Request
 
-	requestLine, err := reader.ReadString('\n')
+	budget := limits.MaxHeaderBytes
+	requestLine, err := readLine(reader, &budget)
 	if err != nil {
 		return nil, err
 	}
 	log.Println(requestLine)
 
-	r.method, r.path, err = parseRequestLine(requestLine)
+	r.method, r.path, r.proto, err = parseRequestLine(requestLine)
+	if err != nil {
+		return nil, err
+	}
+	r.path, r.query, _ = strings.Cut(r.path, "?")
+	r.path, err = decodePath(r.path)
 	if err != nil {
 		return nil, err
 	}
 
 	headers := make(map[string]string, 0)
-	for {
-		headerLine, err := reader.ReadString('\n')
+	for numHeaders := 0; ; numHeaders++ {
+		headerLine, err := readLine(reader, &budget)
 		if err != nil {
 			log.Println("error reading header:", err)
 			return nil, err
@@ -159,6 +249,9 @@ func parse(reader *bufio.Reader) (*Request, error) {
 		if headerLine == "\r\n" {
 			break
 		}
+		if numHeaders == limits.MaxHeaders {
+			return nil, errTooManyHeaders
+		}
 
 		key, value, err := parseHeader(headerLine)
 		if err != nil {
@@ -169,11 +262,36 @@ func parse(reader *bufio.Reader) (*Request, error) {
 	}
 	r.headers = headers
 
+	// HTTP/1.1 clients must send the host, so that a server can host several
+	// sites.
+	if r.proto == "HTTP/1.1" && r.Header("Host") == "" {
+		return nil, badRequest("missing host header")
+	}
+
+	// A chunked body ends with an empty chunk instead of having a length.
+	// Requests with both headers are rejected, since a proxy in front of the
+	// server could disagree on where the body ends.
+	transferEncoding := r.Header("Transfer-Encoding")
 	contentLength := r.Header("Content-Length")
-	if contentLength != "" {
-		length, err := strconv.Atoi(contentLength)
+	if transferEncoding != "" {
+		if contentLength != "" {
+			return nil, badRequest("both transfer encoding and content length are set")
+		}
+		if !isChunked(transferEncoding) {
+			return nil, &requestError{status: 501, msg: fmt.Sprintf("unsupported transfer encoding: %s", transferEncoding)}
+		}
+
+		r.body, r.trailers, err = readChunked(reader, limits)
 		if err != nil {
-			return nil, fmt.Errorf("invalid content length: %s", contentLength)
+			return nil, err
+		}
+	} else if contentLength != "" {
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return nil, badRequest("invalid content length: %s", contentLength)
+		}
+		if length > limits.MaxBodyBytes {
+			return nil, errBodyTooLarge
 		}
 
 		r.body = make([]byte, length)
@@ -185,26 +303,51 @@ func parse(reader *bufio.Reader) (*Request, error) {
 	return &r, nil
 }
 
+// isChunked reports whether chunked is the only transfer encoding, which is
+// the only one the server can decode.
+// Example input: chunked
+func isChunked(transferEncoding string) bool {
+	return strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked")
+}
+
 // parseRequestLine ...
 // Example input: GET / HTTP/1.1
-func parseRequestLine(requestLine string) (method Method, path string, err error) {
+func parseRequestLine(requestLine string) (method Method, path, proto string, err error) {
 	parts := strings.Split(strings.TrimSpace(requestLine), " ")
 	if len(parts) != 3 {
-		return "", "", fmt.Errorf("invalid method line: %s", requestLine)
+		return "", "", "", badRequest("invalid method line: %s", requestLine)
 	}
 
 	path = parts[1]
 	method, err = parseMethod(parts[0])
 	if err != nil {
-		return "", "", err
+		return "", "", "", badRequest("%v", err)
 	}
 
-	httpVersion := parts[2]
-	if httpVersion != "HTTP/1.1" {
-		return "", "", fmt.Errorf("unsupported HTTP version: %s", httpVersion)
+	proto = parts[2]
+	if proto != "HTTP/1.0" && proto != "HTTP/1.1" {
+		return "", "", "", &requestError{status: 505, msg: fmt.Sprintf("unsupported HTTP version: %s", proto)}
 	}
 
-	return method, path, nil
+	return method, path, proto, nil
+}
+
+// decodePath percent-decodes the path of a request. Encoded slashes are
+// rejected, since they'd become separators once decoded, and so are NUL bytes,
+// which can't be part of a file name.
+// Example input: /files/my%20notes.txt
+func decodePath(rawPath string) (string, error) {
+	if strings.Contains(strings.ToUpper(rawPath), "%2F") {
+		return "", badRequest("encoded slash in path: %s", rawPath)
+	}
+	p, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", badRequest("invalid path: %s", rawPath)
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", badRequest("NUL byte in path: %s", rawPath)
+	}
+	return p, nil
 }
 
 // parseMethod ...
@@ -220,35 +363,56 @@ func parseMethod(method string) (Method, error) {
 func parseHeader(headerLine string) (key, value string, err error) {
 	parts := strings.SplitN(headerLine, ":", 2)
 	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid header line: %s", headerLine)
+		return "", "", badRequest("invalid header line: %s", headerLine)
 	}
 
 	// HTTP header name is case-insensitive, so we'll normalize it to lowercase.
 	key = strings.ToLower(parts[0])
 	// HTTP header name does not allow preceding or trailing whitespace, so
 	if strings.Contains(key, " ") {
-		return "", "", fmt.Errorf("invalid header name: %s", key)
+		return "", "", badRequest("invalid header name: %s", key)
 	}
 
 	value = strings.TrimSpace(parts[1])
 	return key, value, nil
 }
 
-// writeResponse writes the HTTP response to the TCP connection.
-// If keepAlive is true, includes Connection: keep-alive header.
-func writeResponse(conn *net.TCPConn, status int, body []byte, keepAlive bool) error {
-	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
-	fmt.Fprintf(conn, "Content-Length: %d\r\n", len(body))
-	if keepAlive {
-		fmt.Fprintf(conn, "Connection: keep-alive\r\n")
-	} else {
-		fmt.Fprintf(conn, "Connection: close\r\n")
+// writeResponse writes the HTTP response to w, with the length of body. If
+// keepAlive is true, includes Connection: keep-alive header.
+func writeResponse(w *bufio.Writer, status int, header Header, body []byte, keepAlive bool) error {
+	header = maps.Clone(header)
+	header.Del("Transfer-Encoding")
+	if !bodyAllowed(status) {
+		header.Del("Content-Length")
+		writeHead(w, status, header, keepAlive)
+		return nil
 	}
-	fmt.Fprintf(conn, "\r\n")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	writeHead(w, status, header, keepAlive)
 
-	_, err := conn.Write(body)
+	_, err := w.Write(body)
 	if err != nil {
 		return fmt.Errorf("error writing response: %w", err)
 	}
 	return nil
 }
+
+// writeHead writes the status line and the headers of a response, sorted by
+// name. The Connection header is set from keepAlive.
+func writeHead(w *bufio.Writer, status int, header Header, keepAlive bool) {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for _, key := range slices.Sorted(maps.Keys(header)) {
+		if key == "Connection" {
+			continue
+		}
+		for _, value := range header[key] {
+			fmt.Fprintf(w, "%s: %s\r\n", key, value)
+		}
+	}
+	if keepAlive {
+		fmt.Fprintf(w, "Connection: keep-alive\r\n")
+	} else {
+		fmt.Fprintf(w, "Connection: close\r\n")
+	}
+	fmt.Fprintf(w, "\r\n")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestRequestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name       string
+		proto      string
+		connection string
+		expected   bool
+	}{
+		{"HTTP/1.1", "HTTP/1.1", "", true},
+		{"HTTP/1.1 close", "HTTP/1.1", "close", false},
+		{"HTTP/1.1 close in a list", "HTTP/1.1", "Upgrade, Close", false},
+		{"HTTP/1.1 keep-alive", "HTTP/1.1", "keep-alive", true},
+		{"HTTP/1.0", "HTTP/1.0", "", false},
+		{"HTTP/1.0 keep-alive", "HTTP/1.0", "Keep-Alive", true},
+		{"HTTP/1.0 keep-alive in a list", "HTTP/1.0", "foo, keep-alive", true},
+		{"HTTP/1.0 close", "HTTP/1.0", "close", false},
+		{"close wins", "HTTP/1.0", "keep-alive, close", false},
+		{"token substring", "HTTP/1.0", "keep-alive-please", false},
+	}
+
+	for _, tt := range tests {
+		r := &Request{proto: tt.proto, headers: map[string]string{}}
+		if tt.connection != "" {
+			r.headers["connection"] = tt.connection
+		}
+		if got := r.KeepAlive(); got != tt.expected {
+			t.Errorf("%s: expected KeepAlive() = %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := "POST /users?id=42 HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"
+	r, err := parse(bufio.NewReader(strings.NewReader(input)), defaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method() != MethodPost || r.Path() != "/users" || r.Query() != "id=42" || r.Proto() != "HTTP/1.1" {
+		t.Errorf("unexpected request line: %s %s ? %s %s", r.Method(), r.Path(), r.Query(), r.Proto())
+	}
+	if r.Header("host") != "example.com" {
+		t.Errorf("expected host example.com, got %q", r.Header("host"))
+	}
+	if string(r.Body()) != "hello" {
+		t.Errorf("expected body hello, got %q", r.Body())
+	}
+}
+
+func TestDecodePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"plain", "/files/a.txt", "/files/a.txt", true},
+		{"space", "/files/my%20notes.txt", "/files/my notes.txt", true},
+		{"utf-8", "/files/%E2%82%AC.txt", "/files/€.txt", true},
+		{"encoded dots", "/files/%2e%2e/secret", "/files/../secret", true},
+		{"encoded slash", "/files/a%2Fb", "", false},
+		{"lowercase encoded slash", "/files/..%2f..%2fetc/passwd", "", false},
+		{"NUL", "/files/a.txt%00.html", "", false},
+		{"invalid escape", "/files/100%", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := decodePath(tt.input)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: decodePath(%q) returned error %v", tt.name, tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
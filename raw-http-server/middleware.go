@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a handler to run code around it.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares, the first one being the outermost.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// statusRecorder records the status and size of a response for logging.
+type statusRecorder struct {
+	ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+func (w *statusRecorder) Reset() bool {
+	if !w.ResponseWriter.Reset() {
+		return false
+	}
+	w.status, w.size = 0, 0
+	return true
+}
+
+// Logging logs the method, path, status, size and duration of each request.
+func Logging(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = 200
+		}
+		log.Printf("%s %s %d %dB %s", r.Method(), r.Path(), status, rec.size, time.Since(start))
+	})
+}
+
+// Recovery replies 500 when the handler panics, instead of dropping the
+// connection. If the response was already flushed, it can't be replaced, so
+// the panic goes on and the server closes the connection.
+func Recovery(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if !w.Reset() {
+				panic(v)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method(), r.Path(), v, debug.Stack())
+			w.WriteHeader(500)
+			w.Write([]byte("internal server error"))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Timeout replies 503 when the handler takes longer than d. The context of
+// the request is canceled after d, so that the handler can stop. Responses
+// are buffered until the handler returns, so Flush has no effect.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{header: make(Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						panicked <- v
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				maps.Copy(w.Header(), tw.header)
+				if tw.status != 0 {
+					w.WriteHeader(tw.status)
+				}
+				w.Write(tw.body.Bytes())
+			case v := <-panicked:
+				// Panic in the goroutine of the request, so that Recovery can
+				// catch it.
+				panic(v)
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				w.WriteHeader(503)
+				fmt.Fprintf(w, "request timed out after %s", d)
+			}
+		})
+	}
+}
+
+// timeoutWriter buffers the response of a handler run by Timeout, and drops
+// the writes made after the timeout.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   Header
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, context.DeadlineExceeded
+	}
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.body.Write(p)
+}
+
+func (w *timeoutWriter) Flush() error {
+	return nil
+}
+
+func (w *timeoutWriter) Reset() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.header = make(Header)
+	w.status = 0
+	w.body.Reset()
+	return true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves handler on a local port until the test ends, and
+// returns the server and its address.
+func startServer(t *testing.T, handler Handler, limits Limits) (*Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(handler, limits)
+	go s.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s, listener.Addr().String()
+}
+
+// dial connects to addr, and fails the test if the connection blocks for
+// more than 5 seconds.
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// serve runs handler on req, and returns the response it wrote.
+func serve(t *testing.T, handler Handler, req *Request) *response {
+	t.Helper()
+	w := newResponse(bufio.NewWriter(io.Discard), req, true)
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := Chain(HandlerFunc(func(w ResponseWriter, r *Request) {
+		calls = append(calls, "handler")
+	}), middleware("first"), middleware("second"))
+
+	serve(t, handler, &Request{method: MethodGet, path: "/", proto: "HTTP/1.1"})
+	if got := strings.Join(calls, ","); got != "first,second,handler" {
+		t.Errorf("expected first,second,handler, got %s", got)
+	}
+}
+
+func TestRecoveryBeforeFlush(t *testing.T) {
+	handler := Recovery(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Partial", "true")
+		w.Write([]byte("partial"))
+		panic("something went wrong")
+	}))
+
+	w := serve(t, handler, &Request{method: MethodGet, path: "/", proto: "HTTP/1.1"})
+	if w.status != 500 {
+		t.Errorf("expected status 500, got %d", w.status)
+	}
+	if body := w.body.String(); body != "internal server error" {
+		t.Errorf("expected the partial response to be replaced, got body %q", body)
+	}
+	if w.Header().Get("X-Partial") != "" {
+		t.Errorf("expected the headers of the partial response to be dropped")
+	}
+}
+
+func TestRecoveryAfterFlush(t *testing.T) {
+	handler := Recovery(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("partial"))
+		w.Flush()
+		panic("something went wrong")
+	}))
+	_, addr := startServer(t, handler, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	// The response was already sent, so the connection is closed before the
+	// last chunk.
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+	if !strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("expected the flushed response, got %q", resp)
+	}
+	if strings.HasSuffix(string(resp), "0\r\n\r\n") {
+		t.Errorf("expected the body to be cut short, got %q", resp)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	handler := Timeout(50 * time.Millisecond)(HandlerFunc(func(w ResponseWriter, r *Request) {
+		<-r.Context().Done()
+		// Give the middleware time to reply before writing.
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(200)
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}))
+
+	w := serve(t, handler, &Request{method: MethodGet, path: "/", proto: "HTTP/1.1"})
+	if w.status != 503 {
+		t.Errorf("expected status 503, got %d", w.status)
+	}
+	if err := <-lateWrite; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the late write to fail, got %v", err)
+	}
+	if body := w.body.String(); !strings.HasPrefix(body, "request timed out") {
+		t.Errorf("expected the timeout message, got body %q", body)
+	}
+}
+
+func TestTimeoutInTime(t *testing.T) {
+	handler := Timeout(time.Second)(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(201)
+		w.Write([]byte("created"))
+	}))
+
+	w := serve(t, handler, &Request{method: MethodGet, path: "/", proto: "HTTP/1.1"})
+	if w.status != 201 || w.body.String() != "created" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected the response of the handler, got %d %q", w.status, w.body.String())
+	}
+}
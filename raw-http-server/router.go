@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Router dispatches requests to the handler of the first route whose method
+// and path pattern match.
+//
+// A pattern is a method followed by a path, e.g. "GET /users/{id}". Segments
+// in braces are parameters that match any single segment, and a last segment
+// like {path...} matches the rest of the path. A pattern without a method
+// matches every method.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   Method
+	segments []string
+	handler  Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern. It panics if pattern is invalid.
+func (rt *Router) Handle(pattern string, handler Handler) {
+	var r route
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	if method != "" {
+		m, err := parseMethod(method)
+		if err != nil {
+			panic(fmt.Sprintf("invalid pattern %q: %v", pattern, err))
+		}
+		r.method = m
+	}
+	if !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("invalid pattern %q: path must start with /", pattern))
+	}
+
+	r.segments = strings.Split(path[1:], "/")
+	for i, segment := range r.segments {
+		name, isParam := paramName(segment)
+		if !isParam {
+			continue
+		}
+		if name == "" {
+			panic(fmt.Sprintf("invalid pattern %q: empty parameter name", pattern))
+		}
+		if strings.HasSuffix(name, "...") && i != len(r.segments)-1 {
+			panic(fmt.Sprintf("invalid pattern %q: %s must be the last segment", pattern, segment))
+		}
+	}
+	r.handler = handler
+
+	rt.routes = append(rt.routes, r)
+}
+
+// HandleFunc registers a function as the handler for pattern.
+func (rt *Router) HandleFunc(pattern string, handler func(w ResponseWriter, r *Request)) {
+	rt.Handle(pattern, HandlerFunc(handler))
+}
+
+// ServeHTTP calls the handler of the route matching the request. It replies
+// 405 with the allowed methods if only the path matches, and 404 if nothing
+// does.
+func (rt *Router) ServeHTTP(w ResponseWriter, r *Request) {
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := route.match(r.Path())
+		if !ok {
+			continue
+		}
+		if !route.allows(r.Method()) {
+			allowed = append(allowed, string(route.method))
+			continue
+		}
+
+		r.params = params
+		route.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(slices.Compact(allowed), ", "))
+		w.WriteHeader(405)
+		w.Write([]byte("method not allowed"))
+		return
+	}
+
+	w.WriteHeader(404)
+	w.Write([]byte("not found"))
+}
+
+// allows reports whether the route handles method. GET routes also handle
+// HEAD, since the server drops the body of HEAD responses.
+func (r route) allows(method Method) bool {
+	return r.method == "" || r.method == method || (r.method == MethodGet && method == MethodHead)
+}
+
+// match reports whether path matches the pattern of the route, and returns
+// the values of its parameters.
+func (r route) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	segments := strings.Split(path[1:], "/")
+
+	params := make(map[string]string)
+	for i, pattern := range r.segments {
+		name, isParam := paramName(pattern)
+		if isParam && strings.HasSuffix(name, "...") && i <= len(segments) {
+			params[strings.TrimSuffix(name, "...")] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case isParam && segments[i] != "":
+			params[name] = segments[i]
+		case pattern != segments[i]:
+			return nil, false
+		}
+	}
+
+	return params, len(segments) == len(r.segments)
+}
+
+// paramName returns the name of the parameter of a pattern segment, e.g. id
+// for {id}.
+func paramName(segment string) (string, bool) {
+	if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		params  map[string]string
+		ok      bool
+	}{
+		{"root", "/", "/", map[string]string{}, true},
+		{"static", "/users", "/users", map[string]string{}, true},
+		{"static mismatch", "/users", "/posts", nil, false},
+		{"trailing slash", "/users", "/users/", nil, false},
+		{"param", "/users/{id}", "/users/42", map[string]string{"id": "42"}, true},
+		{"empty param", "/users/{id}", "/users/", nil, false},
+		{"too many segments", "/users/{id}", "/users/42/posts", nil, false},
+		{"too few segments", "/users/{id}/posts", "/users/42", nil, false},
+		{"two params", "/users/{id}/posts/{post}", "/users/42/posts/7", map[string]string{"id": "42", "post": "7"}, true},
+		{"rest", "/files/{path...}", "/files/a/b.txt", map[string]string{"path": "a/b.txt"}, true},
+		{"empty rest", "/files/{path...}", "/files/", map[string]string{"path": ""}, true},
+		{"rest without slash", "/files/{path...}", "/files", map[string]string{"path": ""}, true},
+		{"rest mismatch", "/files/{path...}", "/static/a", nil, false},
+		{"relative path", "/users", "users", nil, false},
+	}
+
+	for _, tt := range tests {
+		rt := NewRouter()
+		rt.HandleFunc(tt.pattern, func(w ResponseWriter, r *Request) {})
+		params, ok := rt.routes[0].match(tt.path)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(params, tt.params)) {
+			t.Errorf("%s: match(%q) of %q = %v, %v, want %v, %v", tt.name, tt.path, tt.pattern, params, ok, tt.params, tt.ok)
+		}
+	}
+}
+
+func TestRouterStatus(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleFunc("GET /users/{id}", func(w ResponseWriter, r *Request) {
+		w.Write([]byte(r.PathValue("id")))
+	})
+	rt.HandleFunc("DELETE /users/{id}", func(w ResponseWriter, r *Request) {})
+
+	tests := []struct {
+		name   string
+		method Method
+		path   string
+		status int
+		allow  string
+	}{
+		{"match", MethodGet, "/users/42", 200, ""},
+		{"head of a get route", MethodHead, "/users/42", 200, ""},
+		{"wrong method", MethodPost, "/users/42", 405, "DELETE, GET"},
+		{"no route", MethodGet, "/posts/42", 404, ""},
+	}
+
+	for _, tt := range tests {
+		req := &Request{method: tt.method, path: tt.path, proto: "HTTP/1.1"}
+		w := newResponse(bufio.NewWriter(io.Discard), req, false)
+		rt.ServeHTTP(w, req)
+		if w.status != tt.status || w.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s: %s %s replied %d with Allow %q, want %d with Allow %q",
+				tt.name, tt.method, tt.path, w.status, w.Header().Get("Allow"), tt.status, tt.allow)
+		}
+	}
+}
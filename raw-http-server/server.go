@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the in-flight
+// requests are done.
+const shutdownPollInterval = 100 * time.Millisecond
+
+var errServerClosed = errors.New("server closed")
+
+// Server serves HTTP/1.x requests on a listener, until it's shut down.
+type Server struct {
+	Handler Handler
+	Limits  Limits
+
+	mu       sync.Mutex
+	listener net.Listener
+	// conns tracks the open connections, and whether they're idle, i.e.
+	// waiting for a request.
+	conns        map[net.Conn]bool
+	shuttingDown bool
+}
+
+func NewServer(handler Handler, limits Limits) *Server {
+	return &Server{
+		Handler: handler,
+		Limits:  limits,
+		conns:   make(map[net.Conn]bool),
+	}
+}
+
+// newTLSConfig loads a certificate and its key, and advertises http/1.1 with
+// ALPN, so that clients don't try to negotiate HTTP/2.
+func newTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Serve accepts connections on listener and serves them until Shutdown is
+// called, and then returns errServerClosed.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		listener.Close()
+		return errServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isShuttingDown() {
+				return errServerClosed
+			}
+			log.Println("error accepting connection:", err)
+			continue
+		}
+
+		log.Println("connection accepted")
+		s.trackConn(conn, true)
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections, closes the idle ones, and waits for
+// the in-flight requests to finish, closing their connection once they're
+// answered. If ctx is done first, the remaining connections are closed and
+// the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			log.Printf("closing %d connections with requests in flight", len(s.conns))
+			for conn := range s.conns {
+				conn.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes the idle connections, and reports whether no
+// connection is left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// trackConn records whether conn is idle. It reports false if the server is
+// shutting down and conn is idle, in which case conn must be closed.
+func (s *Server) trackConn(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown && idle {
+		return false
+	}
+	s.conns[conn] = idle
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// serveConn serves the requests sent on conn, one at a time, so that the
+// responses are sent in the order of the requests even when the client
+// pipelines them.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		// A handler that panics after flushing its response leaves the
+		// connection in an unknown state, so it's closed.
+		if v := recover(); v != nil {
+			log.Println("panic serving request:", v)
+		}
+
+		s.untrackConn(conn)
+		err := conn.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Println("error closing connection:", err)
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		// The deadline is set before each request, so that a persistent
+		// connection stays open as long as the client keeps sending requests,
+		// but not while it's idle. A pipelined request is already buffered.
+		if r.Buffered() == 0 {
+			if !s.trackConn(conn, true) {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(s.Limits.IdleTimeout))
+			if _, err := r.Peek(1); err != nil {
+				if err == io.EOF {
+					log.Println("client closed connection")
+				} else {
+					log.Println("closing idle connection:", err)
+				}
+				return
+			}
+		}
+		if !s.trackConn(conn, false) {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.Limits.ReadTimeout))
+
+		req, err := parse(r, s.Limits)
+		if err != nil {
+			log.Println("error parsing request:", err)
+
+			// Invalid requests are answered before closing the connection,
+			// since the rest of the stream can't be parsed.
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				header := Header{}
+				header.Set("Content-Type", "text/plain; charset=utf-8")
+				writeResponse(w, reqErr.status, header, []byte(reqErr.msg), false)
+				w.Flush()
+			}
+			return
+		}
+		// The handler may take longer than the read timeout.
+		conn.SetReadDeadline(time.Time{})
+
+		// Once the server is shutting down, the connection is closed after
+		// the response.
+		resp := newResponse(w, req, req.KeepAlive() && !s.isShuttingDown())
+		s.Handler.ServeHTTP(resp, req)
+		if s.isShuttingDown() {
+			// The shutdown started while the handler ran.
+			resp.keepAlive = false
+		}
+		err = resp.finish()
+		if err == nil && (resp.closeConnection() || r.Buffered() == 0) {
+			// The responses to the pipelined requests that are already
+			// buffered are sent together.
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Println("error writing response:", err)
+			return
+		}
+
+		// The connection is closed if the request or the response asked for
+		// it, if the client closes the connection, or if an error occurs.
+		if resp.closeConnection() {
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoPath replies with the path of the request.
+var echoPath = HandlerFunc(func(w ResponseWriter, r *Request) {
+	w.Write([]byte(r.Path()))
+})
+
+// readResponse reads a response with a Content-Length, and returns its head
+// and body.
+func readResponse(t *testing.T, r *bufio.Reader) (head, body string) {
+	t.Helper()
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		head += line
+		if line == "\r\n" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				t.Fatalf("invalid content length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		t.Fatalf("response without Content-Length: %q", head)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("error reading body: %v", err)
+	}
+	return head, string(buf)
+}
+
+// expectClosed fails the test unless the server closed conn.
+func expectClosed(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	if rest, err := io.ReadAll(r); err != nil || len(rest) > 0 {
+		t.Errorf("expected the connection to be closed, got %q, %v", rest, err)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// The first request is the slowest, so its response would come last if
+	// the requests were served concurrently.
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Path() == "/first" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		echoPath(w, r)
+	})
+	_, addr := startServer(t, handler, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"/first", "/second"} {
+		head, body := readResponse(t, r)
+		if body != want {
+			t.Errorf("expected the response to %s, got %q", want, body)
+		}
+		if !strings.Contains(head, "Connection: keep-alive\r\n") {
+			t.Errorf("expected the connection to stay open, got %q", head)
+		}
+	}
+}
+
+func TestHTTP10ClosesConnection(t *testing.T) {
+	_, addr := startServer(t, echoPath, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /old HTTP/1.0\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	head, body := readResponse(t, r)
+	if body != "/old" || !strings.Contains(head, "Connection: close\r\n") {
+		t.Errorf("expected /old with Connection: close, got %q %q", head, body)
+	}
+	expectClosed(t, r)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := defaultLimits
+	limits.MaxHeaderBytes = 256
+	limits.MaxBodyBytes = 16
+
+	tests := []struct {
+		name    string
+		request string
+		status  string
+	}{
+		{"header block over the limit",
+			"GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("x", 300) + "\r\n\r\n",
+			"HTTP/1.1 431 Request Header Fields Too Large\r\n"},
+		{"content length over the limit",
+			"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\n" + strings.Repeat("x", 17),
+			"HTTP/1.1 413 Request Entity Too Large\r\n"},
+		{"invalid content length",
+			"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: ten\r\n\r\n",
+			"HTTP/1.1 400 Bad Request\r\n"},
+		{"missing host",
+			"GET / HTTP/1.1\r\n\r\n",
+			"HTTP/1.1 400 Bad Request\r\n"},
+	}
+
+	_, addr := startServer(t, echoPath, limits)
+	for _, tt := range tests {
+		conn := dial(t, addr)
+		io.WriteString(conn, tt.request)
+
+		r := bufio.NewReader(conn)
+		head, _ := readResponse(t, r)
+		if !strings.HasPrefix(head, tt.status) || !strings.Contains(head, "Connection: close\r\n") {
+			t.Errorf("%s: expected %q and Connection: close, got %q", tt.name, tt.status, head)
+		}
+		expectClosed(t, r)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	limits := defaultLimits
+	limits.IdleTimeout = 50 * time.Millisecond
+	_, addr := startServer(t, echoPath, limits)
+
+	// The idle deadline applies again after each response.
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r := bufio.NewReader(conn)
+	if _, body := readResponse(t, r); body != "/a" {
+		t.Errorf("expected /a, got %q", body)
+	}
+	expectClosed(t, r)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	s, addr := startServer(t, echoPath, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r := bufio.NewReader(conn)
+	if head, _ := readResponse(t, r); !strings.Contains(head, "Connection: keep-alive\r\n") {
+		t.Fatalf("expected a persistent connection, got %q", head)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("expected Shutdown to succeed, got %v", err)
+	}
+	expectClosed(t, r)
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	s, addr := startServer(t, handler, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+	// Shutdown keeps waiting while the request is in flight.
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(2 * shutdownPollInterval):
+	}
+	close(release)
+
+	r := bufio.NewReader(conn)
+	head, body := readResponse(t, r)
+	if body != "done" || !strings.Contains(head, "Connection: close\r\n") {
+		t.Errorf("expected the response with Connection: close, got %q %q", head, body)
+	}
+	expectClosed(t, r)
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("expected Shutdown to succeed, got %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		<-release
+	})
+	s, addr := startServer(t, handler, defaultLimits)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*shutdownPollInterval)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Shutdown to return the error of ctx, got %v", err)
+	}
+	// The connection is closed without a response.
+	if resp, err := io.ReadAll(conn); len(resp) > 0 {
+		t.Errorf("expected the connection to be closed, got %q, %v", resp, err)
+	}
+}